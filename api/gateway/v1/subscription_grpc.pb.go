@@ -0,0 +1,173 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package gatewayv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// SubscriptionClient is the client API for Subscription service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SubscriptionClient interface {
+	// Subscribe streams the state of the selected resources as NEW messages followed by Synced,
+	// then the live messages. With a resume token still held by the replay buffer of the monitor
+	// the missed messages are streamed instead of the state.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Subscription_SubscribeClient, error)
+	// GetState returns the state of the selected resources.
+	GetState(ctx context.Context, in *GetStateRequest, opts ...grpc.CallOption) (*GetStateResponse, error)
+}
+
+type subscriptionClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSubscriptionClient(cc grpc.ClientConnInterface) SubscriptionClient {
+	return &subscriptionClient{cc}
+}
+
+func (c *subscriptionClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Subscription_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Subscription_ServiceDesc.Streams[0], "/fusionapp.gateway.v1.Subscription/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &subscriptionSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Subscription_SubscribeClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type subscriptionSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *subscriptionSubscribeClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *subscriptionClient) GetState(ctx context.Context, in *GetStateRequest, opts ...grpc.CallOption) (*GetStateResponse, error) {
+	out := new(GetStateResponse)
+	err := c.cc.Invoke(ctx, "/fusionapp.gateway.v1.Subscription/GetState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SubscriptionServer is the server API for Subscription service.
+// All implementations must embed UnimplementedSubscriptionServer
+// for forward compatibility
+type SubscriptionServer interface {
+	// Subscribe streams the state of the selected resources as NEW messages followed by Synced,
+	// then the live messages. With a resume token still held by the replay buffer of the monitor
+	// the missed messages are streamed instead of the state.
+	Subscribe(*SubscribeRequest, Subscription_SubscribeServer) error
+	// GetState returns the state of the selected resources.
+	GetState(context.Context, *GetStateRequest) (*GetStateResponse, error)
+	mustEmbedUnimplementedSubscriptionServer()
+}
+
+// UnimplementedSubscriptionServer must be embedded to have forward compatible implementations.
+type UnimplementedSubscriptionServer struct {
+}
+
+func (UnimplementedSubscriptionServer) Subscribe(*SubscribeRequest, Subscription_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedSubscriptionServer) GetState(context.Context, *GetStateRequest) (*GetStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetState not implemented")
+}
+func (UnimplementedSubscriptionServer) mustEmbedUnimplementedSubscriptionServer() {}
+
+// UnsafeSubscriptionServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SubscriptionServer will
+// result in compilation errors.
+type UnsafeSubscriptionServer interface {
+	mustEmbedUnimplementedSubscriptionServer()
+}
+
+func RegisterSubscriptionServer(s grpc.ServiceRegistrar, srv SubscriptionServer) {
+	s.RegisterService(&Subscription_ServiceDesc, srv)
+}
+
+func _Subscription_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SubscriptionServer).Subscribe(m, &subscriptionSubscribeServer{stream})
+}
+
+type Subscription_SubscribeServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type subscriptionSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *subscriptionSubscribeServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Subscription_GetState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriptionServer).GetState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fusionapp.gateway.v1.Subscription/GetState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriptionServer).GetState(ctx, req.(*GetStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Subscription_ServiceDesc is the grpc.ServiceDesc for Subscription service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Subscription_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fusionapp.gateway.v1.Subscription",
+	HandlerType: (*SubscriptionServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetState",
+			Handler:    _Subscription_GetState_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Subscription_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gateway/v1/subscription.proto",
+}
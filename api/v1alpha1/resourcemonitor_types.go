@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -32,6 +33,31 @@ type ResourceMonitorSpec struct {
 	Selector       SelectorSpec `json:"selector"`
 	MsgBuilder     MsgBuilder   `json:"msgBuilder"`
 	MsgBackendSpec `json:",inline"`
+	RateLimit      *RateLimitSpec `json:"rateLimit,omitempty"`
+	// Checkpoint persists the dedup state, so a restarted gateway only publishes real differences
+	Checkpoint *CheckpointSpec `json:"checkpoint,omitempty"`
+}
+
+// CheckpointSpec configures where the checkpoint is written, exactly one of ConfigMap, Secret or Path
+type CheckpointSpec struct {
+	// ConfigMap in the monitor namespace, created and owned by the gateway
+	ConfigMap string `json:"configMap,omitempty"`
+	// Secret in the monitor namespace, created and owned by the gateway
+	Secret string `json:"secret,omitempty"`
+	// Path of a file on a local volume
+	Path string `json:"path,omitempty"`
+	// Interval between two writes, defaults to 10s
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// RateLimitSpec throttles the Update messages of a monitor, other messages are never delayed
+type RateLimitSpec struct {
+	// DebounceWindow coalesces the updates of one resource into a single message carrying the latest state
+	DebounceWindow *metav1.Duration `json:"debounceWindow,omitempty"`
+	// MaxRate is the maximum number of Update messages per second sent to the backend
+	MaxRate float64 `json:"maxRate,omitempty"`
+	// Burst defaults to 1
+	Burst int `json:"burst,omitempty"`
 }
 
 type SelectorSpec struct {
@@ -45,16 +71,147 @@ type MsgBuilder struct {
 	Type      ChangeFilterType `json:"type"`
 	Format    *MsgFormat       `json:"format"`
 	MsgSource `json:",inline"`
+	// Metrics polled from the metric source, defaults depend on the source and the selected kind
+	Metrics []MetricSpec `json:"metrics,omitempty"`
+	// IgnorePaths are JSON pointers or JSONPaths of fields which never count as a change,
+	// e.g. "/metadata/labels/foo" or "status.conditions[*].lastTransitionTime"
+	IgnorePaths []string `json:"ignorePaths,omitempty"`
+	// DisableDefaultIgnorePaths stops ignoring resourceVersion, managedFields and condition heartbeats
+	DisableDefaultIgnorePaths bool `json:"disableDefaultIgnorePaths,omitempty"`
+	// StripIgnoredPaths removes the ignored fields from the published payload as well
+	StripIgnoredPaths bool `json:"stripIgnoredPaths,omitempty"`
+	// Transitions are watched fields, a Transition message is published whenever one changes
+	Transitions []TransitionSpec `json:"transitions,omitempty"`
+	// TransitionsOnly publishes the Transition messages instead of Update messages for object changes
+	TransitionsOnly bool `json:"transitionsOnly,omitempty"`
+	// Filter narrows the published objects and changes with CEL expressions
+	Filter *FilterSpec `json:"filter,omitempty"`
+	// Transform renders the payload of New, Update and Delete messages instead of the object
+	Transform *TransformSpec `json:"transform,omitempty"`
+}
+
+// TransformSpec renders the payload from `object`, `metrics`, `meta` and `op`, e.g. the jq program
+// "{phase: .object.status.phase, cpu: .metrics.cpu_use}". Only one of JQ and Template can be set.
+type TransformSpec struct {
+	// JQ is a jq program producing a single value, published as JSON
+	JQ string `json:"jq,omitempty"`
+	// Template is a Go text/template, the json function encodes a value as JSON
+	Template string `json:"template,omitempty"`
+}
+
+// FilterSpec holds CEL expressions evaluating to a bool, an empty expression lets everything through.
+type FilterSpec struct {
+	// Object is evaluated against `object`, only the matching objects are published,
+	// e.g. "object.status.phase != 'Succeeded'"
+	Object string `json:"object,omitempty"`
+	// Update is evaluated against `object` and `oldObject`, only the matching changes are published,
+	// e.g. "object.spec.replicas != oldObject.spec.replicas"
+	Update string `json:"update,omitempty"`
+}
+
+// TransitionSpec watches either a field or the status of a condition.
+type TransitionSpec struct {
+	// Name identifies the transition in the messages, defaults to the condition type or the path
+	Name string `json:"name,omitempty"`
+	// Path is a JSONPath of the watched field, e.g. "status.phase"
+	Path string `json:"path,omitempty"`
+	// ConditionType watches the status of the status.conditions entry of that type, e.g. "Ready"
+	ConditionType string `json:"conditionType,omitempty"`
 }
 
+// MsgSource configures at most one metric source, a monitor without source only publishes object changes
 type MsgSource struct {
-	PrometheusSource *PrometheusDataSource `json:"prometheus_source,omitempty"`
+	PrometheusSource      *PrometheusDataSource      `json:"prometheus_source,omitempty"`
+	ResourceMetricsSource *ResourceMetricsDataSource `json:"resource_metrics_source,omitempty"`
+	ScrapeSource          *ScrapeDataSource          `json:"scrape_source,omitempty"`
+}
+
+type MetricSpec struct {
+	// Field is the key of the metric in the message extras
+	Field string `json:"field"`
+	// Metric is the metric name, "cpu" or "memory" for the metrics.k8s.io source
+	Metric string `json:"metric"`
+	// Policy decides when a new value is published, every change is published by default
+	Policy *MetricPolicy `json:"policy,omitempty"`
+}
+
+type MetricPolicy struct {
+	// Deadband is the minimum relative change against the last published value, e.g. 0.05 for 5%
+	Deadband float64 `json:"deadband,omitempty"`
+	// MinInterval is the minimum time between two published values
+	MinInterval *metav1.Duration `json:"minInterval,omitempty"`
+	// AlertOnly disables value updates, only Alert messages are published
+	AlertOnly  bool            `json:"alertOnly,omitempty"`
+	Thresholds []ThresholdRule `json:"thresholds,omitempty"`
 }
 
+// ThresholdRule fires an Alert once the value has crossed the bound for the configured duration
+type ThresholdRule struct {
+	Name     string            `json:"name"`
+	Operator ThresholdOperator `json:"operator"`
+	Value    float64           `json:"value"`
+	For      *metav1.Duration  `json:"for,omitempty"`
+	Severity string            `json:"severity,omitempty"`
+}
+
+type ThresholdOperator string
+
+const (
+	GreaterThan      ThresholdOperator = ">"
+	GreaterOrEqualTo ThresholdOperator = ">="
+	LessThan         ThresholdOperator = "<"
+	LessOrEqualTo    ThresholdOperator = "<="
+)
+
 type PrometheusDataSource struct {
 	Scheme string `json:"scheme"`
 	Host   string `json:"host"`
 	Port   int    `json:"port"`
+	// Interval between two polls, defaults to 20s
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// NamespaceLabel is the label matched against the object namespace, defaults to "exported_namespace"
+	NamespaceLabel string `json:"namespaceLabel,omitempty"`
+	// NameLabel is the label matched against the object name, defaults to "name"
+	NameLabel string `json:"nameLabel,omitempty"`
+	// LabelMapping maps additional label names to JSONPaths of the object, e.g. {"node": "{.status.nodeName}"}
+	LabelMapping map[string]string `json:"labelMapping,omitempty"`
+	// BearerToken is read from a Secret in the monitor namespace
+	BearerToken *corev1.SecretKeySelector `json:"bearerToken,omitempty"`
+	BasicAuth   *BasicAuth                `json:"basicAuth,omitempty"`
+	TLSConfig   *TLSConfig                `json:"tlsConfig,omitempty"`
+}
+
+// ResourceMetricsDataSource reads PodMetrics and NodeMetrics from the metrics.k8s.io API
+type ResourceMetricsDataSource struct {
+	// Interval between two polls, defaults to 20s
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// ScrapeDataSource scrapes a Prometheus format endpoint exposed by the selected object
+type ScrapeDataSource struct {
+	Scheme string `json:"scheme,omitempty"`
+	Port   int    `json:"port"`
+	// Path defaults to /metrics
+	Path string `json:"path,omitempty"`
+	// Address is the JSONPath of the object address, defaults to the Pod IP, Service cluster IP
+	// or the first VirtualMachineInstance interface
+	Address string `json:"address,omitempty"`
+	// Interval between two polls, defaults to 20s
+	Interval    *metav1.Duration          `json:"interval,omitempty"`
+	BearerToken *corev1.SecretKeySelector `json:"bearerToken,omitempty"`
+	TLSConfig   *TLSConfig                `json:"tlsConfig,omitempty"`
+}
+
+type BasicAuth struct {
+	Username corev1.SecretKeySelector `json:"username"`
+	Password corev1.SecretKeySelector `json:"password"`
+}
+
+type TLSConfig struct {
+	// CA is the PEM encoded CA bundle used to verify the server
+	CA                 *corev1.SecretKeySelector `json:"ca,omitempty"`
+	ServerName         string                    `json:"serverName,omitempty"`
+	InsecureSkipVerify bool                      `json:"insecureSkipVerify,omitempty"`
 }
 
 type MsgFormat struct {
@@ -70,15 +227,233 @@ const (
 )
 
 type MsgBackendSpec struct {
-	MQTTBackend *MQTTBackendSpec `json:"mqttBackend,omitempty"`
+	MQTTBackend        *MQTTBackendSpec        `json:"mqttBackend,omitempty"`
+	WebhookBackend     *WebhookBackendSpec     `json:"webhookBackend,omitempty"`
+	NATSBackend        *NATSBackendSpec        `json:"natsBackend,omitempty"`
+	AMQPBackend        *AMQPBackendSpec        `json:"amqpBackend,omitempty"`
+	RedisStreamBackend *RedisStreamBackendSpec `json:"redisStreamBackend,omitempty"`
+}
+
+// RedisStreamBackendSpec XADDs the messages to a Redis stream with the fields op, schema_id,
+// namespace, name, uid, seq, reason, content_type and payload. The Secrets are read from the
+// monitor namespace.
+type RedisStreamBackendSpec struct {
+	// Address is host:port
+	Address  string                    `json:"address"`
+	DB       int                       `json:"db,omitempty"`
+	Password *corev1.SecretKeySelector `json:"password,omitempty"`
+	// Stream is a template like the subject of the NATS backend, e.g. per monitor
+	// "k8s:{{.MonitorNamespace}}:{{.MonitorName}}" or per kind "k8s:{{.Kind}}"
+	Stream string `json:"stream"`
+	// MaxLen trims the stream to about MaxLen entries (MAXLEN ~), unbounded if 0
+	MaxLen int64 `json:"maxLen,omitempty"`
+	// LatestHash is a template of a hash holding the latest payload of each resource in the field
+	// <namespace>/<name>, the field is removed on Delete. The stream and the hash are written in
+	// one transaction, a consumer reads both in one MULTI to bootstrap and then tails the stream.
+	LatestHash string     `json:"latestHash,omitempty"`
+	TLSConfig  *TLSConfig `json:"tlsConfig,omitempty"`
+	// Envelope wrapping the payloads, Redis has no headers so the binary CloudEvents mode falls
+	// back to the structured one
+	Envelope *EnvelopeSpec `json:"envelope,omitempty"`
+	// Encoding of the message payloads
+	Encoding *EncodingSpec `json:"encoding,omitempty"`
+}
+
+// AMQPBackendSpec publishes persistent messages to an AMQP 0-9-1 exchange with publisher confirms,
+// the Secrets are read from the monitor namespace.
+type AMQPBackendSpec struct {
+	// URL of the broker, e.g. amqp://rabbitmq:5672/vhost, amqps:// for TLS
+	URL         string     `json:"url"`
+	Credentials *BasicAuth `json:"credentials,omitempty"`
+	Exchange    string     `json:"exchange"`
+	// RoutingKey is a template like the subject of the NATS backend, e.g.
+	// "{{lower .Kind}}.{{.Namespace}}.{{.Name}}.{{lower .Op}}"
+	RoutingKey string `json:"routingKey"`
+	// DeclareExchange declares a durable exchange of ExchangeType
+	DeclareExchange bool `json:"declareExchange,omitempty"`
+	// ExchangeType defaults to topic
+	ExchangeType string `json:"exchangeType,omitempty"`
+	// ConfirmTimeout defaults to 5s
+	ConfirmTimeout *metav1.Duration `json:"confirmTimeout,omitempty"`
+	TLSConfig      *TLSConfig       `json:"tlsConfig,omitempty"`
+	// Envelope wrapping the messages, the binary CloudEvents mode uses cloudEvents_ headers
+	Envelope *EnvelopeSpec `json:"envelope,omitempty"`
+	// Encoding of the message payloads
+	Encoding *EncodingSpec `json:"encoding,omitempty"`
+}
+
+// NATSBackendSpec publishes the messages to NATS, the Secrets are read from the monitor namespace.
+type NATSBackendSpec struct {
+	// Servers are the URLs of the cluster, e.g. nats://nats:4222
+	Servers []string `json:"servers"`
+	// Subject is a Go template of the message with the fields Op, Kind, Namespace, Name,
+	// MonitorNamespace, MonitorName and GatewayID and the functions lower and upper, e.g.
+	// "k8s.{{.Kind}}.{{.Namespace}}.{{.Name}}". Empty values and the characters reserved in
+	// subjects become "_".
+	Subject string `json:"subject"`
+	// Credentials is a user credentials file with the JWT and the NKey seed
+	Credentials *corev1.SecretKeySelector `json:"credentials,omitempty"`
+	// NKey is an NKey seed of the user
+	NKey      *corev1.SecretKeySelector `json:"nkey,omitempty"`
+	TLSConfig *TLSConfig                `json:"tlsConfig,omitempty"`
+	// JetStream waits for the acks of a stream, the messages carry a de-duplication ID derived
+	// from the resource UID and the sequence number
+	JetStream *JetStreamSpec `json:"jetStream,omitempty"`
+	// Envelope wrapping the messages, the binary CloudEvents mode uses ce- headers
+	Envelope *EnvelopeSpec `json:"envelope,omitempty"`
+	// Encoding of the message payloads
+	Encoding *EncodingSpec `json:"encoding,omitempty"`
+}
+
+type JetStreamSpec struct {
+	// Stream the subjects are expected to be stored in, not checked if empty
+	Stream string `json:"stream,omitempty"`
+	// AckTimeout defaults to 5s
+	AckTimeout *metav1.Duration `json:"ackTimeout,omitempty"`
+}
+
+// WebhookBackendSpec POSTs the messages to an HTTP endpoint, the Secrets are read from the monitor
+// namespace.
+type WebhookBackendSpec struct {
+	URL string `json:"url"`
+	// HeadersSecret is a Secret whose keys and values are sent as headers
+	HeadersSecret string `json:"headersSecret,omitempty"`
+	// SigningKey signs the requests with HMAC-SHA256, see the X-Gateway-Signature header
+	SigningKey *corev1.SecretKeySelector `json:"signingKey,omitempty"`
+	// Timeout of a request, defaults to 10s
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// MaxRetries of a request failed with a network error, 429 or 5xx, defaults to 3
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	// MinBackoff before the first retry, doubled for each retry, defaults to 500ms
+	MinBackoff *metav1.Duration `json:"minBackoff,omitempty"`
+	// MaxBackoff between two retries, defaults to 30s, it also caps the delay of a Retry-After header
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+	// Batch sends the JSON messages as arrays
+	Batch      *WebhookBatchSpec `json:"batch,omitempty"`
+	DeadLetter *DeadLetterSpec   `json:"deadLetter,omitempty"`
+	TLSConfig  *TLSConfig        `json:"tlsConfig,omitempty"`
+	// Envelope wrapping the messages, the binary CloudEvents mode uses ce- headers
+	Envelope *EnvelopeSpec `json:"envelope,omitempty"`
+	// Encoding of the message payloads
+	Encoding *EncodingSpec `json:"encoding,omitempty"`
+}
+
+// WebhookBatchSpec collects messages until MaxSize or MaxWait is reached. Messages which aren't
+// JSON or carry binary CloudEvents headers are sent alone.
+type WebhookBatchSpec struct {
+	MaxSize int `json:"maxSize"`
+	// MaxWait defaults to 1s
+	MaxWait *metav1.Duration `json:"maxWait,omitempty"`
+}
+
+// DeadLetterSpec receives the messages which couldn't be delivered, exactly one of URL or Path
+type DeadLetterSpec struct {
+	// URL the dead letters are POSTed to once
+	URL string `json:"url,omitempty"`
+	// Path of a file the dead letters are appended to as JSON lines
+	Path string `json:"path,omitempty"`
 }
 
 type MQTTBackendSpec struct {
+	// Host "local" targets the MQTT broker embedded in the gateway, the port and the credentials
+	// are ignored then
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
 	Topic    string `json:"topic"`
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+	// StateTopic is a template like the subject of the NATS backend, the New and Update messages
+	// are also published retained on it and the retained message is cleared on Delete, so a
+	// consumer gets the latest state of each resource when subscribing. Defaults to
+	// <topic>/state/{{.Kind}}/{{.Namespace}}/{{.Name}} on the local broker, ignored with Sparkplug
+	StateTopic string `json:"stateTopic,omitempty"`
+	// Envelope wrapping the messages, MQTT 3.1.1 has no headers so the binary CloudEvents mode
+	// falls back to the structured one
+	Envelope *EnvelopeSpec `json:"envelope,omitempty"`
+	// Encoding of the message payloads
+	Encoding *EncodingSpec `json:"encoding,omitempty"`
+	// Sparkplug publishes Sparkplug B messages instead, the topic, envelope and encoding are ignored
+	Sparkplug *SparkplugSpec `json:"sparkplug,omitempty"`
+	// Presence announces the gateway and the monitors on a status topic, ignored with Sparkplug
+	Presence *PresenceSpec `json:"presence,omitempty"`
+}
+
+// PresenceSpec publishes a retained birth message on connect and registers a retained last will,
+// the will is also published before a clean shutdown.
+type PresenceSpec struct {
+	// Topic defaults to <topic>/status/<gateway ID>, the heartbeats of a monitor go to
+	// <Topic>/<monitor namespace>/<monitor name>
+	Topic string `json:"topic,omitempty"`
+	// BirthMessage replaces the JSON online status
+	BirthMessage string `json:"birthMessage,omitempty"`
+	// WillMessage replaces the JSON offline status
+	WillMessage string `json:"willMessage,omitempty"`
+	// HeartbeatInterval of the monitors, no heartbeats if unset
+	HeartbeatInterval *metav1.Duration `json:"heartbeatInterval,omitempty"`
+}
+
+// SparkplugSpec makes the gateway a Sparkplug B edge node, each selected resource is a device.
+type SparkplugSpec struct {
+	GroupID string `json:"groupID"`
+	// EdgeNodeID defaults to the gateway ID
+	EdgeNodeID string `json:"edgeNodeID,omitempty"`
+}
+
+type EncodingFormat string
+
+const (
+	JSONEncoding        EncodingFormat = "JSON"
+	MessagePackEncoding EncodingFormat = "MessagePack"
+	CBOREncoding        EncodingFormat = "CBOR"
+	// ProtobufEncoding uses messages generated from the registered JSON Schema, the metrics are
+	// carried by the extras field of the root message
+	ProtobufEncoding EncodingFormat = "Protobuf"
+)
+
+type Compression string
+
+const (
+	NoCompression   Compression = "None"
+	GzipCompression Compression = "Gzip"
+	ZstdCompression Compression = "Zstd"
+)
+
+// EncodingSpec converts and compresses the payloads, the RegisterSchema message tells consumers
+// which encoding they receive.
+type EncodingSpec struct {
+	// Format defaults to JSON
+	Format EncodingFormat `json:"format,omitempty"`
+	// Compression defaults to None
+	Compression Compression `json:"compression,omitempty"`
+}
+
+type EnvelopeFormat string
+
+const (
+	// MessageEnvelope is the op, meta and data envelope, the data is base64 encoded
+	MessageEnvelope EnvelopeFormat = "Message"
+	// RawJSONEnvelope is the op, meta and data envelope, the data is embedded as JSON
+	RawJSONEnvelope EnvelopeFormat = "RawJSON"
+	// CloudEventsEnvelope is a CloudEvents 1.0 event
+	CloudEventsEnvelope EnvelopeFormat = "CloudEvents"
+)
+
+type CloudEventsMode string
+
+const (
+	// StructuredMode carries the whole event in the body
+	StructuredMode CloudEventsMode = "Structured"
+	// BinaryMode carries the attributes as protocol headers and the data as the body
+	BinaryMode CloudEventsMode = "Binary"
+)
+
+type EnvelopeSpec struct {
+	// Format defaults to Message
+	Format EnvelopeFormat `json:"format,omitempty"`
+	// Mode of the CloudEvents format, defaults to Structured
+	Mode CloudEventsMode `json:"mode,omitempty"`
+	// Source of the CloudEvents, defaults to /gateways/<gateway id>/monitors/<namespace>/<name>
+	Source string `json:"source,omitempty"`
 }
 
 // ResourceMonitorStatus defines the observed state of ResourceMonitor
@@ -86,6 +461,31 @@ type ResourceMonitorStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	Selected int `json:"selected,omitempty"`
+	// Coalesced counts the updates merged into a later message by the debounce window
+	Coalesced uint64 `json:"coalesced,omitempty"`
+	// Delayed counts the updates held back by the rate limit, they are published once it allows
+	Delayed uint64 `json:"delayed,omitempty"`
+	// PublishErrors counts the messages the backend failed to publish, or dropped after its retries
+	PublishErrors uint64 `json:"publishErrors,omitempty"`
+	// FilterErrors counts the failed evaluations of the filter, the objects are published then
+	FilterErrors uint64 `json:"filterErrors,omitempty"`
+	// FilterError is the last compile or evaluation error of the filter
+	FilterError string `json:"filterError,omitempty"`
+	// BackendError is the error building the message backend, nothing is published meanwhile
+	BackendError string `json:"backendError,omitempty"`
+	// IgnorePathError is the error parsing the ignore paths, only the default ones apply meanwhile
+	IgnorePathError string `json:"ignorePathError,omitempty"`
+	// Preview is the payload rendered for the object named by the PreviewAnnotation
+	Preview *PreviewStatus `json:"preview,omitempty"`
+}
+
+// PreviewAnnotation names a selected object, as name or namespace/name, whose payload is rendered into the status.
+const PreviewAnnotation = "fusion-app.io/preview"
+
+type PreviewStatus struct {
+	Object  string `json:"object"`
+	Payload string `json:"payload,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
 //+kubebuilder:object:root=true
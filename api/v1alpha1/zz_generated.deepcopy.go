@@ -21,16 +21,592 @@ limitations under the License.
 package v1alpha1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AMQPBackendSpec) DeepCopyInto(out *AMQPBackendSpec) {
+	*out = *in
+	if in.Credentials != nil {
+		in, out := &in.Credentials, &out.Credentials
+		*out = new(BasicAuth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfirmTimeout != nil {
+		in, out := &in.ConfirmTimeout, &out.ConfirmTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.TLSConfig != nil {
+		in, out := &in.TLSConfig, &out.TLSConfig
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Envelope != nil {
+		in, out := &in.Envelope, &out.Envelope
+		*out = new(EnvelopeSpec)
+		**out = **in
+	}
+	if in.Encoding != nil {
+		in, out := &in.Encoding, &out.Encoding
+		*out = new(EncodingSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AMQPBackendSpec.
+func (in *AMQPBackendSpec) DeepCopy() *AMQPBackendSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AMQPBackendSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BasicAuth) DeepCopyInto(out *BasicAuth) {
+	*out = *in
+	in.Username.DeepCopyInto(&out.Username)
+	in.Password.DeepCopyInto(&out.Password)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BasicAuth.
+func (in *BasicAuth) DeepCopy() *BasicAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(BasicAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CheckpointSpec) DeepCopyInto(out *CheckpointSpec) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CheckpointSpec.
+func (in *CheckpointSpec) DeepCopy() *CheckpointSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CheckpointSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeadLetterSpec) DeepCopyInto(out *DeadLetterSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeadLetterSpec.
+func (in *DeadLetterSpec) DeepCopy() *DeadLetterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DeadLetterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EncodingSpec) DeepCopyInto(out *EncodingSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EncodingSpec.
+func (in *EncodingSpec) DeepCopy() *EncodingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EncodingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvelopeSpec) DeepCopyInto(out *EnvelopeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvelopeSpec.
+func (in *EnvelopeSpec) DeepCopy() *EnvelopeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvelopeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FilterSpec) DeepCopyInto(out *FilterSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FilterSpec.
+func (in *FilterSpec) DeepCopy() *FilterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FilterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JetStreamSpec) DeepCopyInto(out *JetStreamSpec) {
+	*out = *in
+	if in.AckTimeout != nil {
+		in, out := &in.AckTimeout, &out.AckTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JetStreamSpec.
+func (in *JetStreamSpec) DeepCopy() *JetStreamSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(JetStreamSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MQTTBackendSpec) DeepCopyInto(out *MQTTBackendSpec) {
+	*out = *in
+	if in.Envelope != nil {
+		in, out := &in.Envelope, &out.Envelope
+		*out = new(EnvelopeSpec)
+		**out = **in
+	}
+	if in.Encoding != nil {
+		in, out := &in.Encoding, &out.Encoding
+		*out = new(EncodingSpec)
+		**out = **in
+	}
+	if in.Sparkplug != nil {
+		in, out := &in.Sparkplug, &out.Sparkplug
+		*out = new(SparkplugSpec)
+		**out = **in
+	}
+	if in.Presence != nil {
+		in, out := &in.Presence, &out.Presence
+		*out = new(PresenceSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MQTTBackendSpec.
+func (in *MQTTBackendSpec) DeepCopy() *MQTTBackendSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MQTTBackendSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricPolicy) DeepCopyInto(out *MetricPolicy) {
+	*out = *in
+	if in.MinInterval != nil {
+		in, out := &in.MinInterval, &out.MinInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Thresholds != nil {
+		in, out := &in.Thresholds, &out.Thresholds
+		*out = make([]ThresholdRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricPolicy.
+func (in *MetricPolicy) DeepCopy() *MetricPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricSpec) DeepCopyInto(out *MetricSpec) {
+	*out = *in
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		*out = new(MetricPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricSpec.
+func (in *MetricSpec) DeepCopy() *MetricSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MsgBackendSpec) DeepCopyInto(out *MsgBackendSpec) {
+	*out = *in
+	if in.MQTTBackend != nil {
+		in, out := &in.MQTTBackend, &out.MQTTBackend
+		*out = new(MQTTBackendSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WebhookBackend != nil {
+		in, out := &in.WebhookBackend, &out.WebhookBackend
+		*out = new(WebhookBackendSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NATSBackend != nil {
+		in, out := &in.NATSBackend, &out.NATSBackend
+		*out = new(NATSBackendSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AMQPBackend != nil {
+		in, out := &in.AMQPBackend, &out.AMQPBackend
+		*out = new(AMQPBackendSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RedisStreamBackend != nil {
+		in, out := &in.RedisStreamBackend, &out.RedisStreamBackend
+		*out = new(RedisStreamBackendSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MsgBackendSpec.
+func (in *MsgBackendSpec) DeepCopy() *MsgBackendSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MsgBackendSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MsgBuilder) DeepCopyInto(out *MsgBuilder) {
+	*out = *in
+	if in.Format != nil {
+		in, out := &in.Format, &out.Format
+		*out = new(MsgFormat)
+		**out = **in
+	}
+	in.MsgSource.DeepCopyInto(&out.MsgSource)
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = make([]MetricSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IgnorePaths != nil {
+		in, out := &in.IgnorePaths, &out.IgnorePaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Transitions != nil {
+		in, out := &in.Transitions, &out.Transitions
+		*out = make([]TransitionSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.Filter != nil {
+		in, out := &in.Filter, &out.Filter
+		*out = new(FilterSpec)
+		**out = **in
+	}
+	if in.Transform != nil {
+		in, out := &in.Transform, &out.Transform
+		*out = new(TransformSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MsgBuilder.
+func (in *MsgBuilder) DeepCopy() *MsgBuilder {
+	if in == nil {
+		return nil
+	}
+	out := new(MsgBuilder)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MsgFormat) DeepCopyInto(out *MsgFormat) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MsgFormat.
+func (in *MsgFormat) DeepCopy() *MsgFormat {
+	if in == nil {
+		return nil
+	}
+	out := new(MsgFormat)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MsgSource) DeepCopyInto(out *MsgSource) {
+	*out = *in
+	if in.PrometheusSource != nil {
+		in, out := &in.PrometheusSource, &out.PrometheusSource
+		*out = new(PrometheusDataSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ResourceMetricsSource != nil {
+		in, out := &in.ResourceMetricsSource, &out.ResourceMetricsSource
+		*out = new(ResourceMetricsDataSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScrapeSource != nil {
+		in, out := &in.ScrapeSource, &out.ScrapeSource
+		*out = new(ScrapeDataSource)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MsgSource.
+func (in *MsgSource) DeepCopy() *MsgSource {
+	if in == nil {
+		return nil
+	}
+	out := new(MsgSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSBackendSpec) DeepCopyInto(out *NATSBackendSpec) {
+	*out = *in
+	if in.Servers != nil {
+		in, out := &in.Servers, &out.Servers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Credentials != nil {
+		in, out := &in.Credentials, &out.Credentials
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NKey != nil {
+		in, out := &in.NKey, &out.NKey
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLSConfig != nil {
+		in, out := &in.TLSConfig, &out.TLSConfig
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.JetStream != nil {
+		in, out := &in.JetStream, &out.JetStream
+		*out = new(JetStreamSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Envelope != nil {
+		in, out := &in.Envelope, &out.Envelope
+		*out = new(EnvelopeSpec)
+		**out = **in
+	}
+	if in.Encoding != nil {
+		in, out := &in.Encoding, &out.Encoding
+		*out = new(EncodingSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSBackendSpec.
+func (in *NATSBackendSpec) DeepCopy() *NATSBackendSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSBackendSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PresenceSpec) DeepCopyInto(out *PresenceSpec) {
+	*out = *in
+	if in.HeartbeatInterval != nil {
+		in, out := &in.HeartbeatInterval, &out.HeartbeatInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PresenceSpec.
+func (in *PresenceSpec) DeepCopy() *PresenceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PresenceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreviewStatus) DeepCopyInto(out *PreviewStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreviewStatus.
+func (in *PreviewStatus) DeepCopy() *PreviewStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PreviewStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusDataSource) DeepCopyInto(out *PrometheusDataSource) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.LabelMapping != nil {
+		in, out := &in.LabelMapping, &out.LabelMapping
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.BearerToken != nil {
+		in, out := &in.BearerToken, &out.BearerToken
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BasicAuth != nil {
+		in, out := &in.BasicAuth, &out.BasicAuth
+		*out = new(BasicAuth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLSConfig != nil {
+		in, out := &in.TLSConfig, &out.TLSConfig
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusDataSource.
+func (in *PrometheusDataSource) DeepCopy() *PrometheusDataSource {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusDataSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitSpec) DeepCopyInto(out *RateLimitSpec) {
+	*out = *in
+	if in.DebounceWindow != nil {
+		in, out := &in.DebounceWindow, &out.DebounceWindow
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitSpec.
+func (in *RateLimitSpec) DeepCopy() *RateLimitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisStreamBackendSpec) DeepCopyInto(out *RedisStreamBackendSpec) {
+	*out = *in
+	if in.Password != nil {
+		in, out := &in.Password, &out.Password
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLSConfig != nil {
+		in, out := &in.TLSConfig, &out.TLSConfig
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Envelope != nil {
+		in, out := &in.Envelope, &out.Envelope
+		*out = new(EnvelopeSpec)
+		**out = **in
+	}
+	if in.Encoding != nil {
+		in, out := &in.Encoding, &out.Encoding
+		*out = new(EncodingSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisStreamBackendSpec.
+func (in *RedisStreamBackendSpec) DeepCopy() *RedisStreamBackendSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisStreamBackendSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceMetricsDataSource) DeepCopyInto(out *ResourceMetricsDataSource) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceMetricsDataSource.
+func (in *ResourceMetricsDataSource) DeepCopy() *ResourceMetricsDataSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceMetricsDataSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceMonitor) DeepCopyInto(out *ResourceMonitor) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceMonitor.
@@ -86,6 +662,19 @@ func (in *ResourceMonitorList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceMonitorSpec) DeepCopyInto(out *ResourceMonitorSpec) {
 	*out = *in
+	in.Selector.DeepCopyInto(&out.Selector)
+	in.MsgBuilder.DeepCopyInto(&out.MsgBuilder)
+	in.MsgBackendSpec.DeepCopyInto(&out.MsgBackendSpec)
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(RateLimitSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Checkpoint != nil {
+		in, out := &in.Checkpoint, &out.Checkpoint
+		*out = new(CheckpointSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceMonitorSpec.
@@ -101,6 +690,11 @@ func (in *ResourceMonitorSpec) DeepCopy() *ResourceMonitorSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceMonitorStatus) DeepCopyInto(out *ResourceMonitorStatus) {
 	*out = *in
+	if in.Preview != nil {
+		in, out := &in.Preview, &out.Preview
+		*out = new(PreviewStatus)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceMonitorStatus.
@@ -112,3 +706,226 @@ func (in *ResourceMonitorStatus) DeepCopy() *ResourceMonitorStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeDataSource) DeepCopyInto(out *ScrapeDataSource) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.BearerToken != nil {
+		in, out := &in.BearerToken, &out.BearerToken
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLSConfig != nil {
+		in, out := &in.TLSConfig, &out.TLSConfig
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeDataSource.
+func (in *ScrapeDataSource) DeepCopy() *ScrapeDataSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeDataSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SelectorSpec) DeepCopyInto(out *SelectorSpec) {
+	*out = *in
+	out.GVK = in.GVK
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SelectorSpec.
+func (in *SelectorSpec) DeepCopy() *SelectorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SelectorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SparkplugSpec) DeepCopyInto(out *SparkplugSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SparkplugSpec.
+func (in *SparkplugSpec) DeepCopy() *SparkplugSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SparkplugSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSConfig) DeepCopyInto(out *TLSConfig) {
+	*out = *in
+	if in.CA != nil {
+		in, out := &in.CA, &out.CA
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSConfig.
+func (in *TLSConfig) DeepCopy() *TLSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThresholdRule) DeepCopyInto(out *ThresholdRule) {
+	*out = *in
+	if in.For != nil {
+		in, out := &in.For, &out.For
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThresholdRule.
+func (in *ThresholdRule) DeepCopy() *ThresholdRule {
+	if in == nil {
+		return nil
+	}
+	out := new(ThresholdRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TransformSpec) DeepCopyInto(out *TransformSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TransformSpec.
+func (in *TransformSpec) DeepCopy() *TransformSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TransformSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TransitionSpec) DeepCopyInto(out *TransitionSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TransitionSpec.
+func (in *TransitionSpec) DeepCopy() *TransitionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TransitionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebhookBackendSpec) DeepCopyInto(out *WebhookBackendSpec) {
+	*out = *in
+	if in.SigningKey != nil {
+		in, out := &in.SigningKey, &out.SigningKey
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxRetries != nil {
+		in, out := &in.MaxRetries, &out.MaxRetries
+		*out = new(int)
+		**out = **in
+	}
+	if in.MinBackoff != nil {
+		in, out := &in.MinBackoff, &out.MinBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxBackoff != nil {
+		in, out := &in.MaxBackoff, &out.MaxBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Batch != nil {
+		in, out := &in.Batch, &out.Batch
+		*out = new(WebhookBatchSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DeadLetter != nil {
+		in, out := &in.DeadLetter, &out.DeadLetter
+		*out = new(DeadLetterSpec)
+		**out = **in
+	}
+	if in.TLSConfig != nil {
+		in, out := &in.TLSConfig, &out.TLSConfig
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Envelope != nil {
+		in, out := &in.Envelope, &out.Envelope
+		*out = new(EnvelopeSpec)
+		**out = **in
+	}
+	if in.Encoding != nil {
+		in, out := &in.Encoding, &out.Encoding
+		*out = new(EncodingSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebhookBackendSpec.
+func (in *WebhookBackendSpec) DeepCopy() *WebhookBackendSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WebhookBackendSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebhookBatchSpec) DeepCopyInto(out *WebhookBatchSpec) {
+	*out = *in
+	if in.MaxWait != nil {
+		in, out := &in.MaxWait, &out.MaxWait
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebhookBatchSpec.
+func (in *WebhookBatchSpec) DeepCopy() *WebhookBatchSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WebhookBatchSpec)
+	in.DeepCopyInto(out)
+	return out
+}
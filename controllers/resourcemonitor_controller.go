@@ -24,12 +24,19 @@ import (
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
 	"github.com/fusion-app/gateway/pkg/job"
+	"github.com/fusion-app/gateway/pkg/msg"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const monitorFinalizer = "monitor.fusion-app.io/finalizer"
@@ -37,9 +44,11 @@ const monitorFinalizer = "monitor.fusion-app.io/finalizer"
 // ResourceMonitorReconciler reconciles a ResourceMonitor object
 type ResourceMonitorReconciler struct {
 	client.Client
-	Log        logr.Logger
-	Scheme     *runtime.Scheme
-	jobManager *job.MonitorJobManager
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// MaxConcurrentReconciles defaults to 1
+	MaxConcurrentReconciles int
+	jobManager              *job.MonitorJobManager
 }
 
 func (r *ResourceMonitorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -79,15 +88,39 @@ func (r *ResourceMonitorReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		}
 	}
 
-	r.jobManager.NewJobOrExist(monitor)
+	monitorJob := r.jobManager.NewJobOrExist(monitor)
+	monitorJob.UpdatePreview(monitor)
 	return ctrl.Result{}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ResourceMonitorReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	r.jobManager = job.NewSyncJobManager(mgr.GetCache(), mgr.GetClient())
+	r.jobManager = job.NewSyncJobManager(mgr)
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&monitorv1alpha1.ResourceMonitor{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		// the annotations request previews
+		For(&monitorv1alpha1.ResourceMonitor{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		// a rotated Secret rebuilds the backends reading it
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.monitorsReadingSecret)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
+
+// monitorsReadingSecret returns the monitors whose backend reads secret.
+func (r *ResourceMonitorReconciler) monitorsReadingSecret(secret client.Object) []reconcile.Request {
+	monitors := &monitorv1alpha1.ResourceMonitorList{}
+	if err := r.List(context.TODO(), monitors, client.InNamespace(secret.GetNamespace())); err != nil {
+		r.Log.Error(err, "List monitors of a Secret failed", "secret", secret.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, monitor := range monitors.Items {
+		for _, name := range msg.SecretNames(monitor.Spec.MsgBackendSpec) {
+			if name == secret.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&monitor)})
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -4,18 +4,38 @@ go 1.15
 
 require (
 	github.com/alecthomas/jsonschema v0.0.0-20210413112511-5c9c23bdc720
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/eclipse/paho.mqtt.golang v1.3.3
+	github.com/fxamacker/cbor/v2 v2.2.0
 	github.com/go-logr/logr v0.3.0
 	github.com/go-logr/zapr v0.3.0 // indirect
+	github.com/go-redis/redis/v7 v7.4.1
+	github.com/golang/protobuf v1.4.3
+	github.com/google/cel-go v0.7.3
+	github.com/google/uuid v1.1.2
+	github.com/gorilla/websocket v1.4.2
+	github.com/itchyny/gojq v0.12.4
+	github.com/klauspost/compress v1.11.13
+	github.com/mochi-co/mqtt v1.0.0
+	github.com/nats-io/nats.go v1.11.0
+	github.com/nats-io/nkeys v0.3.0
 	github.com/onsi/ginkgo v1.14.1
 	github.com/onsi/gomega v1.10.2
 	github.com/prometheus/client_golang v1.10.0
 	github.com/prometheus/common v0.18.0
+	github.com/streadway/amqp v1.0.0
+	github.com/vmihailenco/msgpack/v5 v5.1.0
 	github.com/wI2L/jsondiff v0.1.0
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
+	google.golang.org/genproto v0.0.0-20201102152239-715cce707fb0
+	google.golang.org/grpc v1.36.0
+	google.golang.org/protobuf v1.25.0
+	k8s.io/api v0.19.4
 	k8s.io/apimachinery v0.19.4
 	k8s.io/client-go v12.0.0+incompatible
 	kubevirt.io/client-go v0.33.0
 	sigs.k8s.io/controller-runtime v0.7.0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace k8s.io/client-go => k8s.io/client-go v0.19.2
@@ -34,6 +34,11 @@ import (
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
 	"github.com/fusion-app/gateway/controllers"
+	"github.com/fusion-app/gateway/pkg/broker"
+	"github.com/fusion-app/gateway/pkg/msg"
+	"github.com/fusion-app/gateway/pkg/rpc"
+	"github.com/fusion-app/gateway/pkg/stream"
+	"github.com/fusion-app/gateway/pkg/webhook"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -53,11 +58,28 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var maxConcurrentReconciles int
+	var enableWebhooks bool
+	var streamAddr string
+	var grpcAddr string
+	var brokerConfigPath string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", true,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The maximum number of ResourceMonitors reconciled concurrently.")
+	flag.StringVar(&msg.GatewayID, "gateway-id", msg.GatewayID,
+		"The ID of this gateway instance carried by every message, defaults to the hostname.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Enable the validating webhook of ResourceMonitors, see config/webhook to deploy it.")
+	flag.StringVar(&streamAddr, "stream-bind-address", "",
+		"The address the WebSocket and Server-Sent Events endpoint of the monitors binds to, disabled if empty.")
+	flag.StringVar(&grpcAddr, "grpc-bind-address", "",
+		"The address the gRPC subscription API of the monitors binds to, disabled if empty.")
+	flag.StringVar(&brokerConfigPath, "mqtt-broker-config", "",
+		"The config file of the embedded MQTT broker targeted by the MQTT backends with the host local, disabled if empty.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -84,16 +106,56 @@ func main() {
 		os.Exit(1)
 	}
 
+	// before the controller, the MQTT backends with the host local connect to it
+	if brokerConfigPath != "" {
+		config, err := broker.ReadConfig(brokerConfigPath)
+		if err != nil {
+			setupLog.Error(err, "unable to read MQTT broker config")
+			os.Exit(1)
+		}
+		b, err := broker.New(config, mgr.GetAPIReader())
+		if err != nil {
+			setupLog.Error(err, "unable to start MQTT broker")
+			os.Exit(1)
+		}
+		msg.LocalBroker = b.Endpoint()
+		if err := mgr.Add(b); err != nil {
+			setupLog.Error(err, "unable to set up MQTT broker")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controllers.ResourceMonitorReconciler{
 		Client: mgr.GetClient(),
 		Log:    ctrl.Log.WithName("controllers").WithName("ResourceMonitor"),
 		Scheme: mgr.GetScheme(),
+
+		MaxConcurrentReconciles: maxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ResourceMonitor")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err = webhook.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ResourceMonitor")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	if streamAddr != "" {
+		if err := mgr.Add(stream.NewServer(streamAddr, stream.NewReviewAuthorizer(mgr.GetClient()))); err != nil {
+			setupLog.Error(err, "unable to set up stream endpoint")
+			os.Exit(1)
+		}
+	}
+	if grpcAddr != "" {
+		if err := mgr.Add(rpc.NewServer(grpcAddr, stream.NewReviewAuthorizer(mgr.GetClient()))); err != nil {
+			setupLog.Error(err, "unable to set up gRPC subscription API")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -104,7 +166,9 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	msg.CloseHandlers()
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
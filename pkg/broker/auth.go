@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"crypto/subtle"
+	"strings"
+	"sync"
+)
+
+// authController checks the users of the public listener against the users Secret and their ACLs.
+type authController struct {
+	mtx sync.RWMutex
+	// users maps a username to its password, nil lets anyone connect
+	users map[string][]byte
+	// key: username
+	acls map[string]*ACL
+	// defaultACL of the users without ACL, nil denies them everything
+	defaultACL *ACL
+}
+
+func newAuthController(acls []ACL, defaultACL *ACL) *authController {
+	a := &authController{acls: make(map[string]*ACL, len(acls)), defaultACL: defaultACL}
+	for i := range acls {
+		a.acls[acls[i].User] = &acls[i]
+	}
+	return a
+}
+
+func (a *authController) setUsers(users map[string][]byte) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.users = users
+}
+
+func (a *authController) Authenticate(user, password []byte) bool {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	if a.users == nil {
+		return true
+	}
+	expected, exists := a.users[string(user)]
+	return exists && subtle.ConstantTimeCompare(expected, password) == 1
+}
+
+// ACL tells whether user may publish to the topic, or subscribe to the topic filter. A user
+// without ACL gets the default one, denied if there is none.
+func (a *authController) ACL(user []byte, topic string, write bool) bool {
+	acl, exists := a.acls[string(user)]
+	if !exists {
+		if acl = a.defaultACL; acl == nil {
+			return false
+		}
+	}
+	filters := acl.Subscribe
+	if write {
+		filters = acl.Publish
+	}
+	for _, filter := range filters {
+		if covers(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalAuth lets the MQTT backends of the gateway publish and subscribe anywhere.
+type internalAuth struct {
+	username []byte
+	password []byte
+}
+
+func (a *internalAuth) Authenticate(user, password []byte) bool {
+	return subtle.ConstantTimeCompare(a.username, user) == 1 && subtle.ConstantTimeCompare(a.password, password) == 1
+}
+
+func (a *internalAuth) ACL(user []byte, topic string, write bool) bool {
+	return true
+}
+
+// covers tells whether every topic matched by the topic filter requested, or the topic name
+// requested, is matched by the filter allowed.
+func covers(allowed, requested string) bool {
+	allowedLevels := strings.Split(allowed, "/")
+	requestedLevels := strings.Split(requested, "/")
+	for i, level := range allowedLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(requestedLevels) {
+			return false
+		}
+		switch requestedLevels[i] {
+		case "#":
+			return false
+		case "+":
+			if level != "+" {
+				return false
+			}
+		default:
+			if level != "+" && level != requestedLevels[i] {
+				return false
+			}
+		}
+	}
+	return len(allowedLevels) == len(requestedLevels)
+}
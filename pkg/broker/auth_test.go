@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"testing"
+)
+
+func TestCovers(t *testing.T) {
+	for _, c := range []struct {
+		allowed, requested string
+		expected           bool
+	}{
+		{"gateway/#", "gateway/state/Pod/default/web", true},
+		{"gateway/#", "gateway", true},
+		{"gateway/#", "#", false},
+		{"gateway/+/Pod/#", "gateway/state/Pod/+", true},
+		{"gateway/+/Pod/#", "gateway/state/Node/n1", false},
+		{"gateway/+", "gateway/+", true},
+		{"gateway/state", "gateway/+", false},
+		{"gateway/+", "gateway/state/Pod", false},
+		{"gateway/state/+", "gateway/state", false},
+	} {
+		if covers(c.allowed, c.requested) != c.expected {
+			t.Errorf("covers(%s, %s) != %v", c.allowed, c.requested, c.expected)
+		}
+	}
+}
+
+func TestAuthController(t *testing.T) {
+	a := newAuthController([]ACL{{User: "sensor", Publish: []string{"sensors/#"}}}, nil)
+	if !a.Authenticate([]byte("anyone"), nil) {
+		t.Errorf("anonymous user rejected without users")
+	}
+	a.setUsers(map[string][]byte{"sensor": []byte("secret"), "viewer": []byte("secret")})
+	if a.Authenticate([]byte("sensor"), []byte("wrong")) || a.Authenticate([]byte("anyone"), []byte("secret")) {
+		t.Errorf("invalid credentials accepted")
+	}
+	if !a.Authenticate([]byte("sensor"), []byte("secret")) {
+		t.Errorf("valid credentials rejected")
+	}
+	if !a.ACL([]byte("sensor"), "sensors/temperature", true) || a.ACL([]byte("sensor"), "gateway/state", false) {
+		t.Errorf("ACL of sensor not applied")
+	}
+	if a.ACL([]byte("viewer"), "gateway/state", true) || a.ACL([]byte("viewer"), "gateway/#", false) {
+		t.Errorf("user without ACL allowed without default ACL")
+	}
+
+	a = newAuthController(nil, &ACL{Subscribe: []string{"gateway/#"}})
+	if a.ACL([]byte("viewer"), "gateway/state", true) || !a.ACL([]byte("viewer"), "gateway/#", false) ||
+		a.ACL(nil, "sensors/#", false) {
+		t.Errorf("default ACL not applied")
+	}
+}
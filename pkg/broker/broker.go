@@ -0,0 +1,258 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+	"github.com/mochi-co/mqtt/server/listeners/auth"
+	"github.com/mochi-co/mqtt/server/system"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/fusion-app/gateway/pkg/msg"
+)
+
+var brokerLogger = ctrl.Log.WithName("broker")
+
+const (
+	defaultAddress       = ":1883"
+	internalUsername     = "k8s-gateway"
+	usersRefreshInterval = time.Minute
+)
+
+// Config of the embedded broker, read from a YAML or JSON file.
+type Config struct {
+	// Address of the listener for the consumers, :1883 by default
+	Address string     `json:"address,omitempty"`
+	TLS     *TLSConfig `json:"tls,omitempty"`
+	// UsersSecret holds the password of each user under its username, anyone may connect if unset
+	UsersSecret *SecretRef `json:"usersSecret,omitempty"`
+	// ACLs of the users
+	ACLs []ACL `json:"acls,omitempty"`
+	// DefaultACL applies to the users without their own ACL, the anonymous ones included. They
+	// may neither publish nor subscribe if unset, set its subscribe to ["#"] for read-only consumers.
+	DefaultACL *ACL `json:"defaultACL,omitempty"`
+}
+
+// TLSConfig of the listener, the client certificates are required if ClientCAFile is set.
+type TLSConfig struct {
+	CertFile     string `json:"certFile"`
+	KeyFile      string `json:"keyFile"`
+	ClientCAFile string `json:"clientCAFile,omitempty"`
+}
+
+type SecretRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// ACL lists the topic filters a user may publish and subscribe to, the user of DefaultACL is ignored.
+type ACL struct {
+	User      string   `json:"user"`
+	Publish   []string `json:"publish,omitempty"`
+	Subscribe []string `json:"subscribe,omitempty"`
+}
+
+func ReadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("invalid broker config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Broker is the MQTT broker embedded in the gateway. The consumers connect to the public
+// listener, the MQTT backends with the host local to an internal listener on the loopback
+// interface with generated credentials.
+type Broker struct {
+	server   *mqtt.Server
+	address  string
+	reader   client.Reader
+	config   *Config
+	auth     *authController
+	endpoint *msg.BrokerEndpoint
+}
+
+// New serves right away, so the backends can connect before the manager is started. The users
+// Secret is read with reader.
+func New(config *Config, reader client.Reader) (*Broker, error) {
+	b := &Broker{
+		server: mqtt.New(),
+		reader: reader,
+		config: config,
+		auth:   newAuthController(config.ACLs, config.DefaultACL),
+	}
+	if config.UsersSecret != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := b.refreshUsers(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	address := config.Address
+	if address == "" {
+		address = defaultAddress
+	}
+	publicListener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if config.TLS != nil {
+		tlsConfig, err := serverTLSConfig(config.TLS)
+		if err != nil {
+			publicListener.Close()
+			return nil, err
+		}
+		publicListener = tls.NewListener(publicListener, tlsConfig)
+	}
+	internalListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		publicListener.Close()
+		return nil, err
+	}
+	password := make([]byte, 16)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	b.endpoint = &msg.BrokerEndpoint{
+		Address:  internalListener.Addr().String(),
+		Username: internalUsername,
+		Password: hex.EncodeToString(password),
+	}
+	internal := &internalAuth{username: []byte(b.endpoint.Username), password: []byte(b.endpoint.Password)}
+
+	if err := b.server.AddListener(newListener("public", publicListener, b.auth), nil); err != nil {
+		return nil, err
+	}
+	if err := b.server.AddListener(newListener("internal", internalListener, internal), nil); err != nil {
+		return nil, err
+	}
+	if err := b.server.Serve(); err != nil {
+		return nil, err
+	}
+	b.address = publicListener.Addr().String()
+	brokerLogger.Info("Serving the embedded MQTT broker", "address", b.address)
+	return b, nil
+}
+
+// Endpoint of the internal listener.
+func (b *Broker) Endpoint() *msg.BrokerEndpoint {
+	return b.endpoint
+}
+
+// Start refreshes the users until ctx is done, then closes the broker.
+func (b *Broker) Start(ctx context.Context) error {
+	ticker := time.NewTicker(usersRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if b.config.UsersSecret == nil {
+				continue
+			}
+			if err := b.refreshUsers(ctx); err != nil {
+				brokerLogger.Error(err, "Refresh users failed, the previous ones are kept")
+			}
+		case <-ctx.Done():
+			return b.server.Close()
+		}
+	}
+}
+
+// NeedLeaderElection is false, every gateway instance serves its broker.
+func (b *Broker) NeedLeaderElection() bool {
+	return false
+}
+
+func (b *Broker) refreshUsers(ctx context.Context) error {
+	ref := b.config.UsersSecret
+	secret := &corev1.Secret{}
+	if err := b.reader.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		return fmt.Errorf("read users secret %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+	users := make(map[string][]byte, len(secret.Data))
+	for username, password := range secret.Data {
+		users[username] = password
+	}
+	b.auth.setUsers(users)
+	return nil
+}
+
+func serverTLSConfig(spec *TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(spec.CertFile, spec.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if spec.ClientCAFile != "" {
+		caData, err := ioutil.ReadFile(spec.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificate in %s", spec.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// listener serves the connections of a bound net.Listener, the TCP listener of the broker
+// neither exposes the bound address nor takes client CAs.
+type listener struct {
+	id       string
+	listener net.Listener
+	auth     auth.Controller
+	closed   int32
+}
+
+func newListener(id string, netListener net.Listener, controller auth.Controller) *listener {
+	return &listener{id: id, listener: netListener, auth: controller}
+}
+
+func (l *listener) SetConfig(*listeners.Config) {}
+
+func (l *listener) Listen(*system.Info) error {
+	return nil
+}
+
+func (l *listener) Serve(establish listeners.EstablishFunc) {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		go establish(l.id, conn, l.auth)
+	}
+}
+
+func (l *listener) ID() string {
+	return l.id
+}
+
+func (l *listener) Close(closeClients listeners.CloseFunc) {
+	if atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		closeClients(l.id)
+		l.listener.Close()
+	}
+}
@@ -0,0 +1,111 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/msg"
+)
+
+func newTestBroker(t *testing.T) (*Broker, func()) {
+	b, err := New(&Config{Address: "127.0.0.1:0", DefaultACL: &ACL{Subscribe: []string{"#"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Start(ctx)
+		close(done)
+	}()
+	return b, func() {
+		cancel()
+		<-done
+	}
+}
+
+func connect(t *testing.T, address, username, password string) mqtt.Client {
+	opts := mqtt.NewClientOptions().AddBroker("tcp://" + address).SetUsername(username).SetPassword(password)
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); !token.WaitTimeout(time.Second*3) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	return client
+}
+
+func TestBroker_RetainedState(t *testing.T) {
+	b, stop := newTestBroker(t)
+	defer stop()
+	msg.LocalBroker = b.Endpoint()
+	defer func() { msg.LocalBroker = nil }()
+
+	handler, err := msg.NewMQTTMsgHandler(&monitorv1alpha1.MQTTBackendSpec{Host: msg.LocalBrokerHost, Topic: "gateway"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	for _, m := range []*msg.Message{
+		{Op: msg.NewResource, Meta: &msg.ResourceMeta{SchemaID: "v1/Pod", Namespace: "default", Name: "web"}, Data: []byte(`{"phase":"Running"}`)},
+		{Op: msg.NewResource, Meta: &msg.ResourceMeta{SchemaID: "v1/Pod", Namespace: "default", Name: "db"}, Data: []byte(`{"phase":"Running"}`)},
+		{Op: msg.DelResource, Meta: &msg.ResourceMeta{SchemaID: "v1/Pod", Namespace: "default", Name: "db"}},
+	} {
+		if err := handler.Publish(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// subscribed after the publications, on the public listener
+	consumer := connect(t, b.address, "", "")
+	defer consumer.Disconnect(0)
+	received := make(chan string, 4)
+	token := consumer.Subscribe("gateway/state/#", 1, func(_ mqtt.Client, m mqtt.Message) {
+		if m.Retained() {
+			received <- m.Topic()
+		}
+	})
+	if !token.WaitTimeout(time.Second*3) || token.Error() != nil {
+		t.Fatalf("subscribe failed: %v", token.Error())
+	}
+	select {
+	case topic := <-received:
+		if topic != "gateway/state/Pod/default/web" {
+			t.Errorf("retained topic = %s", topic)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("retained state not received")
+	}
+	select {
+	case topic := <-received:
+		t.Errorf("unexpected retained %s", topic)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestBroker_Auth(t *testing.T) {
+	b, stop := newTestBroker(t)
+	defer stop()
+	b.auth.setUsers(map[string][]byte{"viewer": []byte("secret")})
+
+	opts := mqtt.NewClientOptions().AddBroker("tcp://" + b.address).SetUsername("viewer").SetPassword("wrong")
+	if token := mqtt.NewClient(opts).Connect(); token.WaitTimeout(time.Second*3) && token.Error() == nil {
+		t.Errorf("connected with an invalid password")
+	}
+	viewer := connect(t, b.address, "viewer", "secret")
+	defer viewer.Disconnect(0)
+	if token := viewer.Publish("gateway/state/Pod/default/web", 1, true, "{}"); token.WaitTimeout(time.Second) && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	internal := connect(t, b.Endpoint().Address, b.Endpoint().Username, b.Endpoint().Password)
+	defer internal.Disconnect(0)
+	received := make(chan struct{}, 1)
+	internal.Subscribe("gateway/#", 1, func(mqtt.Client, mqtt.Message) { received <- struct{}{} }).WaitTimeout(time.Second * 3)
+	select {
+	case <-received:
+		t.Errorf("publication of viewer without ACL delivered")
+	case <-time.After(time.Millisecond * 200):
+	}
+}
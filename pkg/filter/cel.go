@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	objectVar    = "object"
+	oldObjectVar = "oldObject"
+)
+
+// Filter decides with CEL expressions which objects and which changes are published.
+type Filter struct {
+	object cel.Program
+	update cel.Program
+}
+
+// New compiles both expressions, an empty expression lets everything through.
+func New(objectExpr, updateExpr string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.object, err = compile(objectExpr, objectVar); err != nil {
+		return nil, fmt.Errorf("object filter: %v", err)
+	}
+	if f.update, err = compile(updateExpr, objectVar, oldObjectVar); err != nil {
+		return nil, fmt.Errorf("update filter: %v", err)
+	}
+	return f, nil
+}
+
+func compile(expr string, vars ...string) (cel.Program, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	declarations := make([]*exprpb.Decl, 0, len(vars))
+	for _, name := range vars {
+		declarations = append(declarations, decls.NewVar(name, decls.NewMapType(decls.String, decls.Dyn)))
+	}
+	env, err := cel.NewEnv(cel.Declarations(declarations...))
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if resultType := ast.ResultType(); !proto.Equal(resultType, decls.Bool) && !proto.Equal(resultType, decls.Dyn) {
+		return nil, fmt.Errorf("expression %q must evaluate to a bool", expr)
+	}
+	return env.Program(ast)
+}
+
+// MatchObject evaluates the object expression.
+func (f *Filter) MatchObject(object map[string]interface{}) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	return eval(f.object, map[string]interface{}{objectVar: object})
+}
+
+// MatchUpdate evaluates the update expression against both states of the object.
+func (f *Filter) MatchUpdate(oldObject, object map[string]interface{}) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	return eval(f.update, map[string]interface{}{objectVar: object, oldObjectVar: oldObject})
+}
+
+func eval(program cel.Program, vars map[string]interface{}) (bool, error) {
+	if program == nil {
+		return true, nil
+	}
+	val, _, err := program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	matched, ok := val.(types.Bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluated to %v instead of a bool", val.Value())
+	}
+	return bool(matched), nil
+}
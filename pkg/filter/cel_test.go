@@ -0,0 +1,38 @@
+package filter
+
+import "testing"
+
+func TestFilter(t *testing.T) {
+	f, err := New("object.status.phase != 'Succeeded'", "object.spec.replicas != oldObject.spec.replicas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := map[string]interface{}{"status": map[string]interface{}{"phase": "Running"}}
+	succeeded := map[string]interface{}{"status": map[string]interface{}{"phase": "Succeeded"}}
+	if matched, err := f.MatchObject(running); err != nil || !matched {
+		t.Errorf("expected the running object to match, got %v, %v", matched, err)
+	}
+	if matched, err := f.MatchObject(succeeded); err != nil || matched {
+		t.Errorf("expected the succeeded object not to match, got %v, %v", matched, err)
+	}
+
+	oldObject := map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}}
+	object := map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(2)}}
+	if matched, err := f.MatchUpdate(oldObject, object); err != nil || !matched {
+		t.Errorf("expected the scaled object to match, got %v, %v", matched, err)
+	}
+	if matched, err := f.MatchUpdate(object, object); err != nil || matched {
+		t.Errorf("expected the unchanged object not to match, got %v, %v", matched, err)
+	}
+	if _, err := f.MatchObject(map[string]interface{}{}); err == nil {
+		t.Errorf("expected an error for a missing field")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, expr := range []string{"object.status.phase ==", "oldObject.spec", "1 + 1"} {
+		if _, err := New(expr, ""); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// informerSyncTimeout bounds the initial list of a kind, it never ends when the kind can't be
+// listed (forbidden, CRD missing).
+const informerSyncTimeout = time.Minute
+
+// informerRegistry runs one dedicated informer per GVK shared by the jobs selecting that kind.
+// Jobs hold removable handler registrations, and the informer is stopped with its last handler.
+type informerRegistry struct {
+	logger      logr.Logger
+	config      *rest.Config
+	scheme      *runtime.Scheme
+	mapper      meta.RESTMapper
+	syncTimeout time.Duration
+
+	// mtx only guards the map and the reference counts, the informers are started without it
+	mtx       sync.Mutex
+	informers map[schema.GroupVersionKind]*sharedInformer
+}
+
+func newInformerRegistry(logger logr.Logger, config *rest.Config, scheme *runtime.Scheme, mapper meta.RESTMapper) *informerRegistry {
+	return &informerRegistry{
+		logger:      logger,
+		config:      config,
+		scheme:      scheme,
+		mapper:      mapper,
+		syncTimeout: informerSyncTimeout,
+		informers:   make(map[schema.GroupVersionKind]*sharedInformer),
+	}
+}
+
+// Register adds handler to the informer of gvk, starting the informer if needed. It returns once
+// the handler has received an Add event for every object already known by the informer.
+func (r *informerRegistry) Register(gvk schema.GroupVersionKind, handler toolscache.ResourceEventHandler) (*handlerRegistration, error) {
+	r.mtx.Lock()
+	informer, exists := r.informers[gvk]
+	if !exists {
+		informer = &sharedInformer{
+			ready:    make(chan struct{}),
+			handlers: make(map[int]*handlerQueue),
+		}
+		r.informers[gvk] = informer
+	}
+	informer.refs++
+	r.mtx.Unlock()
+
+	if !exists {
+		informer.err = r.startInformer(gvk, informer)
+		close(informer.ready)
+	}
+	<-informer.ready
+	if informer.err != nil {
+		r.mtx.Lock()
+		if r.informers[gvk] == informer {
+			delete(r.informers, gvk)
+		}
+		r.mtx.Unlock()
+		return nil, informer.err
+	}
+	registration, replayed := informer.addHandler(r, gvk, handler)
+	<-replayed
+	return registration, nil
+}
+
+// startInformer runs the informer of gvk until its sync, the callers of Register wait on shared.ready.
+func (r *informerRegistry) startInformer(gvk schema.GroupVersionKind, shared *sharedInformer) error {
+	kindCache, err := cache.New(r.config, cache.Options{Scheme: r.scheme, Mapper: r.mapper})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if err := kindCache.Start(ctx); err != nil {
+			r.logger.Error(err, "Informer stopped", "gvk", gvk)
+		}
+	}()
+	syncCtx, syncCancel := context.WithTimeout(ctx, r.syncTimeout)
+	defer syncCancel()
+	informer, err := kindCache.GetInformerForKind(syncCtx, gvk)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if !kindCache.WaitForCacheSync(syncCtx) {
+		cancel()
+		return fmt.Errorf("sync informer of %s failed within %s", gvk, r.syncTimeout)
+	}
+
+	shared.informer = informer
+	shared.cancel = cancel
+	informer.AddEventHandler(shared)
+	r.logger.Info("Informer started", "gvk", gvk)
+	return nil
+}
+
+func (r *informerRegistry) unregister(gvk schema.GroupVersionKind, id int) {
+	r.mtx.Lock()
+	informer, exists := r.informers[gvk]
+	if !exists {
+		r.mtx.Unlock()
+		return
+	}
+	informer.refs--
+	stop := informer.refs == 0
+	if stop {
+		delete(r.informers, gvk)
+	}
+	r.mtx.Unlock()
+
+	informer.removeHandler(id)
+	if stop {
+		informer.cancel()
+		r.logger.Info("Informer stopped, no monitor selects the kind anymore", "gvk", gvk)
+	}
+}
+
+// sharedInformer fans the events of one informer out to the queues of the registered handlers.
+type sharedInformer struct {
+	informer cache.Informer
+	cancel   context.CancelFunc
+	// ready is closed once the informer synced or failed with err
+	ready chan struct{}
+	err   error
+	// refs counts the registered and registering handlers, guarded by the registry
+	refs int
+
+	mtx      sync.RWMutex
+	nextID   int
+	handlers map[int]*handlerQueue
+}
+
+// addHandler queues the Add events of the known objects before any live event, replayed is
+// closed once the handler processed them.
+func (s *sharedInformer) addHandler(r *informerRegistry, gvk schema.GroupVersionKind, handler toolscache.ResourceEventHandler) (*handlerRegistration, <-chan struct{}) {
+	queue := newHandlerQueue(handler)
+	replayed := make(chan struct{})
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, obj := range s.list() {
+		obj := obj
+		queue.push(func() { handler.OnAdd(obj) })
+	}
+	queue.push(func() { close(replayed) })
+	id := s.nextID
+	s.nextID++
+	s.handlers[id] = queue
+	return &handlerRegistration{registry: r, gvk: gvk, id: id, queue: queue, informer: s}, replayed
+}
+
+// removeHandler waits for the in-flight event of the handler, the queued ones are dropped.
+func (s *sharedInformer) removeHandler(id int) {
+	s.mtx.Lock()
+	queue, exists := s.handlers[id]
+	delete(s.handlers, id)
+	s.mtx.Unlock()
+	if exists {
+		queue.stop()
+	}
+}
+
+func (s *sharedInformer) list() []interface{} {
+	if informer, ok := s.informer.(toolscache.SharedInformer); ok {
+		return informer.GetStore().List()
+	}
+	return nil
+}
+
+func (s *sharedInformer) OnAdd(obj interface{}) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	for _, queue := range s.handlers {
+		handler := queue.handler
+		queue.push(func() { handler.OnAdd(obj) })
+	}
+}
+
+func (s *sharedInformer) OnUpdate(oldObj, newObj interface{}) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	for _, queue := range s.handlers {
+		handler := queue.handler
+		queue.push(func() { handler.OnUpdate(oldObj, newObj) })
+	}
+}
+
+func (s *sharedInformer) OnDelete(obj interface{}) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	for _, queue := range s.handlers {
+		handler := queue.handler
+		queue.push(func() { handler.OnDelete(obj) })
+	}
+}
+
+// handlerQueue delivers the events to one handler on its own goroutine, so a handler blocked by
+// its backend doesn't hold back the other monitors of the kind. The queue is unbounded like the
+// listeners of client-go.
+type handlerQueue struct {
+	handler toolscache.ResourceEventHandler
+
+	mtx     sync.Mutex
+	events  []func()
+	notify  chan struct{}
+	stopped bool
+	done    chan struct{}
+}
+
+func newHandlerQueue(handler toolscache.ResourceEventHandler) *handlerQueue {
+	q := &handlerQueue{
+		handler: handler,
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// push returns false once the queue is stopped.
+func (q *handlerQueue) push(event func()) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.stopped {
+		return false
+	}
+	q.events = append(q.events, event)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (q *handlerQueue) run() {
+	defer close(q.done)
+	for range q.notify {
+		for {
+			q.mtx.Lock()
+			if q.stopped || len(q.events) == 0 {
+				q.mtx.Unlock()
+				break
+			}
+			event := q.events[0]
+			q.events[0] = nil
+			q.events = q.events[1:]
+			q.mtx.Unlock()
+			event()
+		}
+	}
+}
+
+// stop returns once no event is delivered anymore.
+func (q *handlerQueue) stop() {
+	q.mtx.Lock()
+	if !q.stopped {
+		q.stopped = true
+		q.events = nil
+		close(q.notify)
+	}
+	q.mtx.Unlock()
+	<-q.done
+}
+
+// handlerRegistration is the handle a job keeps to stop receiving events.
+type handlerRegistration struct {
+	registry *informerRegistry
+	gvk      schema.GroupVersionKind
+	id       int
+	queue    *handlerQueue
+	informer *sharedInformer
+	once     sync.Once
+}
+
+// Exclusive runs fn with the objects known by the informer while no event is delivered to the
+// handler, the other handlers of the kind keep receiving theirs.
+func (h *handlerRegistration) Exclusive(fn func(objs []interface{})) {
+	done := make(chan struct{})
+	// listing and queueing under the lock keeps fn between the events before and after the list
+	h.informer.mtx.Lock()
+	objs := h.informer.list()
+	queued := h.queue.push(func() {
+		defer close(done)
+		fn(objs)
+	})
+	h.informer.mtx.Unlock()
+	if !queued {
+		fn(nil)
+		return
+	}
+	// fn is dropped when the handler is removed meanwhile
+	select {
+	case <-done:
+	case <-h.queue.done:
+	}
+}
+
+// Remove returns once no event is delivered to the handler anymore.
+func (h *handlerRegistration) Remove() {
+	h.once.Do(func() {
+		h.registry.unregister(h.gvk, h.id)
+	})
+}
@@ -0,0 +1,112 @@
+package job
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func newTestSharedInformer(registry *informerRegistry, gvk schema.GroupVersionKind, stopped *bool) *sharedInformer {
+	shared := &sharedInformer{
+		cancel:   func() { *stopped = true },
+		ready:    make(chan struct{}),
+		handlers: make(map[int]*handlerQueue),
+	}
+	close(shared.ready)
+	registry.informers[gvk] = shared
+	return shared
+}
+
+// register adds handler the way Register does on a started informer.
+func register(registry *informerRegistry, gvk schema.GroupVersionKind, shared *sharedInformer, handler toolscache.ResourceEventHandler) *handlerRegistration {
+	registry.mtx.Lock()
+	shared.refs++
+	registry.mtx.Unlock()
+	registration, replayed := shared.addHandler(registry, gvk, handler)
+	<-replayed
+	return registration
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second * 3)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestHandlerRegistration_Remove(t *testing.T) {
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	stopped := false
+	registry := newInformerRegistry(ctrl.Log, nil, nil, nil)
+	shared := newTestSharedInformer(registry, gvk, &stopped)
+
+	var first, second int32
+	firstReg := register(registry, gvk, shared, toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { atomic.AddInt32(&first, 1) },
+	})
+	secondReg := register(registry, gvk, shared, toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { atomic.AddInt32(&second, 1) },
+	})
+
+	shared.OnAdd(struct{}{})
+	waitFor(t, func() bool { return atomic.LoadInt32(&first) == 1 })
+	firstReg.Remove()
+	shared.OnAdd(struct{}{})
+	waitFor(t, func() bool { return atomic.LoadInt32(&second) == 2 })
+	if atomic.LoadInt32(&first) != 1 {
+		t.Fatalf("removed handler should not receive events, got %d", first)
+	}
+	if stopped {
+		t.Fatal("informer should keep running while a handler is registered")
+	}
+
+	secondReg.Remove()
+	secondReg.Remove()
+	if !stopped {
+		t.Fatal("informer should stop with its last handler")
+	}
+	if _, exists := registry.informers[gvk]; exists {
+		t.Fatal("stopped informer should be dropped from the registry")
+	}
+}
+
+func TestSharedInformer_BlockedHandler(t *testing.T) {
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	stopped := false
+	registry := newInformerRegistry(ctrl.Log, nil, nil, nil)
+	shared := newTestSharedInformer(registry, gvk, &stopped)
+
+	unblock := make(chan struct{})
+	var blocked, other int32
+	blockedReg := register(registry, gvk, shared, toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			atomic.AddInt32(&blocked, 1)
+			<-unblock
+		},
+	})
+	otherReg := register(registry, gvk, shared, toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { atomic.AddInt32(&other, 1) },
+	})
+	defer otherReg.Remove()
+
+	for i := 0; i < 3; i++ {
+		shared.OnAdd(struct{}{})
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&other) == 3 })
+
+	// Exclusive runs after the queued events of its own handler only
+	var excluded int32
+	go otherReg.Exclusive(func([]interface{}) { atomic.StoreInt32(&excluded, 1) })
+	waitFor(t, func() bool { return atomic.LoadInt32(&excluded) == 1 })
+
+	close(unblock)
+	waitFor(t, func() bool { return atomic.LoadInt32(&blocked) == 3 })
+	blockedReg.Remove()
+}
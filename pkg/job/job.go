@@ -2,51 +2,84 @@ package job
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	toolscache "k8s.io/client-go/tools/cache"
-	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/filter"
 	"github.com/fusion-app/gateway/pkg/msg"
 	"github.com/fusion-app/gateway/pkg/prom"
 	"github.com/fusion-app/gateway/pkg/utils"
 )
 
+const (
+	statusSyncInterval        = time.Second * 30
+	defaultCheckpointInterval = time.Second * 10
+)
+
 type MonitorJob struct {
+	// MonitorSpec is replaced by Update while the status is synced, read it with spec()
 	MonitorSpec *monitorv1alpha1.ResourceMonitorSpec
+	specMtx     sync.RWMutex
 
-	monitorGVK  schema.GroupVersionKind
-	monitorName string
-	interestGVK schema.GroupVersionKind
+	monitorGVK       schema.GroupVersionKind
+	monitorNamespace string
+	monitorName      string
+	interestGVK      schema.GroupVersionKind
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	msgStore     *msg.MessageStore
-	metricWorker *prom.MetricWorker
-	resultCh     <-chan *prom.MetricResult
+	metricSource prom.MetricSource
+	metrics      []monitorv1alpha1.MetricSpec
+	resultCh     chan *prom.MetricResult
 	logger       logr.Logger
-	mgrCache     cache.Cache
+	informers    *informerRegistry
+	registration *handlerRegistration
 	mgrClient    client.Client
+
+	filter       *filter.Filter
+	filterErrors uint64
+	// filterError is the last error of the filter, a string
+	filterError atomic.Value
+	// backendError is the error building the message backend, a string
+	backendError atomic.Value
+	// handler is released when replaced or when the job is cancelled, handlerKey tells a rotated
+	// Secret of the backend
+	handler    msg.MsgHandler
+	handlerKey string
+
+	checkpoints        msg.CheckpointStore
+	checkpointInterval time.Duration
+	checkpointMtx      sync.Mutex
 }
 
-func NewMonitorJob(ref *monitorv1alpha1.ResourceMonitor, logger logr.Logger, mgrCache cache.Cache, mgrClient client.Client) *MonitorJob {
+func NewMonitorJob(ref *monitorv1alpha1.ResourceMonitor, logger logr.Logger, informers *informerRegistry, mgrClient client.Client) *MonitorJob {
 	jobContext, jobCancel := context.WithCancel(context.TODO())
 	interestGVK := ref.Spec.Selector.GVK
-	if ref.Spec.MsgBuilder.MsgSource.PrometheusSource == nil {
-		return nil
-	}
 	resultCh := make(chan *prom.MetricResult)
-	worker := prom.NewMetricWorker(jobContext, resultCh, ref.Spec.MsgBuilder.MsgSource.PrometheusSource)
-	return &MonitorJob{
-		MonitorSpec: ref.Spec.DeepCopy(),
-		monitorGVK:  ref.GroupVersionKind(),
-		monitorName: ref.GetName(),
+	metricSource, err := prom.NewMetricSource(jobContext, resultCh, &ref.Spec.MsgBuilder.MsgSource, mgrClient, ref.GetNamespace())
+	if err != nil {
+		logger.Error(err, "Build metric source failed, only object changes will be published")
+	}
+	handlerKey, _ := msg.HandlerKey(ref.Spec.MsgBackendSpec, mgrClient, ref.GetNamespace())
+	handler, handlerErr := msg.NewMsgHandlerOrExist(ref.Spec.MsgBackendSpec, mgrClient, ref.GetNamespace())
+	job := &MonitorJob{
+		MonitorSpec:      ref.Spec.DeepCopy(),
+		monitorGVK:       ref.GroupVersionKind(),
+		monitorNamespace: ref.GetNamespace(),
+		monitorName:      ref.GetName(),
 		interestGVK: schema.GroupVersionKind{
 			Group:   interestGVK.Group,
 			Version: interestGVK.Version,
@@ -54,13 +87,26 @@ func NewMonitorJob(ref *monitorv1alpha1.ResourceMonitor, logger logr.Logger, mgr
 		},
 		ctx:          jobContext,
 		cancel:       jobCancel,
-		msgStore:     msg.NewMsgStore(ref),
-		metricWorker: worker,
+		msgStore:     msg.NewMsgStore(ref, handler),
+		metricSource: metricSource,
+		metrics:      monitorMetrics(interestGVK.Kind, &ref.Spec.MsgBuilder),
 		resultCh:     resultCh,
 		logger:       logger,
-		mgrCache:     mgrCache,
+		informers:    informers,
 		mgrClient:    mgrClient,
+		handler:      handler,
+		handlerKey:   handlerKey,
 	}
+	job.filter = job.compileFilter(ref.Spec.MsgBuilder.Filter)
+	job.setBackendError(handlerErr)
+	if spec := ref.Spec.Checkpoint; spec != nil {
+		job.checkpoints = msg.NewCheckpointStore(spec, mgrClient, ref)
+		job.checkpointInterval = defaultCheckpointInterval
+		if spec.Interval != nil && spec.Interval.Duration > 0 {
+			job.checkpointInterval = spec.Interval.Duration
+		}
+	}
+	return job
 }
 
 func (j *MonitorJob) listRelatedResource() (*unstructured.UnstructuredList, error) {
@@ -68,7 +114,7 @@ func (j *MonitorJob) listRelatedResource() (*unstructured.UnstructuredList, erro
 	objList.SetAPIVersion(j.interestGVK.GroupVersion().String())
 	objList.SetKind(j.interestGVK.Kind + "List")
 	listOpt := client.MatchingLabels{}
-	for k, v := range j.MonitorSpec.Selector.Labels {
+	for k, v := range j.spec().Selector.Labels {
 		listOpt[k] = v
 	}
 	err := j.mgrClient.List(j.ctx, objList, listOpt)
@@ -80,46 +126,61 @@ func (j *MonitorJob) listRelatedResource() (*unstructured.UnstructuredList, erro
 
 func (j *MonitorJob) Start() {
 	j.updateResourceStatus()
-	informer, err := j.mgrCache.GetInformerForKind(context.TODO(), j.interestGVK)
-	if err != nil {
-		j.logger.Error(err, "Build informer failed")
-		return
-	}
-	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+	j.restoreCheckpoint()
+	registration, err := j.informers.Register(j.interestGVK, toolscache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			u := utils.ToUnstructured(obj)
 			if !j.isRelated(u) {
 				return
 			}
-			if u.GetKind() == "VirtualMachineInstance" {
-				j.metricWorker.AddQuery(u.GetNamespace(), u.GetName(), "mem_use", "kubevirt_vmi_memory_resident_bytes")
-				j.metricWorker.AddQuery(u.GetNamespace(), u.GetName(), "cpu_sec", "kubevirt_vmi_vcpu_seconds")
-			}
+			j.addMetricQueries(u)
 			j.msgStore.OnResourceAdd(obj, u)
 			j.updateResourceStatus()
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			oldU := utils.ToUnstructured(oldObj)
 			newU := utils.ToUnstructured(newObj)
-			if !j.isRelated(oldU) && !j.isRelated(newU) {
-				return
+			wasRelated, isRelated := j.isRelated(oldU), j.isRelated(newU)
+			switch {
+			case !wasRelated && isRelated:
+				j.addMetricQueries(newU)
+				j.msgStore.OnResourceAdd(newObj, newU)
+				j.updateResourceStatus()
+			case wasRelated && !isRelated:
+				j.deleteMetricQueries(newU)
+				j.msgStore.OnResourceUnselected(newU)
+				j.updateResourceStatus()
+			case isRelated:
+				// a pod has no address to scrape yet when it is added
+				j.addMetricQueries(newU)
+				if j.matchUpdate(oldU, newU) {
+					j.msgStore.OnResourceUpdate(newObj, newU)
+				}
 			}
-			j.msgStore.OnResourceUpdate(newObj, newU)
 		},
 		DeleteFunc: func(obj interface{}) {
+			obj, finalStateUnknown := utils.UnwrapTombstone(obj)
 			u := utils.ToUnstructured(obj)
 			if !j.isRelated(u) {
 				return
 			}
-
-			if u.GetKind() == "VirtualMachineInstance" {
-				j.metricWorker.DeleteQuery(u.GetNamespace(), u.GetName(), "kubevirt_vmi_memory_resident_bytes")
-				j.metricWorker.DeleteQuery(u.GetNamespace(), u.GetName(), "kubevirt_vmi_vcpu_seconds")
-			}
-			j.msgStore.OnResourceDel(obj, u)
+			j.deleteMetricQueries(u)
+			j.msgStore.OnResourceDel(obj, u, finalStateUnknown)
 			j.updateResourceStatus()
 		},
 	})
+	if err != nil {
+		j.logger.Error(err, "Build informer failed")
+		return
+	}
+	j.registration = registration
+	j.msgStore.Resynced()
+
+	go j.syncStatus()
+	go j.heartbeat()
+	if j.checkpoints != nil {
+		go j.syncCheckpoint()
+	}
 
 	go func() {
 		for {
@@ -132,13 +193,127 @@ func (j *MonitorJob) Start() {
 			}
 		}
 	}()
-	go j.metricWorker.Start(time.Second * 20)
-
+	if j.metricSource != nil {
+		go j.metricSource.Start()
+	}
 }
 
+// Cancel returns once nothing is published on behalf of the job anymore.
 func (j *MonitorJob) Cancel() {
-	j.metricWorker.Stop()
+	if j.registration != nil {
+		j.registration.Remove()
+	}
+	if j.metricSource != nil {
+		j.metricSource.Stop()
+	}
 	j.cancel()
+	j.msgStore.Close()
+	j.saveCheckpoint()
+	msg.ReleaseHandler(j.handler)
+}
+
+func (j *MonitorJob) restoreCheckpoint() {
+	if j.checkpoints == nil {
+		return
+	}
+	checkpoint, err := j.checkpoints.Load(j.ctx)
+	if err != nil {
+		j.logger.Error(err, "Load checkpoint failed, all selected resources will be published")
+		return
+	}
+	j.msgStore.Restore(checkpoint)
+}
+
+// syncCheckpoint saves the dedup state periodically while the job runs.
+func (j *MonitorJob) syncCheckpoint() {
+	ticker := time.NewTicker(j.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-ticker.C:
+			j.saveCheckpoint()
+		}
+	}
+}
+
+func (j *MonitorJob) saveCheckpoint() {
+	if j.checkpoints == nil {
+		return
+	}
+	j.checkpointMtx.Lock()
+	defer j.checkpointMtx.Unlock()
+	checkpoint := j.msgStore.Checkpoint()
+	if checkpoint == nil {
+		return
+	}
+	// the job context may be cancelled already when the final checkpoint is saved
+	ctx, cancel := context.WithTimeout(context.Background(), j.checkpointInterval)
+	defer cancel()
+	if err := j.checkpoints.Save(ctx, checkpoint); err != nil {
+		j.logger.Error(err, "Save checkpoint failed")
+		j.msgStore.CheckpointFailed()
+	}
+}
+
+// heartbeat reports the monitor to the backend while the job runs, the interval is read again
+// after each beat as the backend may change.
+func (j *MonitorJob) heartbeat() {
+	for {
+		interval := j.msgStore.HeartbeatInterval()
+		if interval == 0 {
+			interval = statusSyncInterval
+		} else {
+			j.msgStore.PublishHeartbeat()
+		}
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// syncStatus refreshes the status while the message counters move.
+func (j *MonitorJob) syncStatus() {
+	ticker := time.NewTicker(statusSyncInterval)
+	defer ticker.Stop()
+	lastStats := j.msgStore.Stats()
+	lastFilterErrors := atomic.LoadUint64(&j.filterErrors)
+	for {
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-ticker.C:
+			stats := j.msgStore.Stats()
+			filterErrors := atomic.LoadUint64(&j.filterErrors)
+			if stats.Coalesced == lastStats.Coalesced && stats.Delayed == lastStats.Delayed &&
+				stats.PublishErrors == lastStats.PublishErrors && filterErrors == lastFilterErrors {
+				continue
+			}
+			lastStats, lastFilterErrors = stats, filterErrors
+			j.updateResourceStatus()
+		}
+	}
+}
+
+func (j *MonitorJob) addMetricQueries(u *unstructured.Unstructured) {
+	if j.metricSource == nil {
+		return
+	}
+	for _, metric := range j.metrics {
+		j.metricSource.AddQuery(u, metric.Field, metric.Metric)
+	}
+}
+
+func (j *MonitorJob) deleteMetricQueries(u *unstructured.Unstructured) {
+	if j.metricSource == nil {
+		return
+	}
+	for _, metric := range j.metrics {
+		j.metricSource.DeleteQuery(u.GetNamespace(), u.GetName(), metric.Metric)
+	}
 }
 
 func (j *MonitorJob) updateResourceStatus() {
@@ -149,10 +324,19 @@ func (j *MonitorJob) updateResourceStatus() {
 	}
 
 	monitor := &monitorv1alpha1.ResourceMonitor{}
+	monitor.SetNamespace(j.monitorNamespace)
 	monitor.SetName(j.monitorName)
 
 	patch := client.MergeFrom(monitor.DeepCopy())
+	stats := j.msgStore.Stats()
 	monitor.Status.Selected = len(objList.Items)
+	monitor.Status.Coalesced = stats.Coalesced
+	monitor.Status.Delayed = stats.Delayed
+	monitor.Status.PublishErrors = stats.PublishErrors
+	monitor.Status.FilterErrors = atomic.LoadUint64(&j.filterErrors)
+	monitor.Status.FilterError, _ = j.filterError.Load().(string)
+	monitor.Status.BackendError, _ = j.backendError.Load().(string)
+	monitor.Status.IgnorePathError = j.msgStore.IgnorePathError()
 
 	if err := j.mgrClient.Status().Patch(j.ctx, monitor, patch); err != nil {
 		j.logger.Error(err, "Update monitor status failed")
@@ -160,9 +344,143 @@ func (j *MonitorJob) updateResourceStatus() {
 	}
 }
 
+// BackendRotated tells whether a Secret read by the backend changed since it was built.
+func (j *MonitorJob) BackendRotated() bool {
+	key, err := msg.HandlerKey(j.spec().MsgBackendSpec, j.mgrClient, j.monitorNamespace)
+	return err == nil && key != j.handlerKey
+}
+
+// setBackendError records err of the message backend for the status, nothing is published
+// until the spec is fixed.
+func (j *MonitorJob) setBackendError(err error) {
+	if err != nil {
+		j.logger.Error(err, "Build message backend failed, nothing will be published", "monitor", j.monitorName)
+		j.backendError.Store(err.Error())
+		return
+	}
+	j.backendError.Store("")
+}
+
 func (j *MonitorJob) isRelated(u *unstructured.Unstructured) bool {
-	selector := j.MonitorSpec.Selector
+	return isSelected(u, j.spec().Selector) && j.matchObject(j.filter, u)
+}
+
+// spec returns the current spec, it isn't modified once set.
+func (j *MonitorJob) spec() *monitorv1alpha1.ResourceMonitorSpec {
+	j.specMtx.RLock()
+	defer j.specMtx.RUnlock()
+	return j.MonitorSpec
+}
+
+// compileFilter returns nil when spec is invalid, everything is published then.
+func (j *MonitorJob) compileFilter(spec *monitorv1alpha1.FilterSpec) *filter.Filter {
+	if spec == nil {
+		j.filterError.Store("")
+		return nil
+	}
+	f, err := filter.New(spec.Object, spec.Update)
+	if err != nil {
+		j.logger.Error(err, "Compile filter failed, all selected objects will be published")
+		j.filterError.Store(err.Error())
+		return nil
+	}
+	j.filterError.Store("")
+	return f
+}
+
+// matchObject lets the object through when the filter fails, the error is reported in the status.
+func (j *MonitorJob) matchObject(f *filter.Filter, u *unstructured.Unstructured) bool {
+	matched, err := f.MatchObject(u.Object)
+	if err != nil {
+		j.onFilterError(err, u)
+		return true
+	}
+	return matched
+}
+
+func (j *MonitorJob) matchUpdate(oldU, newU *unstructured.Unstructured) bool {
+	matched, err := j.filter.MatchUpdate(oldU.Object, newU.Object)
+	if err != nil {
+		j.onFilterError(err, newU)
+		return true
+	}
+	return matched
+}
+
+func (j *MonitorJob) onFilterError(err error, u *unstructured.Unstructured) {
+	atomic.AddUint64(&j.filterErrors, 1)
+	j.filterError.Store(fmt.Sprintf("%s/%s: %v", u.GetNamespace(), u.GetName(), err))
+}
+
+func isSelected(u *unstructured.Unstructured, selector monitorv1alpha1.SelectorSpec) bool {
 	return u != nil &&
 		u.GetNamespace() == selector.Namespace &&
 		utils.MatchesLabelSelector(u.GetLabels(), selector.Labels)
 }
+
+// monitorMetrics returns the metrics polled for the selected kind, monitors without an explicit
+// list keep the built-in KubeVirt metrics or the usage reported by metrics.k8s.io.
+func monitorMetrics(kind string, builder *monitorv1alpha1.MsgBuilder) []monitorv1alpha1.MetricSpec {
+	if len(builder.Metrics) > 0 {
+		return builder.Metrics
+	}
+	switch {
+	case builder.PrometheusSource != nil && kind == "VirtualMachineInstance":
+		return []monitorv1alpha1.MetricSpec{
+			{Field: "mem_use", Metric: "kubevirt_vmi_memory_resident_bytes"},
+			{Field: "cpu_sec", Metric: "kubevirt_vmi_vcpu_seconds"},
+		}
+	case builder.ResourceMetricsSource != nil:
+		return []monitorv1alpha1.MetricSpec{
+			{Field: "cpu_use", Metric: prom.ResourceMetricCPU},
+			{Field: "mem_use", Metric: prom.ResourceMetricMemory},
+		}
+	}
+	return nil
+}
+
+// UpdatePreview renders the payload of the object named by the preview annotation of monitor into
+// its status, the preview is cleared once the annotation is removed.
+func (j *MonitorJob) UpdatePreview(monitor *monitorv1alpha1.ResourceMonitor) {
+	target := monitor.GetAnnotations()[monitorv1alpha1.PreviewAnnotation]
+	if target == "" && monitor.Status.Preview == nil {
+		return
+	}
+	patchTarget := &monitorv1alpha1.ResourceMonitor{}
+	patchTarget.SetNamespace(j.monitorNamespace)
+	patchTarget.SetName(j.monitorName)
+	var patch client.Patch
+	if target == "" {
+		patch = client.RawPatch(types.MergePatchType, []byte(`{"status":{"preview":null}}`))
+	} else {
+		patch = client.MergeFrom(patchTarget.DeepCopy())
+		patchTarget.Status.Preview = j.preview(target)
+	}
+	if err := j.mgrClient.Status().Patch(j.ctx, patchTarget, patch); err != nil {
+		j.logger.Error(err, "Update monitor preview failed")
+	}
+}
+
+func (j *MonitorJob) preview(target string) *monitorv1alpha1.PreviewStatus {
+	key := client.ObjectKey{Namespace: j.spec().Selector.Namespace, Name: target}
+	if parts := strings.SplitN(target, "/", 2); len(parts) == 2 {
+		key = client.ObjectKey{Namespace: parts[0], Name: parts[1]}
+	}
+	preview := &monitorv1alpha1.PreviewStatus{Object: key.String()}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(j.interestGVK)
+	err := j.mgrClient.Get(j.ctx, key, u)
+	if err == nil && !j.isRelated(u) {
+		err = fmt.Errorf("%s is not selected by the monitor", key)
+	}
+	var payload []byte
+	if err == nil {
+		payload, err = j.msgStore.Preview(u, u)
+	}
+	if err != nil {
+		preview.Error = err.Error()
+		return preview
+	}
+	preview.Payload = string(payload)
+	return preview
+}
@@ -3,64 +3,124 @@ package job
 import (
 	"fmt"
 	"reflect"
+	"sync"
 
 	"github.com/go-logr/logr"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
 )
 
+// MonitorJobManager is safe for concurrent reconciles, the operations on one monitor are serialized.
 type MonitorJobManager struct {
-	client client.Client
-	cache  cache.Cache
-	logger logr.Logger
+	client    client.Client
+	informers *informerRegistry
+	logger    logr.Logger
+
+	mtx sync.Mutex
 	// key: namespace/name
 	jobCache map[string]*MonitorJob
+	// key: namespace/name
+	keyLocks map[string]*keyLock
 }
 
-func NewSyncJobManager(mgrCache cache.Cache, mgrClient client.Client) *MonitorJobManager {
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func NewSyncJobManager(mgr manager.Manager) *MonitorJobManager {
+	logger := ctrl.Log.WithName("job_manager")
 	return &MonitorJobManager{
-		client:   mgrClient,
-		cache:    mgrCache,
-		logger:   ctrl.Log.WithName("job_manager"),
-		jobCache: make(map[string]*MonitorJob),
+		client:    mgr.GetClient(),
+		informers: newInformerRegistry(logger, mgr.GetConfig(), mgr.GetScheme(), mgr.GetRESTMapper()),
+		logger:    logger,
+		jobCache:  make(map[string]*MonitorJob),
+		keyLocks:  make(map[string]*keyLock),
 	}
 }
 
 func (m *MonitorJobManager) NewJobOrExist(monitorRef *monitorv1alpha1.ResourceMonitor) *MonitorJob {
 	cacheKey := jobCacheKey(monitorRef)
-	oldJob, exists := m.jobCache[cacheKey]
+	unlock := m.lockKey(cacheKey)
+	defer unlock()
+
+	oldJob, exists := m.getJob(cacheKey)
 	if !exists {
-		m.logger.Info("Create MonitorJob")
-		newJob := NewMonitorJob(monitorRef, m.logger, m.cache, m.client)
+		m.logger.Info("Create MonitorJob", "monitor", cacheKey)
+		newJob := NewMonitorJob(monitorRef, m.logger, m.informers, m.client)
 		newJob.Start()
-		m.jobCache[cacheKey] = newJob
+		m.setJob(cacheKey, newJob)
 		return newJob
 	}
 	// check whether reset old job
-	if reflect.DeepEqual(monitorRef.Spec, oldJob.MonitorSpec) {
-		m.logger.Info("Use exist MonitorJob")
+	if reflect.DeepEqual(monitorRef.Spec, *oldJob.spec()) && !oldJob.BackendRotated() {
+		m.logger.Info("Use exist MonitorJob", "monitor", cacheKey)
+		return oldJob
+	}
+	if oldJob.Update(monitorRef) {
+		m.logger.Info("Update exist MonitorJob in place", "monitor", cacheKey)
 		return oldJob
 	}
 	oldJob.Cancel()
-	m.logger.Info("Renew old MonitorJob")
-	newJob := NewMonitorJob(monitorRef, m.logger, m.cache, m.client)
+	m.logger.Info("Renew old MonitorJob", "monitor", cacheKey)
+	newJob := NewMonitorJob(monitorRef, m.logger, m.informers, m.client)
 	newJob.Start()
-	m.jobCache[cacheKey] = newJob
+	m.setJob(cacheKey, newJob)
 	return newJob
 }
 
 func (m *MonitorJobManager) CleanJob(monitorRef *monitorv1alpha1.ResourceMonitor) {
 	cacheKey := jobCacheKey(monitorRef)
-	oldJob, exists := m.jobCache[cacheKey]
+	unlock := m.lockKey(cacheKey)
+	defer unlock()
+
+	oldJob, exists := m.getJob(cacheKey)
 	if !exists {
 		return
 	}
 	oldJob.Cancel()
+	m.mtx.Lock()
 	delete(m.jobCache, cacheKey)
-	return
+	m.mtx.Unlock()
+}
+
+func (m *MonitorJobManager) getJob(cacheKey string) (*MonitorJob, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	job, exists := m.jobCache[cacheKey]
+	return job, exists
+}
+
+func (m *MonitorJobManager) setJob(cacheKey string, job *MonitorJob) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.jobCache[cacheKey] = job
+}
+
+// lockKey serializes start, restart and clean of one monitor, the returned func releases the lock.
+func (m *MonitorJobManager) lockKey(cacheKey string) func() {
+	m.mtx.Lock()
+	lock, exists := m.keyLocks[cacheKey]
+	if !exists {
+		lock = &keyLock{}
+		m.keyLocks[cacheKey] = lock
+	}
+	lock.refs++
+	m.mtx.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		m.mtx.Lock()
+		defer m.mtx.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(m.keyLocks, cacheKey)
+		}
+	}
 }
 
 func jobCacheKey(monitorRef *monitorv1alpha1.ResourceMonitor) string {
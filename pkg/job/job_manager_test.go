@@ -0,0 +1,32 @@
+package job
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMonitorJobManager_LockKey(t *testing.T) {
+	m := &MonitorJobManager{
+		jobCache: make(map[string]*MonitorJob),
+		keyLocks: make(map[string]*keyLock),
+	}
+	var wg sync.WaitGroup
+	inside := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := m.lockKey("default/monitor")
+			defer unlock()
+			inside++
+			if inside != 1 {
+				t.Errorf("operations on the same monitor interleaved")
+			}
+			inside--
+		}()
+	}
+	wg.Wait()
+	if len(m.keyLocks) != 0 {
+		t.Errorf("released key locks should be dropped, %d left", len(m.keyLocks))
+	}
+}
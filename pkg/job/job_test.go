@@ -0,0 +1,245 @@
+package job
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/msg"
+)
+
+func TestMonitorJob_Filter(t *testing.T) {
+	spec := &monitorv1alpha1.ResourceMonitorSpec{
+		Selector: monitorv1alpha1.SelectorSpec{Namespace: "default", Labels: map[string]string{"app": "test"}},
+	}
+	j := &MonitorJob{MonitorSpec: spec, logger: ctrl.Log.WithName("job")}
+	j.filter = j.compileFilter(&monitorv1alpha1.FilterSpec{Object: "object.status.phase != 'Succeeded'"})
+	if j.filter == nil {
+		t.Fatalf("expected the filter to compile, got %v", j.filterError.Load())
+	}
+
+	newPod := func(status map[string]interface{}) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+		u.SetNamespace("default")
+		u.SetName("test")
+		u.SetLabels(map[string]string{"app": "test"})
+		return u
+	}
+	if !j.isRelated(newPod(map[string]interface{}{"phase": "Running"})) {
+		t.Errorf("expected the running pod to be related")
+	}
+	if j.isRelated(newPod(map[string]interface{}{"phase": "Succeeded"})) {
+		t.Errorf("expected the succeeded pod to be filtered out")
+	}
+	if !j.isRelated(newPod(map[string]interface{}{})) {
+		t.Errorf("expected a failed evaluation to let the pod through")
+	}
+	if j.filterErrors != 1 || j.filterError.Load() == "" {
+		t.Errorf("expected the evaluation error to be recorded, got %d %v", j.filterErrors, j.filterError.Load())
+	}
+
+	if j.compileFilter(&monitorv1alpha1.FilterSpec{Object: "object.status.phase =="}) != nil ||
+		j.filterError.Load() == "" {
+		t.Errorf("expected the compile error to be recorded")
+	}
+}
+
+var podGVK = metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}
+
+func newJobTestPod(name, app string) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": app}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+// newTestJob starts a job on an informer holding pods, the informer never runs.
+func newTestJob(t *testing.T, spec monitorv1alpha1.ResourceMonitorSpec, pods ...*corev1.Pod) (*MonitorJob, *sharedInformer) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitorv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	monitor := &monitorv1alpha1.ResourceMonitor{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"},
+		Spec:       spec,
+	}
+	mgrClient := fake.NewFakeClientWithScheme(scheme, monitor)
+
+	informer := toolscache.NewSharedIndexInformer(&toolscache.ListWatch{}, &corev1.Pod{}, 0, toolscache.Indexers{})
+	for _, pod := range pods {
+		if err := informer.GetStore().Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registry := newInformerRegistry(ctrl.Log, nil, scheme, nil)
+	stopped := false
+	shared := newTestSharedInformer(registry, schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, &stopped)
+	shared.informer = informer
+
+	j := NewMonitorJob(monitor, ctrl.Log.WithName("job"), registry, mgrClient)
+	j.Start()
+	if j.registration == nil {
+		t.Fatal("job not started")
+	}
+	return j, shared
+}
+
+func subscribe(t *testing.T, j *MonitorJob) (map[string]msg.ResourceOp, *msg.Subscription) {
+	snapshot, sub, err := j.msgStore.Subscribe(msg.SubscriptionFilter{}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected := make(map[string]msg.ResourceOp)
+	for _, m := range snapshot {
+		selected[m.Meta.Name] = m.Op
+	}
+	return selected, sub
+}
+
+func receive(t *testing.T, sub *msg.Subscription) *msg.Message {
+	select {
+	case m := <-sub.C:
+		return m
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestMonitorJob_UpdateMembership(t *testing.T) {
+	spec := monitorv1alpha1.ResourceMonitorSpec{
+		Selector: monitorv1alpha1.SelectorSpec{GVK: podGVK, Namespace: "default", Labels: map[string]string{"app": "web"}},
+	}
+	j, _ := newTestJob(t, spec, newJobTestPod("web", "web"), newJobTestPod("db", "db"))
+	defer j.Cancel()
+	selected, sub := subscribe(t, j)
+	if len(selected) != 1 || selected["web"] != msg.NewResource {
+		t.Fatalf("selected = %v", selected)
+	}
+
+	spec.Selector.Labels = map[string]string{"app": "db"}
+	if !j.Update(&monitorv1alpha1.ResourceMonitor{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"}, Spec: spec}) {
+		t.Fatal("selector change not applied in place")
+	}
+	ops := make(map[string]msg.ResourceOp)
+	for i := 0; i < 2; i++ {
+		m := receive(t, sub)
+		ops[m.Meta.Name] = m.Op
+	}
+	if ops["web"] != msg.DelResource || ops["db"] != msg.NewResource {
+		t.Errorf("membership diff = %v", ops)
+	}
+
+	// the filter leaves the db pod out
+	spec.MsgBuilder.Filter = &monitorv1alpha1.FilterSpec{Object: "object.metadata.name != 'db'"}
+	if !j.Update(&monitorv1alpha1.ResourceMonitor{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"}, Spec: spec}) {
+		t.Fatal("filter change not applied in place")
+	}
+	if m := receive(t, sub); m.Meta.Name != "db" || m.Op != msg.DelResource {
+		t.Errorf("message = %s %s", m.Op, m.Meta.Name)
+	}
+}
+
+func TestMonitorJob_UpdateBackend(t *testing.T) {
+	received := make(chan string, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer server.Close()
+
+	spec := monitorv1alpha1.ResourceMonitorSpec{
+		Selector: monitorv1alpha1.SelectorSpec{GVK: podGVK, Namespace: "default"},
+	}
+	web := newJobTestPod("web", "web")
+	j, shared := newTestJob(t, spec, web)
+	defer j.Cancel()
+
+	spec.MsgBackendSpec.WebhookBackend = &monitorv1alpha1.WebhookBackendSpec{URL: server.URL}
+	if !j.Update(&monitorv1alpha1.ResourceMonitor{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"}, Spec: spec}) {
+		t.Fatal("backend change not applied in place")
+	}
+	updated := web.DeepCopy()
+	updated.Status.Phase = corev1.PodSucceeded
+	shared.OnUpdate(web, updated)
+	deadline := time.After(time.Second * 3)
+	for {
+		select {
+		case body := <-received:
+			if strings.Contains(body, `"op":"Update"`) {
+				return
+			}
+		case <-deadline:
+			t.Fatal("update not published to the new backend")
+		}
+	}
+}
+
+func TestMonitorJob_UpdateRecreate(t *testing.T) {
+	spec := monitorv1alpha1.ResourceMonitorSpec{
+		Selector: monitorv1alpha1.SelectorSpec{GVK: podGVK, Namespace: "default"},
+	}
+	j, _ := newTestJob(t, spec)
+	defer j.Cancel()
+
+	gvkChanged := spec.DeepCopy()
+	gvkChanged.Selector.GVK = metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	checkpointChanged := spec.DeepCopy()
+	checkpointChanged.Checkpoint = &monitorv1alpha1.CheckpointSpec{}
+	for _, newSpec := range []*monitorv1alpha1.ResourceMonitorSpec{gvkChanged, checkpointChanged} {
+		if j.Update(&monitorv1alpha1.ResourceMonitor{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"}, Spec: *newSpec}) {
+			t.Errorf("update of %v applied in place", newSpec)
+		}
+	}
+	if j.spec().Selector.GVK != podGVK || j.spec().Checkpoint != nil {
+		t.Errorf("spec changed by a refused update")
+	}
+}
+
+func TestMonitorJob_UpdateDuringStatusSync(t *testing.T) {
+	spec := monitorv1alpha1.ResourceMonitorSpec{
+		Selector: monitorv1alpha1.SelectorSpec{GVK: podGVK, Namespace: "default", Labels: map[string]string{"app": "web"}},
+	}
+	j, _ := newTestJob(t, spec, newJobTestPod("web", "web"), newJobTestPod("db", "db"))
+	defer j.Cancel()
+
+	done := make(chan struct{})
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				j.updateResourceStatus()
+			}
+		}
+	}()
+	for _, app := range []string{"db", "web", "db"} {
+		spec.Selector.Labels = map[string]string{"app": app}
+		if !j.Update(&monitorv1alpha1.ResourceMonitor{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"}, Spec: spec}) {
+			t.Fatal("selector change not applied in place")
+		}
+	}
+	close(done)
+	<-synced
+}
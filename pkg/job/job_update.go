@@ -0,0 +1,141 @@
+package job
+
+import (
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/filter"
+	"github.com/fusion-app/gateway/pkg/msg"
+	"github.com/fusion-app/gateway/pkg/prom"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+// Update applies a new spec to the running job without resending unchanged state, a rotated
+// Secret of the backend switches it as well. It returns false when the change can't be applied
+// in place and the job has to be rebuilt. The backend and the metric source are built before the
+// events of the job are held back, and the status is updated after.
+func (j *MonitorJob) Update(ref *monitorv1alpha1.ResourceMonitor) bool {
+	newSpec := ref.Spec.DeepCopy()
+	oldSpec := j.spec()
+	if j.registration == nil || !reflect.DeepEqual(newSpec.Selector.GVK, oldSpec.Selector.GVK) ||
+		!reflect.DeepEqual(newSpec.Checkpoint, oldSpec.Checkpoint) {
+		return false
+	}
+
+	handlerKey, _ := msg.HandlerKey(newSpec.MsgBackendSpec, j.mgrClient, j.monitorNamespace)
+	backendChanged := handlerKey != j.handlerKey
+	var (
+		handler    msg.MsgHandler
+		handlerErr error
+	)
+	if backendChanged {
+		j.logger.Info("Switch message backend", "monitor", j.monitorName)
+		handler, handlerErr = msg.NewMsgHandlerOrExist(newSpec.MsgBackendSpec, j.mgrClient, j.monitorNamespace)
+		j.setBackendError(handlerErr)
+	}
+	sourceChanged := !reflect.DeepEqual(newSpec.MsgBuilder.MsgSource, oldSpec.MsgBuilder.MsgSource)
+	metricsChanged := sourceChanged || !reflect.DeepEqual(newSpec.MsgBuilder.Metrics, oldSpec.MsgBuilder.Metrics)
+	var newSource, oldSource prom.MetricSource
+	if sourceChanged {
+		var err error
+		newSource, err = prom.NewMetricSource(j.ctx, j.resultCh, &newSpec.MsgBuilder.MsgSource, j.mgrClient, j.monitorNamespace)
+		if err != nil {
+			j.logger.Error(err, "Build metric source failed, only object changes will be published")
+		}
+	}
+
+	builderChanged := !reflect.DeepEqual(newSpec.MsgBuilder, oldSpec.MsgBuilder)
+	membershipChanged := false
+	j.registration.Exclusive(func(objs []interface{}) {
+		if backendChanged {
+			j.msgStore.SetHandler(handler)
+		}
+		if builderChanged || !reflect.DeepEqual(newSpec.RateLimit, oldSpec.RateLimit) {
+			j.msgStore.Reconfigure(newSpec)
+		}
+
+		if metricsChanged {
+			related := make([]*unstructured.Unstructured, 0, len(objs))
+			for _, obj := range objs {
+				if u := utils.ToUnstructured(obj); j.isRelated(u) {
+					related = append(related, u)
+				}
+			}
+			oldSource = j.swapMetricSource(newSpec, related, sourceChanged, newSource)
+		}
+
+		oldFilter := j.filter
+		filterChanged := !reflect.DeepEqual(newSpec.MsgBuilder.Filter, oldSpec.MsgBuilder.Filter)
+		if filterChanged {
+			j.filter = j.compileFilter(newSpec.MsgBuilder.Filter)
+		}
+
+		j.specMtx.Lock()
+		j.MonitorSpec = newSpec
+		j.specMtx.Unlock()
+		membershipChanged = filterChanged || !reflect.DeepEqual(newSpec.Selector, oldSpec.Selector)
+		if membershipChanged {
+			j.updateMembership(objs, oldSpec.Selector, oldFilter)
+		}
+	})
+
+	if backendChanged {
+		// the store publishes to the new backend only, the old one is closed with its last job
+		msg.ReleaseHandler(j.handler)
+		j.handler, j.handlerKey = handler, handlerKey
+	}
+	if sourceChanged {
+		if oldSource != nil {
+			oldSource.Stop()
+		}
+		if newSource != nil {
+			go newSource.Start()
+		}
+	}
+	// the builder carries the ignore paths and the filter reported in the status
+	if membershipChanged || backendChanged || builderChanged {
+		j.updateResourceStatus()
+	}
+	return true
+}
+
+// swapMetricSource moves the queries of the currently selected objects to the new metrics, and
+// to newSource when replaced is set. It returns the replaced source, to be stopped by the caller.
+func (j *MonitorJob) swapMetricSource(newSpec *monitorv1alpha1.ResourceMonitorSpec, related []*unstructured.Unstructured,
+	replaced bool, newSource prom.MetricSource) prom.MetricSource {
+	for _, u := range related {
+		j.deleteMetricQueries(u)
+	}
+	var oldSource prom.MetricSource
+	if replaced {
+		oldSource = j.metricSource
+		j.metricSource = newSource
+	}
+	j.metrics = monitorMetrics(j.interestGVK.Kind, &newSpec.MsgBuilder)
+	for _, u := range related {
+		j.addMetricQueries(u)
+	}
+	return oldSource
+}
+
+// updateMembership publishes New for the objects entering the selection and Delete for the leaving ones.
+func (j *MonitorJob) updateMembership(objs []interface{}, oldSelector monitorv1alpha1.SelectorSpec, oldFilter *filter.Filter) {
+	for _, obj := range objs {
+		u := utils.ToUnstructured(obj)
+		if u == nil {
+			continue
+		}
+		wasRelated := isSelected(u, oldSelector) && j.matchObject(oldFilter, u)
+		isRelated := j.isRelated(u)
+		switch {
+		case !wasRelated && isRelated:
+			j.addMetricQueries(u)
+			j.msgStore.OnResourceAdd(obj, u)
+		case wasRelated && !isRelated:
+			j.deleteMetricQueries(u)
+			j.msgStore.OnResourceUnselected(u)
+		}
+	}
+}
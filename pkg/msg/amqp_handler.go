@@ -0,0 +1,279 @@
+package msg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/streadway/amqp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/topic"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+var amqpLogger = ctrl.Log.WithName("amqp")
+
+const (
+	defaultAMQPConfirmTimeout = time.Second * 5
+	amqpMinReconnectDelay     = time.Second
+	amqpMaxReconnectDelay     = time.Second * 30
+	// amqpCloudEventsPrefix of the headers carrying the binary CloudEvents attributes
+	amqpCloudEventsPrefix = "cloudEvents_"
+)
+
+// AMQPMsgHandler publishes persistent messages with publisher confirms, one at a time. The
+// connection and the channel are recovered in the background once they are closed.
+type AMQPMsgHandler struct {
+	url            string
+	config         amqp.Config
+	exchange       string
+	exchangeType   string
+	declare        bool
+	routingKey     *template.Template
+	confirmTimeout time.Duration
+	envelope       *Envelope
+
+	mtx      sync.Mutex
+	closed   bool
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+	// recovering is set while the background recovery runs
+	recovering bool
+}
+
+// NewAMQPMsgHandler reads the credentials from the Secrets in namespace, the handler is returned
+// even if the broker is unreachable.
+func NewAMQPMsgHandler(spec *monitorv1alpha1.AMQPBackendSpec, reader client.Reader, namespace string) (*AMQPMsgHandler, error) {
+	routingKey, err := topic.Parse(spec.RoutingKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing key: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	config := amqp.Config{
+		Heartbeat:  time.Second * 10,
+		Properties: amqp.Table{"connection_name": "k8s-gateway-" + GatewayID},
+	}
+	if spec.Credentials != nil {
+		username, err := utils.SecretValue(ctx, reader, namespace, &spec.Credentials.Username)
+		if err != nil {
+			return nil, err
+		}
+		password, err := utils.SecretValue(ctx, reader, namespace, &spec.Credentials.Password)
+		if err != nil {
+			return nil, err
+		}
+		config.SASL = []amqp.Authentication{&amqp.PlainAuth{Username: string(username), Password: string(password)}}
+	}
+	if spec.TLSConfig != nil {
+		if config.TLSClientConfig, err = utils.TLSClientConfig(ctx, reader, namespace, spec.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	h := &AMQPMsgHandler{
+		url:            spec.URL,
+		config:         config,
+		exchange:       spec.Exchange,
+		exchangeType:   spec.ExchangeType,
+		declare:        spec.DeclareExchange,
+		routingKey:     routingKey,
+		confirmTimeout: defaultAMQPConfirmTimeout,
+		envelope:       NewEnvelope(spec.Envelope, spec.Encoding, true),
+	}
+	if h.exchangeType == "" {
+		h.exchangeType = amqp.ExchangeTopic
+	}
+	if spec.ConfirmTimeout != nil && spec.ConfirmTimeout.Duration > 0 {
+		h.confirmTimeout = spec.ConfirmTimeout.Duration
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if err := h.connect(); err != nil {
+		amqpLogger.Error(err, "Connect failed, retrying in the background", "url", h.url)
+		h.reconnect()
+	}
+	return h, nil
+}
+
+func (h *AMQPMsgHandler) Publish(msg *Message) error {
+	publishing, routingKey, err := h.publishing(msg)
+	if err != nil {
+		amqpLogger.Error(err, "Encode message failed")
+		return err
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.closed {
+		return fmt.Errorf("AMQP backend %s is closed", h.url)
+	}
+	if h.channel == nil {
+		// recovered in the background
+		err := fmt.Errorf("not connected to %s", h.url)
+		amqpLogger.Error(err, "Publish failed")
+		return err
+	}
+	if err := h.channel.Publish(h.exchange, routingKey, false, false, *publishing); err != nil {
+		amqpLogger.Error(err, "Publish failed", "routingKey", routingKey)
+		h.reset()
+		return err
+	}
+	select {
+	case confirm, ok := <-h.confirms:
+		if !ok {
+			return fmt.Errorf("channel closed before the confirm")
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("message nacked by the broker")
+		}
+		return nil
+	case <-time.After(h.confirmTimeout):
+		// a late confirm would be taken for the next message
+		h.reset()
+		return fmt.Errorf("no confirm within %s", h.confirmTimeout)
+	}
+}
+
+func (h *AMQPMsgHandler) publishing(msg *Message) (*amqp.Publishing, string, error) {
+	encoded, err := h.envelope.Encode(msg)
+	if err != nil {
+		return nil, "", err
+	}
+	routingKey, err := renderTopic(h.routingKey, msg, routingKeyWord)
+	if err != nil {
+		return nil, "", err
+	}
+	publishing := &amqp.Publishing{
+		ContentType:     encoded.ContentType,
+		ContentEncoding: encoded.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Timestamp:       time.Now(),
+		AppId:           "k8s-gateway",
+		Body:            encoded.Body,
+	}
+	if msg.Meta != nil {
+		publishing.MessageId = messageID(msg.Meta)
+	}
+	if len(encoded.Attributes) > 0 {
+		publishing.Headers = make(amqp.Table, len(encoded.Attributes))
+		for name, value := range encoded.Attributes {
+			publishing.Headers[amqpCloudEventsPrefix+name] = value
+		}
+	}
+	return publishing, routingKey, nil
+}
+
+// routingKeyWord replaces the characters separating and matching the words of a routing key.
+func routingKeyWord(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return strings.NewReplacer(".", "_", "*", "_", "#", "_").Replace(value)
+}
+
+// connect opens the connection and a channel in confirm mode, h.mtx must be held by the caller.
+func (h *AMQPMsgHandler) connect() error {
+	if h.conn == nil || h.conn.IsClosed() {
+		conn, err := amqp.DialConfig(h.url, h.config)
+		if err != nil {
+			return err
+		}
+		h.conn = conn
+		h.watch(conn.NotifyClose(make(chan *amqp.Error, 1)), conn, nil)
+	}
+	channel, err := h.conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return err
+	}
+	if h.declare {
+		if err := channel.ExchangeDeclare(h.exchange, h.exchangeType, true, false, false, false, nil); err != nil {
+			channel.Close()
+			return err
+		}
+	}
+	h.channel = channel
+	h.confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	h.watch(channel.NotifyClose(make(chan *amqp.Error, 1)), h.conn, channel)
+	amqpLogger.Info("Connected", "url", h.url, "exchange", h.exchange)
+	return nil
+}
+
+// watch recovers once the connection, or the channel if not nil, is closed. The close of an
+// outdated connection or channel is ignored.
+func (h *AMQPMsgHandler) watch(closed chan *amqp.Error, conn *amqp.Connection, channel *amqp.Channel) {
+	go func() {
+		amqpErr, ok := <-closed
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		if h.closed || conn != h.conn || channel != nil && channel != h.channel {
+			return
+		}
+		if ok {
+			amqpLogger.Error(amqpErr, "Connection closed", "url", h.url)
+		}
+		h.channel = nil
+		h.reconnect()
+	}()
+}
+
+// reset drops the channel after a failed publish, h.mtx must be held by the caller.
+func (h *AMQPMsgHandler) reset() {
+	if h.channel != nil {
+		channel := h.channel
+		h.channel = nil
+		channel.Close()
+	}
+	h.reconnect()
+}
+
+// reconnect in the background with a growing delay, h.mtx must be held by the caller.
+func (h *AMQPMsgHandler) reconnect() {
+	if h.recovering {
+		return
+	}
+	h.recovering = true
+	go func() {
+		delay := amqpMinReconnectDelay
+		for {
+			time.Sleep(delay)
+			h.mtx.Lock()
+			if h.closed || h.channel != nil {
+				h.recovering = false
+				h.mtx.Unlock()
+				return
+			}
+			err := h.connect()
+			if err == nil {
+				h.recovering = false
+				h.mtx.Unlock()
+				return
+			}
+			h.mtx.Unlock()
+			amqpLogger.Error(err, "Reconnect failed", "url", h.url, "delay", delay)
+			if delay *= 2; delay > amqpMaxReconnectDelay {
+				delay = amqpMaxReconnectDelay
+			}
+		}
+	}()
+}
+
+func (h *AMQPMsgHandler) Close() error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.closed = true
+	if h.conn == nil {
+		return nil
+	}
+	return h.conn.Close()
+}
@@ -0,0 +1,57 @@
+package msg
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/topic"
+)
+
+func TestAMQPMsgHandler_Publishing(t *testing.T) {
+	routingKey, err := topic.Parse("{{lower .Kind}}.{{.Namespace}}.{{.Name}}.{{lower .Op}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &AMQPMsgHandler{
+		routingKey: routingKey,
+		envelope: NewEnvelope(&monitorv1alpha1.EnvelopeSpec{
+			Format: monitorv1alpha1.CloudEventsEnvelope,
+			Mode:   monitorv1alpha1.BinaryMode,
+		}, nil, true),
+	}
+	msg := &Message{
+		Op:   UpdateResource,
+		Meta: &ResourceMeta{SchemaID: "kubevirt.io/v1alpha3/VirtualMachineInstance", Namespace: "default", Name: "droid-14", UID: "42", Seq: 3},
+		Data: []byte(`{}`),
+	}
+	publishing, key, err := h.publishing(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "virtualmachineinstance.default.droid-14.update" {
+		t.Errorf("routing key = %s", key)
+	}
+	if publishing.DeliveryMode != amqp.Persistent || publishing.MessageId != "42:3" || publishing.ContentType != jsonContentType {
+		t.Errorf("publishing = %+v", publishing)
+	}
+	if publishing.Headers["cloudEvents_subject"] != "default/droid-14" {
+		t.Errorf("headers = %v", publishing.Headers)
+	}
+}
+
+func TestAMQPMsgHandler_Unreachable(t *testing.T) {
+	h, err := NewAMQPMsgHandler(&monitorv1alpha1.AMQPBackendSpec{
+		URL:        "amqp://127.0.0.1:1/",
+		Exchange:   "k8s",
+		RoutingKey: "{{.Name}}",
+	}, nil, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.Publish(newTestMessage("web")); err == nil {
+		t.Errorf("Publish succeeded without a broker")
+	}
+}
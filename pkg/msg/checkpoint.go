@@ -0,0 +1,241 @@
+package msg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+const checkpointDataKey = "checkpoint.json"
+
+// Checkpoint is the dedup state of a MessageStore, enough to resume publishing after a restart.
+type Checkpoint struct {
+	SchemaID string `json:"schemaID"`
+	// key: namespace/name
+	Resources map[string]*ResourceCheckpoint `json:"resources"`
+}
+
+type ResourceCheckpoint struct {
+	UID types.UID `json:"uid,omitempty"`
+	// Hash of the object without ignored fields
+	Hash string `json:"hash"`
+	Seq  uint64 `json:"seq"`
+	// key: transition name
+	Transitions map[string]*TransitionState `json:"transitions,omitempty"`
+}
+
+type CheckpointStore interface {
+	// Load returns nil without error when no checkpoint was written yet
+	Load(ctx context.Context) (*Checkpoint, error)
+	Save(ctx context.Context, checkpoint *Checkpoint) error
+}
+
+// NewCheckpointStore builds the store configured in spec, ConfigMaps and Secrets are owned by the monitor.
+func NewCheckpointStore(spec *monitorv1alpha1.CheckpointSpec, c client.Client, monitor *monitorv1alpha1.ResourceMonitor) CheckpointStore {
+	owner := metav1.NewControllerRef(monitor, monitorv1alpha1.GroupVersion.WithKind("ResourceMonitor"))
+	switch {
+	case spec.ConfigMap != "":
+		return &objectCheckpointStore{client: c, key: client.ObjectKey{Namespace: monitor.GetNamespace(), Name: spec.ConfigMap}, owner: owner}
+	case spec.Secret != "":
+		return &objectCheckpointStore{client: c, key: client.ObjectKey{Namespace: monitor.GetNamespace(), Name: spec.Secret}, owner: owner, secret: true}
+	case spec.Path != "":
+		return &fileCheckpointStore{path: spec.Path}
+	}
+	return nil
+}
+
+// objectCheckpointStore keeps the checkpoint in a ConfigMap or a Secret.
+type objectCheckpointStore struct {
+	client client.Client
+	key    client.ObjectKey
+	owner  *metav1.OwnerReference
+	secret bool
+}
+
+func (s *objectCheckpointStore) Load(ctx context.Context) (*Checkpoint, error) {
+	var data []byte
+	if s.secret {
+		secret := &corev1.Secret{}
+		if err := s.client.Get(ctx, s.key, secret); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		data = secret.Data[checkpointDataKey]
+	} else {
+		configMap := &corev1.ConfigMap{}
+		if err := s.client.Get(ctx, s.key, configMap); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		data = []byte(configMap.Data[checkpointDataKey])
+	}
+	return decodeCheckpoint(data)
+}
+
+func (s *objectCheckpointStore) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	var obj client.Object
+	if s.secret {
+		obj = &corev1.Secret{Data: map[string][]byte{checkpointDataKey: data}}
+	} else {
+		obj = &corev1.ConfigMap{Data: map[string]string{checkpointDataKey: string(data)}}
+	}
+	obj.SetNamespace(s.key.Namespace)
+	obj.SetName(s.key.Name)
+	obj.SetOwnerReferences([]metav1.OwnerReference{*s.owner})
+
+	err = s.client.Update(ctx, obj)
+	if apierrors.IsNotFound(err) {
+		return s.client.Create(ctx, obj)
+	}
+	return err
+}
+
+// fileCheckpointStore keeps the checkpoint in a file of a local volume.
+type fileCheckpointStore struct {
+	path string
+}
+
+func (s *fileCheckpointStore) Load(ctx context.Context) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeCheckpoint(data)
+}
+
+func (s *fileCheckpointStore) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	// write then rename, so a crash never leaves a truncated checkpoint
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+func decodeCheckpoint(data []byte) (*Checkpoint, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// Restore seeds the dedup state from a checkpoint, it must be called before any resource is added.
+func (s *MessageStore) Restore(checkpoint *Checkpoint) {
+	if checkpoint == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.restoredSchemaID = checkpoint.SchemaID
+	s.restored = make(map[string]*ResourceCheckpoint, len(checkpoint.Resources))
+	for key, resource := range checkpoint.Resources {
+		s.restored[key] = resource
+	}
+}
+
+// Resynced publishes a Delete for every restored resource which did not show up again, it is
+// called once the informer replayed the existing objects.
+func (s *MessageStore) Resynced() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for key, restored := range s.restored {
+		namespace, name := splitResourceKey(key)
+		meta := s.resourceMeta(namespace, name, nil)
+		meta.SchemaID = s.restoredSchemaID
+		meta.UID = restored.UID
+		msg := &Message{
+			Op:   DelResource,
+			Meta: meta.stamped(restored.Seq + 1),
+		}
+		s.publish(msg)
+		s.dirty = true
+	}
+	s.restored = nil
+}
+
+// Checkpoint returns a snapshot of the dedup state, or nil when nothing changed since the last call.
+func (s *MessageStore) Checkpoint() *Checkpoint {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	checkpoint := &Checkpoint{
+		SchemaID:  s.schemaID,
+		Resources: make(map[string]*ResourceCheckpoint, len(s.cache)+len(s.restored)),
+	}
+	for key, restored := range s.restored {
+		checkpoint.Resources[key] = restored
+	}
+	for key, msgCache := range s.cache {
+		meta := msgCache.Message.Meta
+		resource := &ResourceCheckpoint{
+			UID:  meta.UID,
+			Hash: msgCache.hash,
+			Seq:  msgCache.seq,
+			// copied, the store keeps updating its states after the snapshot
+			Transitions: copyTransitions(msgCache.transitions),
+		}
+		if _, exists := s.pending[key]; exists {
+			// the latest state was not published yet, force an Update after a restart
+			resource.Hash = ""
+		}
+		checkpoint.Resources[resourceKey(meta.Namespace, meta.Name)] = resource
+	}
+	return checkpoint
+}
+
+// CheckpointFailed keeps the state dirty, so the next Checkpoint call returns a snapshot again.
+func (s *MessageStore) CheckpointFailed() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.dirty = true
+}
+
+func splitResourceKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) < 2 {
+		return "", key
+	}
+	return parts[0], parts[1]
+}
+
+func copyTransitions(transitions map[string]*TransitionState) map[string]*TransitionState {
+	if transitions == nil {
+		return nil
+	}
+	copied := make(map[string]*TransitionState, len(transitions))
+	for name, state := range transitions {
+		stateCopy := *state
+		copied[name] = &stateCopy
+	}
+	return copied
+}
@@ -0,0 +1,84 @@
+package msg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageStore_RestoreCheckpoint(t *testing.T) {
+	handler := &recordHandler{}
+	store := newTestStore(handler)
+	obj := newTestObject("Running")
+	store.OnResourceAdd(obj, obj)
+	checkpoint := store.Checkpoint()
+	if checkpoint == nil || len(checkpoint.Resources) != 1 {
+		t.Fatalf("expected a checkpoint of 1 resource, got %+v", checkpoint)
+	}
+	if store.Checkpoint() != nil {
+		t.Errorf("expected no checkpoint while nothing changed")
+	}
+	checkpoint.Resources["default/gone"] = &ResourceCheckpoint{Hash: "stale", Seq: 3}
+
+	handler = &recordHandler{}
+	store = newTestStore(handler)
+	store.Restore(checkpoint)
+	store.OnResourceAdd(obj, obj)
+	if handler.count() != 0 {
+		t.Fatalf("expected the unchanged resource to be skipped, got %d messages", handler.count())
+	}
+	store.Resynced()
+	if handler.count() != 1 {
+		t.Fatalf("expected a Delete of the vanished resource, got %d messages", handler.count())
+	}
+	msg := map[string]interface{}{}
+	if err := json.Unmarshal(handler.msgs[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["op"] != string(DelResource) {
+		t.Errorf("expected op %s, got %v", DelResource, msg["op"])
+	}
+
+	handler = &recordHandler{}
+	store = newTestStore(handler)
+	store.Restore(checkpoint)
+	changed := newTestObject("Succeeded")
+	store.OnResourceAdd(changed, changed)
+	if handler.count() != 1 {
+		t.Fatalf("expected an Update of the changed resource, got %d messages", handler.count())
+	}
+	if err := json.Unmarshal(handler.msgs[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["op"] != string(UpdateResource) {
+		t.Errorf("expected op %s, got %v", UpdateResource, msg["op"])
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := &fileCheckpointStore{path: filepath.Join(dir, "monitor", "checkpoint.json")}
+
+	checkpoint, err := store.Load(context.TODO())
+	if err != nil || checkpoint != nil {
+		t.Fatalf("expected no checkpoint, got %+v, %v", checkpoint, err)
+	}
+	saved := &Checkpoint{SchemaID: "pod", Resources: map[string]*ResourceCheckpoint{"default/test": {Hash: "h", Seq: 2}}}
+	if err := store.Save(context.TODO(), saved); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err = store.Load(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.SchemaID != "pod" || checkpoint.Resources["default/test"].Seq != 2 {
+		t.Errorf("unexpected checkpoint %+v", checkpoint)
+	}
+}
@@ -0,0 +1,201 @@
+package msg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+const (
+	msgpackContentType  = "application/msgpack"
+	cborContentType     = "application/cbor"
+	protobufContentType = "application/x-protobuf"
+)
+
+// zstdEncoder is safe for concurrent EncodeAll calls.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// Encoding converts the payloads of messages and compresses them.
+type Encoding struct {
+	format      monitorv1alpha1.EncodingFormat
+	compression monitorv1alpha1.Compression
+
+	mtx sync.Mutex
+	// key: hash of the JSON Schema
+	protoSchemas map[string]*protoSchema
+}
+
+// encodedPayload is the data of a message in the encoding of the backend.
+type encodedPayload struct {
+	data            []byte
+	contentType     string
+	contentEncoding string
+}
+
+func NewEncoding(spec *monitorv1alpha1.EncodingSpec) *Encoding {
+	e := &Encoding{
+		format:       monitorv1alpha1.JSONEncoding,
+		compression:  monitorv1alpha1.NoCompression,
+		protoSchemas: make(map[string]*protoSchema),
+	}
+	if spec == nil {
+		return e
+	}
+	if spec.Format != "" {
+		e.format = spec.Format
+	}
+	if spec.Compression != "" {
+		e.compression = spec.Compression
+	}
+	return e
+}
+
+func (e *Encoding) isPlainJSON() bool {
+	return e.format == monitorv1alpha1.JSONEncoding && e.compression == monitorv1alpha1.NoCompression
+}
+
+// encode converts the data of msg, the RegisterSchema message stays JSON and describes the encoding.
+func (e *Encoding) encode(msg *Message) (*encodedPayload, error) {
+	if msg.Op == RegisterSchema {
+		data, err := e.describe(msg.Data)
+		return &encodedPayload{data: data, contentType: jsonContentType}, err
+	}
+	if len(msg.Data) == 0 {
+		return &encodedPayload{}, nil
+	}
+	if !json.Valid(msg.Data) {
+		// e.g. rendered by a template, only compressed
+		return e.compress(&encodedPayload{data: msg.Data, contentType: octetStreamContentType})
+	}
+	if e.format == monitorv1alpha1.JSONEncoding {
+		return e.compress(&encodedPayload{data: msg.Data, contentType: jsonContentType})
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(msg.Data, &value); err != nil {
+		return nil, err
+	}
+	payload := &encodedPayload{}
+	var err error
+	switch e.format {
+	case monitorv1alpha1.MessagePackEncoding:
+		payload.contentType = msgpackContentType
+		payload.data, err = msgpack.Marshal(value)
+	case monitorv1alpha1.CBOREncoding:
+		payload.contentType = cborContentType
+		payload.data, err = cbor.Marshal(value)
+	case monitorv1alpha1.ProtobufEncoding:
+		var schema *protoSchema
+		if isResourceOp(msg.Op) && msg.schema != nil {
+			if schema, err = e.protoSchema(msg.schema); err != nil {
+				return nil, err
+			}
+		}
+		payload.contentType = protobufContentType + "; messageType=" + protoValueType
+		if schema != nil {
+			payload.contentType = protobufContentType + "; messageType=" + schema.fullName()
+			payload.data, err = marshalProto(schema.message, value)
+		} else {
+			payload.data, err = marshalProto(nil, value)
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", e.format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.compress(payload)
+}
+
+func (e *Encoding) compress(payload *encodedPayload) (*encodedPayload, error) {
+	switch e.compression {
+	case monitorv1alpha1.GzipCompression:
+		buf := &bytes.Buffer{}
+		writer := gzip.NewWriter(buf)
+		if _, err := writer.Write(payload.data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		payload.data = buf.Bytes()
+	case monitorv1alpha1.ZstdCompression:
+		payload.data = zstdEncoder.EncodeAll(payload.data, nil)
+	}
+	payload.contentEncoding = e.contentEncoding()
+	return payload, nil
+}
+
+func (e *Encoding) contentEncoding() string {
+	switch e.compression {
+	case monitorv1alpha1.GzipCompression:
+		return "gzip"
+	case monitorv1alpha1.ZstdCompression:
+		return "zstd"
+	}
+	return ""
+}
+
+func (e *Encoding) protoSchema(schemaData []byte) (*protoSchema, error) {
+	sum := sha256.Sum256(schemaData)
+	key := string(sum[:])
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if schema, exists := e.protoSchemas[key]; exists {
+		return schema, nil
+	}
+	schema, err := newProtoSchema(schemaData)
+	if err != nil {
+		return nil, fmt.Errorf("generate protobuf from the schema: %v", err)
+	}
+	e.protoSchemas[key] = schema
+	return schema, nil
+}
+
+// schemaEncoding is added to the registered JSON Schema as "x-encoding".
+type schemaEncoding struct {
+	Format          monitorv1alpha1.EncodingFormat `json:"format"`
+	ContentEncoding string                         `json:"contentEncoding,omitempty"`
+	// MessageType and FileDescriptor describe the resource messages of the Protobuf format, the
+	// other messages are google.protobuf.Value
+	MessageType    string `json:"messageType,omitempty"`
+	FileDescriptor []byte `json:"fileDescriptor,omitempty"`
+}
+
+// describe adds the encoding to the JSON Schema, the schema is unchanged for plain JSON.
+func (e *Encoding) describe(schemaData []byte) ([]byte, error) {
+	if e.isPlainJSON() {
+		return schemaData, nil
+	}
+	encoding := &schemaEncoding{Format: e.format, ContentEncoding: e.contentEncoding()}
+	if e.format == monitorv1alpha1.ProtobufEncoding {
+		schema, err := e.protoSchema(schemaData)
+		if err != nil {
+			return nil, err
+		}
+		encoding.MessageType = schema.fullName()
+		if encoding.FileDescriptor, err = proto.Marshal(schema.file); err != nil {
+			return nil, err
+		}
+	}
+	schema := map[string]interface{}{}
+	if err := json.Unmarshal(schemaData, &schema); err != nil {
+		return nil, err
+	}
+	schema["x-encoding"] = encoding
+	return json.Marshal(schema)
+}
+
+func isResourceOp(op ResourceOp) bool {
+	return op == NewResource || op == UpdateResource || op == DelResource
+}
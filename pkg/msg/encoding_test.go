@@ -0,0 +1,127 @@
+package msg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+func TestEncoding_MessagePackGzip(t *testing.T) {
+	encoding := NewEncoding(&monitorv1alpha1.EncodingSpec{
+		Format:      monitorv1alpha1.MessagePackEncoding,
+		Compression: monitorv1alpha1.GzipCompression,
+	})
+	payload, err := encoding.encode(&Message{Op: UpdateResource, Data: []byte(`{"status":{"phase":"Running"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.contentType != msgpackContentType || payload.contentEncoding != "gzip" {
+		t.Errorf("unexpected content type %s and encoding %s", payload.contentType, payload.contentEncoding)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(payload.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := map[string]interface{}{}
+	if err := msgpack.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := decoded["status"].(map[string]interface{}); status["phase"] != "Running" {
+		t.Errorf("unexpected payload %v", decoded)
+	}
+}
+
+func TestEncoding_Protobuf(t *testing.T) {
+	schemaData, err := jsonschema.Reflect(&corev1.Pod{}).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Labels: map[string]string{"app": "test"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:          "app",
+			Ports:         []corev1.ContainerPort{{ContainerPort: 8080}},
+			LivenessProbe: &corev1.Probe{Handler: corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromInt(8080)}}},
+		}}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	podData, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoding := NewEncoding(&monitorv1alpha1.EncodingSpec{Format: monitorv1alpha1.ProtobufEncoding})
+
+	schemaPayload, err := encoding.encode(&Message{Op: RegisterSchema, Data: schemaData})
+	if err != nil {
+		t.Fatal(err)
+	}
+	described := &struct {
+		Encoding *schemaEncoding `json:"x-encoding"`
+	}{}
+	if err := json.Unmarshal(schemaPayload.data, described); err != nil {
+		t.Fatal(err)
+	}
+	if described.Encoding == nil || described.Encoding.MessageType != "fusionapp.gateway.Pod" ||
+		len(described.Encoding.FileDescriptor) == 0 {
+		t.Fatalf("expected the schema to describe the encoding, got %+v", described.Encoding)
+	}
+
+	withMetrics, err := (&Message{Op: UpdateResource, Data: podData}).withExtras(map[string]interface{}{"cpu_use": 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := encoding.encode(&Message{Op: UpdateResource, Data: withMetrics.Data, schema: schemaData})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := encoding.protoSchema(schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := dynamicpb.NewMessage(schema.message)
+	if err := proto.Unmarshal(payload.data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	status := decoded.Get(schema.message.Fields().ByJSONName("status")).Message()
+	if phase := status.Get(status.Descriptor().Fields().ByJSONName("phase")).String(); phase != "Running" {
+		t.Errorf("expected phase Running, got %q", phase)
+	}
+	metadata := decoded.Get(schema.message.Fields().ByJSONName("metadata")).Message()
+	labels := metadata.Get(metadata.Descriptor().Fields().ByJSONName("labels")).Message()
+	if labels.Descriptor().FullName() != protoreflect.FullName(protoValueType) {
+		t.Errorf("expected the free-form labels as %s, got %s", protoValueType, labels.Descriptor().FullName())
+	}
+	extrasField := schema.message.Fields().ByJSONName("extras")
+	if extrasField == nil {
+		t.Fatal("expected an extras field in the root message")
+	}
+	extrasData, err := proto.Marshal(decoded.Get(extrasField).Message().Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+	extras := &structpb.Value{}
+	if err := proto.Unmarshal(extrasData, extras); err != nil {
+		t.Fatal(err)
+	}
+	if cpu := extras.GetStructValue().AsMap()["cpu_use"]; cpu != 0.5 {
+		t.Errorf("expected the metrics in extras, got %v", extras)
+	}
+}
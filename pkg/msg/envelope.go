@@ -0,0 +1,200 @@
+package msg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsTypePrefix  = "io.fusion-app."
+	cloudEventsContentType = "application/cloudevents+json"
+	jsonContentType        = "application/json"
+	octetStreamContentType = "application/octet-stream"
+)
+
+// Encoded is a message ready to be sent by a backend.
+type Encoded struct {
+	ContentType     string
+	ContentEncoding string
+	// Attributes are the CloudEvents context attributes of the binary mode, without the prefix of
+	// the protocol binding
+	Attributes map[string]string
+	Body       []byte
+
+	// dropped is the callback of the encoded message
+	dropped func()
+}
+
+// Envelope encodes messages in the format configured for a backend.
+type Envelope struct {
+	format   monitorv1alpha1.EnvelopeFormat
+	mode     monitorv1alpha1.CloudEventsMode
+	source   string
+	encoding *Encoding
+}
+
+// NewEnvelope builds the envelope of spec around payloads in the given encoding, binary is false
+// for backends without headers.
+func NewEnvelope(spec *monitorv1alpha1.EnvelopeSpec, encoding *monitorv1alpha1.EncodingSpec, binary bool) *Envelope {
+	e := &Envelope{
+		format:   monitorv1alpha1.MessageEnvelope,
+		mode:     monitorv1alpha1.StructuredMode,
+		encoding: NewEncoding(encoding),
+	}
+	if spec == nil {
+		return e
+	}
+	if spec.Format != "" {
+		e.format = spec.Format
+	}
+	if spec.Mode == monitorv1alpha1.BinaryMode {
+		if binary {
+			e.mode = monitorv1alpha1.BinaryMode
+		} else {
+			msgLogger.Info("The backend has no headers, CloudEvents are sent in structured mode")
+		}
+	}
+	e.source = spec.Source
+	return e
+}
+
+func (e *Envelope) Encode(msg *Message) (*Encoded, error) {
+	payload, err := e.encoding.encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	if e.format == monitorv1alpha1.CloudEventsEnvelope {
+		return e.encodeCloudEvent(msg, payload)
+	}
+
+	encodedMsg := &rawJSONMessage{
+		Op:     msg.Op,
+		Meta:   msg.Meta,
+		Reason: msg.Reason,
+	}
+	if !payload.isJSON() {
+		encodedMsg.ContentType = payload.contentType
+		encodedMsg.ContentEncoding = payload.contentEncoding
+	}
+	switch {
+	case len(payload.data) == 0:
+	case e.format == monitorv1alpha1.RawJSONEnvelope && payload.isJSON():
+		encodedMsg.Data = payload.data
+	case e.format == monitorv1alpha1.RawJSONEnvelope && payload.contentType == octetStreamContentType &&
+		payload.contentEncoding == "":
+		// e.g. rendered by a template, embedded as a string
+		encodedMsg.Data, _ = json.Marshal(string(payload.data))
+	default:
+		// base64 as the data of a Message
+		encodedMsg.Data, _ = json.Marshal(payload.data)
+	}
+	body, err := json.Marshal(encodedMsg)
+	return &Encoded{ContentType: jsonContentType, Body: body}, err
+}
+
+// rawJSONMessage is a Message with the data embedded as any JSON value.
+type rawJSONMessage struct {
+	Op              ResourceOp      `json:"op"`
+	Meta            *ResourceMeta   `json:"meta,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	Reason          DeleteReason    `json:"reason,omitempty"`
+	ContentType     string          `json:"content_type,omitempty"`
+	ContentEncoding string          `json:"content_encoding,omitempty"`
+}
+
+func (p *encodedPayload) isJSON() bool {
+	return p.contentType == jsonContentType && p.contentEncoding == ""
+}
+
+func (e *Envelope) encodeCloudEvent(msg *Message, payload *encodedPayload) (*Encoded, error) {
+	attributes := e.cloudEventAttributes(msg)
+	if payload.contentEncoding != "" {
+		attributes["contentencoding"] = payload.contentEncoding
+	}
+	if e.mode == monitorv1alpha1.BinaryMode {
+		return &Encoded{
+			ContentType:     payload.contentType,
+			ContentEncoding: payload.contentEncoding,
+			Attributes:      attributes,
+			Body:            payload.data,
+		}, nil
+	}
+
+	event := make(map[string]interface{}, len(attributes)+2)
+	for name, value := range attributes {
+		event[name] = value
+	}
+	if len(payload.data) > 0 {
+		event["datacontenttype"] = payload.contentType
+		if payload.isJSON() {
+			event["data"] = json.RawMessage(payload.data)
+		} else {
+			event["data_base64"] = payload.data
+		}
+	}
+	body, err := json.Marshal(event)
+	return &Encoded{ContentType: cloudEventsContentType, Body: body}, err
+}
+
+// cloudEventAttributes derives the type from the op and the kind, and the subject from namespace/name.
+func (e *Envelope) cloudEventAttributes(msg *Message) map[string]string {
+	attributes := map[string]string{
+		"specversion": cloudEventsSpecVersion,
+		"type":        cloudEventsTypePrefix + strings.ToLower(string(msg.Op)),
+	}
+	meta := msg.Meta
+	if meta == nil {
+		attributes["id"] = uuid.New().String()
+		attributes["source"] = e.eventSource(nil)
+		return attributes
+	}
+	if kind := schemaKind(meta.SchemaID); kind != "" {
+		attributes["type"] = fmt.Sprintf("%s%s.%s", cloudEventsTypePrefix, strings.ToLower(kind), strings.ToLower(string(msg.Op)))
+	}
+	attributes["id"] = messageID(meta)
+	attributes["source"] = e.eventSource(meta)
+	attributes["subject"] = resourceKey(meta.Namespace, meta.Name)
+	if !meta.Timestamp.IsZero() {
+		attributes["time"] = meta.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	attributes["sequence"] = fmt.Sprintf("%d", meta.Seq)
+	if meta.ResourceVersion != "" {
+		attributes["resourceversion"] = meta.ResourceVersion
+	}
+	if msg.Reason != "" {
+		attributes["reason"] = string(msg.Reason)
+	}
+	return attributes
+}
+
+// messageID is <UID>:<seq>, or <namespace>/<name>:<seq> without UID, stable across redeliveries
+// of the same message.
+func messageID(meta *ResourceMeta) string {
+	resourceID := string(meta.UID)
+	if resourceID == "" {
+		resourceID = resourceKey(meta.Namespace, meta.Name)
+	}
+	return fmt.Sprintf("%s:%d", resourceID, meta.Seq)
+}
+
+func (e *Envelope) eventSource(meta *ResourceMeta) string {
+	if e.source != "" {
+		return e.source
+	}
+	if meta == nil {
+		return fmt.Sprintf("/gateways/%s", GatewayID)
+	}
+	return fmt.Sprintf("/gateways/%s/monitors/%s/%s", meta.GatewayID, meta.MonitorNamespace, meta.MonitorName)
+}
+
+// schemaKind returns the kind of a schema ID such as "v1/Pod".
+func schemaKind(schemaID string) string {
+	return schemaID[strings.LastIndex(schemaID, "/")+1:]
+}
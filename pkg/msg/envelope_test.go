@@ -0,0 +1,87 @@
+package msg
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+func newEnvelopeTestMessage() *Message {
+	return &Message{
+		Op: UpdateResource,
+		Meta: &ResourceMeta{
+			SchemaID:         "v1/Pod",
+			Namespace:        "default",
+			Name:             "test",
+			UID:              "uid-1",
+			Timestamp:        time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			MonitorNamespace: "default",
+			MonitorName:      "monitor",
+			GatewayID:        "gateway-0",
+			Seq:              3,
+		},
+		Data: []byte(`{"status":{"phase":"Running"}}`),
+	}
+}
+
+func TestEnvelope_RawJSON(t *testing.T) {
+	envelope := NewEnvelope(&monitorv1alpha1.EnvelopeSpec{Format: monitorv1alpha1.RawJSONEnvelope}, nil, false)
+	encoded, err := envelope.Encode(newEnvelopeTestMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.Unmarshal(encoded.Body, msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.Data["status"]; !ok {
+		t.Errorf("expected the data embedded as JSON, got %s", encoded.Body)
+	}
+}
+
+func TestEnvelope_CloudEvents(t *testing.T) {
+	spec := &monitorv1alpha1.EnvelopeSpec{Format: monitorv1alpha1.CloudEventsEnvelope}
+	encoded, err := NewEnvelope(spec, nil, true).Encode(newEnvelopeTestMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded.ContentType != cloudEventsContentType {
+		t.Errorf("expected content type %s, got %s", cloudEventsContentType, encoded.ContentType)
+	}
+	event := map[string]interface{}{}
+	if err := json.Unmarshal(encoded.Body, &event); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"specversion": "1.0",
+		"id":          "uid-1:3",
+		"type":        "io.fusion-app.pod.update",
+		"source":      "/gateways/gateway-0/monitors/default/monitor",
+		"subject":     "default/test",
+		"time":        "2021-06-01T00:00:00Z",
+	} {
+		if event[name] != expected {
+			t.Errorf("expected %s %s, got %v", name, expected, event[name])
+		}
+	}
+	if data, _ := event["data"].(map[string]interface{}); data["status"] == nil {
+		t.Errorf("expected the JSON data, got %v", event["data"])
+	}
+
+	spec.Mode = monitorv1alpha1.BinaryMode
+	encoded, err = NewEnvelope(spec, nil, true).Encode(newEnvelopeTestMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded.Attributes["type"] != "io.fusion-app.pod.update" || string(encoded.Body) != `{"status":{"phase":"Running"}}` ||
+		encoded.ContentType != jsonContentType {
+		t.Errorf("unexpected binary event %+v", encoded)
+	}
+	if encoded, _ = NewEnvelope(spec, nil, false).Encode(newEnvelopeTestMessage()); encoded.Attributes != nil {
+		t.Errorf("expected the structured mode for a backend without headers")
+	}
+}
@@ -1,37 +1,192 @@
 package msg
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
 )
 
 var msgLogger = ctrl.Log.WithName("message")
 
+// MsgHandler sends messages to a backend, encoded with the envelope configured for the backend.
 type MsgHandler interface {
-	Publish([]byte) error
+	Publish(*Message) error
 }
 
-var handlerCache = make(map[string]MsgHandler)
+// sharedHandler is a cached handler and the number of jobs publishing to it.
+type sharedHandler struct {
+	handler MsgHandler
+	refs    int
+}
+
+var (
+	// key: see HandlerKey
+	handlerCache = make(map[string]*sharedHandler)
+	handlerMtx   sync.Mutex
+)
 
-func NewMsgHandlerOrExist(spec monitorv1alpha1.MsgBackendSpec) MsgHandler {
+// HandlerKey identifies the handler of spec, a rotated Secret referenced by spec changes it. The
+// Secrets are read with reader from namespace.
+func HandlerKey(spec monitorv1alpha1.MsgBackendSpec, reader client.Reader, namespace string) (string, error) {
 	keyData, err := json.Marshal(spec)
 	if err != nil {
-		msgLogger.Error(err, "MsgBackendSpec serialize failed")
-		return nil
+		return "", err
 	}
 	key := string(keyData)
+	if !readsSecrets(spec) {
+		return key, nil
+	}
+	key = namespace + "/" + key
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	for _, name := range SecretNames(spec) {
+		secret := &corev1.Secret{}
+		// a missing Secret fails the build of the handler, which is retried with the next key
+		if err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err == nil {
+			key += fmt.Sprintf(",%s@%s", name, secret.GetResourceVersion())
+		}
+	}
+	return key, nil
+}
+
+// NewMsgHandlerOrExist returns the handler of spec, nil if spec has no backend. The Secrets
+// referenced by spec are read with reader from namespace. Every returned handler must be given
+// back with ReleaseHandler.
+func NewMsgHandlerOrExist(spec monitorv1alpha1.MsgBackendSpec, reader client.Reader, namespace string) (MsgHandler, error) {
+	key, err := HandlerKey(spec, reader, namespace)
+	if err != nil {
+		return nil, err
+	}
 
-	if handler, exists := handlerCache[key]; exists {
-		msgLogger.Info("Use exist MsgHandler", "handler", handler)
-		return handler
+	handlerMtx.Lock()
+	defer handlerMtx.Unlock()
+	if shared, exists := handlerCache[key]; exists {
+		msgLogger.Info("Use exist MsgHandler", "handler", shared.handler)
+		shared.refs++
+		return shared.handler, nil
+	}
+	var handler MsgHandler
+	switch {
+	case spec.MQTTBackend != nil:
+		mqttHandler, err := NewMQTTMsgHandler(spec.MQTTBackend)
+		if err != nil {
+			return nil, fmt.Errorf("build MQTT backend %s: %v", spec.MQTTBackend.Host, err)
+		}
+		handler = mqttHandler
+	case spec.WebhookBackend != nil:
+		if handler, err = NewWebhookMsgHandler(spec.WebhookBackend, reader, namespace); err != nil {
+			return nil, fmt.Errorf("build webhook backend %s: %v", spec.WebhookBackend.URL, err)
+		}
+	case spec.RedisStreamBackend != nil:
+		if handler, err = NewRedisStreamMsgHandler(spec.RedisStreamBackend, reader, namespace); err != nil {
+			return nil, fmt.Errorf("build Redis stream backend %s: %v", spec.RedisStreamBackend.Address, err)
+		}
+	case spec.AMQPBackend != nil:
+		if handler, err = NewAMQPMsgHandler(spec.AMQPBackend, reader, namespace); err != nil {
+			return nil, fmt.Errorf("build AMQP backend %s: %v", spec.AMQPBackend.URL, err)
+		}
+	case spec.NATSBackend != nil:
+		if handler, err = NewNATSMsgHandler(spec.NATSBackend, reader, namespace); err != nil {
+			return nil, fmt.Errorf("build NATS backend %v: %v", spec.NATSBackend.Servers, err)
+		}
+	default:
+		return nil, nil
+	}
+	handlerCache[key] = &sharedHandler{handler: handler, refs: 1}
+	return handler, nil
+}
+
+// ReleaseHandler gives back a handler of NewMsgHandlerOrExist, the last job releasing it closes it.
+func ReleaseHandler(handler MsgHandler) {
+	if handler == nil {
+		return
+	}
+	handlerMtx.Lock()
+	var unused bool
+	for key, shared := range handlerCache {
+		if shared.handler != handler {
+			continue
+		}
+		shared.refs--
+		if unused = shared.refs <= 0; unused {
+			delete(handlerCache, key)
+		}
+		break
 	}
-	if spec.MQTTBackend != nil {
-		handler := NewMQTTMsgHandler(spec.MQTTBackend)
-		handlerCache[key] = handler
-		return handler
+	handlerMtx.Unlock()
+	// outside the lock, a backend may wait for its pending messages
+	if unused {
+		closeHandler(handler)
+	}
+}
+
+// readsSecrets tells the backends reading Secrets from the monitor namespace.
+func readsSecrets(spec monitorv1alpha1.MsgBackendSpec) bool {
+	return spec.WebhookBackend != nil || spec.NATSBackend != nil || spec.AMQPBackend != nil ||
+		spec.RedisStreamBackend != nil
+}
+
+// SecretNames lists the Secrets of the monitor namespace read by the backend of spec.
+func SecretNames(spec monitorv1alpha1.MsgBackendSpec) []string {
+	var names []string
+	addSelector := func(selector *corev1.SecretKeySelector) {
+		if selector != nil {
+			names = append(names, selector.Name)
+		}
+	}
+	addTLS := func(tlsConfig *monitorv1alpha1.TLSConfig) {
+		if tlsConfig != nil {
+			addSelector(tlsConfig.CA)
+		}
+	}
+	switch {
+	case spec.WebhookBackend != nil:
+		if spec.WebhookBackend.HeadersSecret != "" {
+			names = append(names, spec.WebhookBackend.HeadersSecret)
+		}
+		addSelector(spec.WebhookBackend.SigningKey)
+		addTLS(spec.WebhookBackend.TLSConfig)
+	case spec.NATSBackend != nil:
+		addSelector(spec.NATSBackend.Credentials)
+		addSelector(spec.NATSBackend.NKey)
+		addTLS(spec.NATSBackend.TLSConfig)
+	case spec.AMQPBackend != nil:
+		if credentials := spec.AMQPBackend.Credentials; credentials != nil {
+			addSelector(&credentials.Username)
+			addSelector(&credentials.Password)
+		}
+		addTLS(spec.AMQPBackend.TLSConfig)
+	case spec.RedisStreamBackend != nil:
+		addSelector(spec.RedisStreamBackend.Password)
+		addTLS(spec.RedisStreamBackend.TLSConfig)
+	}
+	return names
+}
+
+// CloseHandlers closes the backends on shutdown, e.g. the MQTT backend publishes its offline status.
+func CloseHandlers() {
+	handlerMtx.Lock()
+	defer handlerMtx.Unlock()
+	for key, shared := range handlerCache {
+		closeHandler(shared.handler)
+		delete(handlerCache, key)
+	}
+}
+
+func closeHandler(handler MsgHandler) {
+	if closer, ok := handler.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			msgLogger.Error(err, "Close MsgHandler failed")
+		}
 	}
-	return nil
 }
@@ -0,0 +1,66 @@
+package msg
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+func TestNewMsgHandlerOrExist_Release(t *testing.T) {
+	server := newWebhookServer()
+	defer server.Close()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "headers"},
+		Data:       map[string][]byte{"Authorization": []byte("Bearer old")},
+	}
+	reader := fake.NewFakeClientWithScheme(clientgoscheme.Scheme, secret)
+	spec := monitorv1alpha1.MsgBackendSpec{
+		WebhookBackend: &monitorv1alpha1.WebhookBackendSpec{URL: server.URL, HeadersSecret: "headers"},
+	}
+
+	first, err := NewMsgHandlerOrExist(spec, reader, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewMsgHandlerOrExist(spec, reader, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("the handler of the same spec is not shared")
+	}
+
+	// a rotated Secret builds a new handler
+	key, _ := HandlerKey(spec, reader, "default")
+	secret.Data["Authorization"] = []byte("Bearer new")
+	if err := reader.Update(context.Background(), secret); err != nil {
+		t.Fatal(err)
+	}
+	rotatedKey, _ := HandlerKey(spec, reader, "default")
+	if rotatedKey == key {
+		t.Fatalf("the key doesn't change with the Secret: %s", key)
+	}
+	rotated, err := NewMsgHandlerOrExist(spec, reader, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == first {
+		t.Fatalf("the handler of a rotated Secret is reused")
+	}
+	defer ReleaseHandler(rotated)
+
+	ReleaseHandler(first)
+	if err := first.Publish(newTestMessage("web")); err != nil {
+		t.Fatalf("handler closed with a job still using it: %v", err)
+	}
+	ReleaseHandler(second)
+	if err := first.Publish(newTestMessage("web")); err == nil {
+		t.Errorf("handler not closed by the last release")
+	}
+}
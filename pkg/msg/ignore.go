@@ -0,0 +1,188 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultIgnorePaths are the fields changing on every write without carrying any state.
+var DefaultIgnorePaths = []string{
+	"/metadata/resourceVersion",
+	"/metadata/managedFields",
+	"status.conditions[*].lastProbeTime",
+	"status.conditions[*].lastHeartbeatTime",
+}
+
+const wildcard = "*"
+
+// pathSegment is a map key, an array index or a wildcard matching every key or element.
+type pathSegment struct {
+	key   string
+	index int
+}
+
+func (p pathSegment) isIndex() bool {
+	return p.index >= 0
+}
+
+type ignorePath []pathSegment
+
+// ValidateIgnorePath returns the error parsing path, the webhook rejects the monitor with it.
+func ValidateIgnorePath(path string) error {
+	_, err := parseIgnorePath(path)
+	return err
+}
+
+// parseIgnorePath accepts a JSON pointer ("/status/conditions/*/lastProbeTime") or a
+// JSONPath ("status.conditions[*].lastProbeTime", "{.metadata.annotations['a.b/c']}"), where
+// "[]" matches every element like "[*]".
+func parseIgnorePath(path string) (ignorePath, error) {
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "/") {
+		return parsePointer(path), nil
+	}
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "{"), "}")
+	path = strings.TrimPrefix(path, "$")
+
+	var segments ignorePath
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket in %s", path)
+			}
+			inner := path[i+1 : i+end]
+			i += end + 1
+			if quoted := strings.Trim(inner, `'"`); quoted != inner {
+				segments = append(segments, pathSegment{key: quoted, index: -1})
+			} else if inner == wildcard || inner == "" {
+				segments = append(segments, pathSegment{key: wildcard, index: -1})
+			} else if index, err := strconv.Atoi(inner); err == nil && index >= 0 {
+				segments = append(segments, pathSegment{index: index})
+			} else {
+				return nil, fmt.Errorf("invalid index %s in %s", inner, path)
+			}
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			segments = append(segments, pathSegment{key: path[i : i+end], index: -1})
+			i += end
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty ignore path")
+	}
+	return segments, nil
+}
+
+func parsePointer(pointer string) ignorePath {
+	var segments ignorePath
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		segments = append(segments, pathSegment{key: token, index: -1})
+	}
+	return segments
+}
+
+// strip deletes every field matched by the path from the decoded JSON tree.
+func (p ignorePath) strip(tree interface{}) {
+	if len(p) == 0 {
+		return
+	}
+	seg, last := p[0], len(p) == 1
+	switch node := tree.(type) {
+	case map[string]interface{}:
+		if seg.isIndex() {
+			return
+		}
+		if seg.key == wildcard {
+			for key, child := range node {
+				if last {
+					delete(node, key)
+				} else {
+					p[1:].strip(child)
+				}
+			}
+			return
+		}
+		if last {
+			delete(node, seg.key)
+		} else if child, exists := node[seg.key]; exists {
+			p[1:].strip(child)
+		}
+	case []interface{}:
+		// elements can't be deleted in place, the path has to go deeper than an array
+		if last {
+			return
+		}
+		if seg.key == wildcard {
+			for _, child := range node {
+				p[1:].strip(child)
+			}
+			return
+		}
+		index := seg.index
+		if !seg.isIndex() {
+			var err error
+			if index, err = strconv.Atoi(seg.key); err != nil {
+				return
+			}
+		}
+		if index < len(node) {
+			p[1:].strip(node[index])
+		}
+	}
+}
+
+// stateFilter removes ignored fields before comparison and, optionally, before publishing.
+type stateFilter struct {
+	paths        []ignorePath
+	stripPublish bool
+}
+
+func newStateFilter(paths []string, disableDefaults, stripPublish bool) (*stateFilter, error) {
+	filter := &stateFilter{stripPublish: stripPublish}
+	if !disableDefaults {
+		paths = append(append([]string{}, DefaultIgnorePaths...), paths...)
+	}
+	for _, path := range paths {
+		parsed, err := parseIgnorePath(path)
+		if err != nil {
+			return nil, err
+		}
+		filter.paths = append(filter.paths, parsed)
+	}
+	return filter, nil
+}
+
+// apply returns the payload to publish and the data used to detect changes.
+func (f *stateFilter) apply(raw []byte) ([]byte, []byte, error) {
+	if f == nil || len(f.paths) == 0 {
+		return raw, raw, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, nil, err
+	}
+	for _, path := range f.paths {
+		path.strip(tree)
+	}
+	stateData, err := json.Marshal(tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.stripPublish {
+		return stateData, stateData, nil
+	}
+	return raw, stateData, nil
+}
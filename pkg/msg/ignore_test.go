@@ -0,0 +1,93 @@
+package msg
+
+import (
+	"testing"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+func TestStateFilter_Apply(t *testing.T) {
+	filter, err := newStateFilter([]string{"{.metadata.annotations['kubevirt.io/latest-observed-api-version']}"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := []byte(`{"metadata":{"name":"droid-14","resourceVersion":"1","annotations":{"kubevirt.io/latest-observed-api-version":"v1"}},
+		"status":{"phase":"Running","conditions":[{"type":"Ready","lastProbeTime":"2021-05-01T00:00:00Z"}]}}`)
+	after := []byte(`{"metadata":{"name":"droid-14","resourceVersion":"2","annotations":{"kubevirt.io/latest-observed-api-version":"v2"}},
+		"status":{"phase":"Running","conditions":[{"type":"Ready","lastProbeTime":"2021-05-01T00:01:00Z"}]}}`)
+
+	payload, beforeState, err := filter.apply(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != string(before) {
+		t.Errorf("payload should be untouched without StripIgnoredPaths")
+	}
+	_, afterState, err := filter.apply(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(beforeState) != string(afterState) {
+		t.Errorf("noise should be ignored, got %s and %s", beforeState, afterState)
+	}
+	expected := `{"metadata":{"annotations":{},"name":"droid-14"},"status":{"conditions":[{"type":"Ready"}],"phase":"Running"}}`
+	if string(afterState) != expected {
+		t.Errorf("expected %s, got %s", expected, afterState)
+	}
+}
+
+func TestParseIgnorePath(t *testing.T) {
+	cases := map[string]int{
+		"/metadata/managedFields":              2,
+		"/metadata/annotations/a~1b":           3,
+		"status.conditions[*].lastProbeTime":   4,
+		"status.conditions[].lastProbeTime":    4,
+		"$.status.interfaces[0].ipAddress":     4,
+		"{.metadata.labels['app.kubernetes']}": 3,
+	}
+	for path, segments := range cases {
+		parsed, err := parseIgnorePath(path)
+		if err != nil {
+			t.Errorf("parse %s failed: %v", path, err)
+			continue
+		}
+		if len(parsed) != segments {
+			t.Errorf("expected %d segments for %s, got %d", segments, path, len(parsed))
+		}
+	}
+	if _, err := parseIgnorePath("status.conditions[x"); err == nil {
+		t.Errorf("unterminated bracket should fail")
+	}
+	if err := ValidateIgnorePath("status.conditions[x].lastProbeTime"); err == nil {
+		t.Errorf("invalid index should fail")
+	}
+}
+
+func TestStateFilter_EveryElement(t *testing.T) {
+	filter, err := newStateFilter([]string{"status.conditions[].lastTransitionTime"}, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _, err := filter.apply([]byte(`{"status":{"conditions":[{"type":"Ready","lastTransitionTime":"a"},{"type":"Synced","lastTransitionTime":"b"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"status":{"conditions":[{"type":"Ready"},{"type":"Synced"}]}}`; string(payload) != expected {
+		t.Errorf("expected %s, got %s", expected, payload)
+	}
+}
+
+func TestMessageStore_IgnorePathError(t *testing.T) {
+	store := newTestStore(nil)
+	spec := &monitorv1alpha1.ResourceMonitorSpec{}
+	spec.MsgBuilder.IgnorePaths = []string{"status.conditions[x].reason"}
+	store.Reconfigure(spec)
+	if store.IgnorePathError() == "" {
+		t.Fatal("invalid ignore path not reported")
+	}
+	spec.MsgBuilder.IgnorePaths = []string{"status.conditions[].reason"}
+	store.Reconfigure(spec)
+	if err := store.IgnorePathError(); err != "" {
+		t.Errorf("error kept after the fix: %s", err)
+	}
+}
@@ -0,0 +1,135 @@
+package msg
+
+import (
+	"math"
+	"time"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+// metricState tracks the last published value and the threshold rules of one metric field.
+type metricState struct {
+	lastValue     float64
+	lastPublished time.Time
+	published     bool
+	rules         map[string]*ruleState
+}
+
+type ruleState struct {
+	pendingSince time.Time
+	firing       bool
+}
+
+func newMetricState() *metricState {
+	return &metricState{
+		rules: make(map[string]*ruleState),
+	}
+}
+
+// shouldPublish applies the deadband and the minimum interval of policy to a new value.
+func (m *metricState) shouldPublish(policy *monitorv1alpha1.MetricPolicy, value float64, now time.Time) bool {
+	if !m.published {
+		return policy == nil || !policy.AlertOnly
+	}
+	if value == m.lastValue {
+		return false
+	}
+	if policy == nil {
+		return true
+	}
+	if policy.AlertOnly {
+		return false
+	}
+	if policy.MinInterval != nil && now.Sub(m.lastPublished) < policy.MinInterval.Duration {
+		return false
+	}
+	if policy.Deadband > 0 && m.lastValue != 0 &&
+		math.Abs(value-m.lastValue)/math.Abs(m.lastValue) < policy.Deadband {
+		return false
+	}
+	return true
+}
+
+func (m *metricState) markPublished(value float64, now time.Time) {
+	m.lastValue = value
+	m.lastPublished = now
+	m.published = true
+}
+
+// evaluate returns the alerts whose state changed with the new value.
+func (m *metricState) evaluate(field string, policy *monitorv1alpha1.MetricPolicy, value float64, now time.Time) []*Alert {
+	if policy == nil {
+		return nil
+	}
+	var alerts []*Alert
+	for _, rule := range policy.Thresholds {
+		state, exists := m.rules[rule.Name]
+		if !exists {
+			state = &ruleState{}
+			m.rules[rule.Name] = state
+		}
+
+		if !crossed(rule.Operator, value, rule.Value) {
+			if state.firing {
+				alerts = append(alerts, newAlert(field, rule, AlertResolved, value, state.pendingSince))
+			}
+			state.pendingSince = time.Time{}
+			state.firing = false
+			continue
+		}
+
+		if state.pendingSince.IsZero() {
+			state.pendingSince = now
+		}
+		var pendingFor time.Duration
+		if rule.For != nil {
+			pendingFor = rule.For.Duration
+		}
+		if !state.firing && now.Sub(state.pendingSince) >= pendingFor {
+			state.firing = true
+			alerts = append(alerts, newAlert(field, rule, AlertFiring, value, state.pendingSince))
+		}
+	}
+	return alerts
+}
+
+func newAlert(field string, rule monitorv1alpha1.ThresholdRule, state AlertState, value float64, since time.Time) *Alert {
+	return &Alert{
+		Rule:      rule.Name,
+		Field:     field,
+		State:     state,
+		Severity:  rule.Severity,
+		Operator:  string(rule.Operator),
+		Threshold: rule.Value,
+		Value:     value,
+		Since:     since,
+	}
+}
+
+func crossed(op monitorv1alpha1.ThresholdOperator, value, bound float64) bool {
+	switch op {
+	case monitorv1alpha1.GreaterThan:
+		return value > bound
+	case monitorv1alpha1.GreaterOrEqualTo:
+		return value >= bound
+	case monitorv1alpha1.LessThan:
+		return value < bound
+	case monitorv1alpha1.LessOrEqualTo:
+		return value <= bound
+	}
+	return false
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
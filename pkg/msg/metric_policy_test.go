@@ -0,0 +1,61 @@
+package msg
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+func TestMetricState_ShouldPublish(t *testing.T) {
+	policy := &monitorv1alpha1.MetricPolicy{
+		Deadband:    0.1,
+		MinInterval: &metav1.Duration{Duration: time.Minute},
+	}
+	now := time.Now()
+	state := newMetricState()
+	if !state.shouldPublish(policy, 100, now) {
+		t.Fatal("first value should be published")
+	}
+	state.markPublished(100, now)
+
+	if state.shouldPublish(policy, 150, now.Add(time.Second*30)) {
+		t.Error("value inside min interval should not be published")
+	}
+	if state.shouldPublish(policy, 105, now.Add(time.Minute*2)) {
+		t.Error("value inside deadband should not be published")
+	}
+	if !state.shouldPublish(policy, 115, now.Add(time.Minute*2)) {
+		t.Error("value outside deadband should be published")
+	}
+}
+
+func TestMetricState_Evaluate(t *testing.T) {
+	policy := &monitorv1alpha1.MetricPolicy{
+		Thresholds: []monitorv1alpha1.ThresholdRule{{
+			Name:     "high-mem",
+			Operator: monitorv1alpha1.GreaterThan,
+			Value:    80,
+			For:      &metav1.Duration{Duration: time.Minute},
+		}},
+	}
+	now := time.Now()
+	state := newMetricState()
+
+	if alerts := state.evaluate("mem_use", policy, 90, now); len(alerts) != 0 {
+		t.Fatalf("alert should be pending, got %v", alerts)
+	}
+	alerts := state.evaluate("mem_use", policy, 95, now.Add(time.Minute))
+	if len(alerts) != 1 || alerts[0].State != AlertFiring || !alerts[0].Since.Equal(now) {
+		t.Fatalf("expected firing alert, got %v", alerts)
+	}
+	if alerts := state.evaluate("mem_use", policy, 95, now.Add(time.Minute*2)); len(alerts) != 0 {
+		t.Fatalf("firing alert should not be repeated, got %v", alerts)
+	}
+	alerts = state.evaluate("mem_use", policy, 50, now.Add(time.Minute*3))
+	if len(alerts) != 1 || alerts[0].State != AlertResolved {
+		t.Fatalf("expected resolved alert, got %v", alerts)
+	}
+}
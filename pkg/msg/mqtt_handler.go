@@ -1,35 +1,141 @@
 package msg
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/topic"
 )
 
 var mqttLogger = ctrl.Log.WithName("mqtt")
 
+// LocalBrokerHost as the host of an MQTT backend targets the embedded broker.
+const LocalBrokerHost = "local"
+
+// BrokerEndpoint is where the backends targeting the embedded broker connect to.
+type BrokerEndpoint struct {
+	Address  string
+	Username string
+	Password string
+}
+
+// LocalBroker is set once the embedded broker serves.
+var LocalBroker *BrokerEndpoint
+
 type MQTTMsgHandler struct {
-	Client     mqtt.Client
-	topic      string
+	Client mqtt.Client
+	topic  string
+	// stateTopic of the retained state of a resource, nil if not retained
+	stateTopic *template.Template
 	pubTimeout time.Duration
+	envelope   *Envelope
+	sparkplug  *sparkplugNode
+	presence   *mqttPresence
+}
+
+// mqttPresence is the status topic of the gateway, the birth is published retained on connect and
+// the will is retained by the broker.
+type mqttPresence struct {
+	topic             string
+	birthMessage      string
+	will              []byte
+	heartbeatInterval time.Duration
+}
+
+func newMQTTPresence(spec *monitorv1alpha1.MQTTBackendSpec) (*mqttPresence, error) {
+	if spec.Presence == nil || spec.Sparkplug != nil {
+		return nil, nil
+	}
+	presence := &mqttPresence{
+		topic:        spec.Presence.Topic,
+		birthMessage: spec.Presence.BirthMessage,
+		will:         []byte(spec.Presence.WillMessage),
+	}
+	if presence.topic == "" {
+		presence.topic = fmt.Sprintf("%s/status/%s", spec.Topic, GatewayID)
+	}
+	if spec.Presence.HeartbeatInterval != nil {
+		presence.heartbeatInterval = spec.Presence.HeartbeatInterval.Duration
+	}
+	if len(presence.will) == 0 {
+		var err error
+		if presence.will, err = json.Marshal(&Presence{State: Offline, GatewayID: GatewayID}); err != nil {
+			return nil, err
+		}
+	}
+	return presence, nil
+}
+
+func (p *mqttPresence) birth() ([]byte, error) {
+	if p.birthMessage != "" {
+		return []byte(p.birthMessage), nil
+	}
+	now := time.Now()
+	return json.Marshal(&Presence{State: Online, GatewayID: GatewayID, Timestamp: &now})
 }
 
-func NewMQTTMsgHandler(spec *monitorv1alpha1.MQTTBackendSpec) *MQTTMsgHandler {
+func NewMQTTMsgHandler(spec *monitorv1alpha1.MQTTBackendSpec) (*MQTTMsgHandler, error) {
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", spec.Host, spec.Port))
-	opts.SetClientID("k8s-gateway")
-	if spec.Username != "" {
-		opts.SetUsername(spec.Username)
+	opts.SetClientID(mqttClientID(spec))
+	stateTopic := spec.StateTopic
+	if spec.Host == LocalBrokerHost {
+		if LocalBroker == nil {
+			return nil, fmt.Errorf("the embedded MQTT broker isn't enabled")
+		}
+		opts.AddBroker("tcp://" + LocalBroker.Address)
+		opts.SetUsername(LocalBroker.Username)
+		opts.SetPassword(LocalBroker.Password)
+		if stateTopic == "" {
+			stateTopic = spec.Topic + "/state/{{.Kind}}/{{.Namespace}}/{{.Name}}"
+		}
+	} else {
+		opts.AddBroker(fmt.Sprintf("tcp://%s:%d", spec.Host, spec.Port))
+		if spec.Username != "" {
+			opts.SetUsername(spec.Username)
+		}
+		if spec.Password != "" {
+			opts.SetPassword(spec.Password)
+		}
+	}
+	var stateTmpl *template.Template
+	if stateTopic != "" && spec.Sparkplug == nil {
+		var err error
+		if stateTmpl, err = topic.Parse(stateTopic); err != nil {
+			return nil, fmt.Errorf("invalid state topic: %v", err)
+		}
+	}
+	var sparkplug *sparkplugNode
+	if spec.Sparkplug != nil {
+		sparkplug = newSparkplugNode(spec.Sparkplug)
+		opts.SetBinaryWill(sparkplug.topic(sparkplugNDeath, ""), sparkplug.death(), 1, false)
+		opts.SetReconnectingHandler(func(_ mqtt.Client, opts *mqtt.ClientOptions) {
+			opts.SetBinaryWill(sparkplug.topic(sparkplugNDeath, ""), sparkplug.nextSession(), 1, false)
+		})
 	}
-	if spec.Password != "" {
-		opts.SetPassword(spec.Password)
+	presence, err := newMQTTPresence(spec)
+	if err != nil {
+		return nil, err
+	}
+	if presence != nil {
+		opts.SetBinaryWill(presence.topic, presence.will, 1, true)
 	}
 	opts.OnConnect = func(client mqtt.Client) {
 		mqttLogger.Info("Connected")
+		if sparkplug != nil {
+			startSparkplugNode(client, sparkplug)
+		}
+		if presence != nil {
+			publishBirth(client, presence)
+		}
 	}
 	opts.OnConnectionLost = func(client mqtt.Client, err error) {
 		mqttLogger.Error(err, "Connection lost")
@@ -38,8 +144,17 @@ func NewMQTTMsgHandler(spec *monitorv1alpha1.MQTTBackendSpec) *MQTTMsgHandler {
 	//	mqttLogger.WithValues("topic", msg.Topic(), "payload", msg.Payload()).Info("Received message")
 	//}
 	client := mqtt.NewClient(opts)
+	if sparkplug != nil {
+		sparkplug.send = func(topic string, qos byte, payload []byte) error {
+			token := client.Publish(topic, qos, false, payload)
+			if !token.WaitTimeout(time.Second * 3) {
+				return fmt.Errorf("publish to %s timed out", topic)
+			}
+			return token.Error()
+		}
+	}
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+		return nil, token.Error()
 	}
 	//go func() {
 	//	if token := client.Subscribe(spec.Topic, 0, func(client mqtt.Client, msg mqtt.Message) {
@@ -51,17 +166,144 @@ func NewMQTTMsgHandler(spec *monitorv1alpha1.MQTTBackendSpec) *MQTTMsgHandler {
 	return &MQTTMsgHandler{
 		Client:     client,
 		topic:      spec.Topic,
+		stateTopic: stateTmpl,
 		pubTimeout: time.Second * 3,
+		envelope:   NewEnvelope(spec.Envelope, spec.Encoding, false),
+		sparkplug:  sparkplug,
+		presence:   presence,
+	}, nil
+}
+
+// mqttClientID tells the gateway instances and their backends apart, a broker drops the older
+// connection of a client ID and publishes its will.
+func mqttClientID(spec *monitorv1alpha1.MQTTBackendSpec) string {
+	specData, _ := json.Marshal(spec)
+	sum := sha256.Sum256(specData)
+	return fmt.Sprintf("k8s-gateway-%s-%s", GatewayID, hex.EncodeToString(sum[:4]))
+}
+
+func publishBirth(client mqtt.Client, presence *mqttPresence) {
+	birth, err := presence.birth()
+	if err != nil {
+		mqttLogger.Error(err, "Serialize birth message failed")
+		return
+	}
+	token := client.Publish(presence.topic, 1, true, birth)
+	if token.Wait() && token.Error() != nil {
+		mqttLogger.Error(token.Error(), "Publish birth message failed")
 	}
 }
 
-func (h *MQTTMsgHandler) Publish(msg []byte) error {
-	token := h.Client.Publish(h.topic, 0, false, msg)
+// startSparkplugNode subscribes to the node commands and publishes the births, the subscription
+// doesn't survive a reconnect of the clean session.
+func startSparkplugNode(client mqtt.Client, node *sparkplugNode) {
+	token := client.Subscribe(node.topic(sparkplugNCmd, ""), 0, func(client mqtt.Client, msg mqtt.Message) {
+		if err := node.command(msg.Payload()); err != nil {
+			mqttLogger.Error(err, "Handle Sparkplug command failed", "topic", msg.Topic())
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		mqttLogger.Error(token.Error(), "Subscribe Sparkplug commands failed")
+	}
+	if err := node.birth(); err != nil {
+		mqttLogger.Error(err, "Publish Sparkplug births failed")
+	}
+}
+
+func (h *MQTTMsgHandler) Publish(msg *Message) error {
+	if h.sparkplug != nil {
+		if err := h.sparkplug.Publish(msg); err != nil {
+			mqttLogger.Error(err, "Publish Sparkplug message failed")
+			return err
+		}
+		return nil
+	}
+	encoded, err := h.envelope.Encode(msg)
+	if err != nil {
+		mqttLogger.Error(err, "Encode message failed")
+		return err
+	}
+	token := h.Client.Publish(h.topic, 0, false, encoded.Body)
 	if !token.WaitTimeout(h.pubTimeout) {
-		mqttLogger.Error(token.Error(), "Publish failed")
-		return token.Error()
+		return fmt.Errorf("publish to %s timed out", h.topic)
+	}
+	if err := token.Error(); err != nil {
+		return err
 	}
 	//mqttLogger.Info("Publish success", "msg", string(msg))
 	mqttLogger.Info("Publish success")
+	if h.stateTopic != nil && isResourceOp(msg.Op) && msg.Meta != nil {
+		return h.retainState(msg, encoded.Body)
+	}
 	return nil
 }
+
+// retainState publishes the state retained on the topic of the resource, an empty message clears it.
+func (h *MQTTMsgHandler) retainState(msg *Message, body []byte) error {
+	topic, err := renderTopic(h.stateTopic, msg, mqttTopicLevel)
+	if err != nil {
+		mqttLogger.Error(err, "Render state topic failed")
+		return err
+	}
+	if msg.Op == DelResource {
+		body = nil
+	}
+	token := h.Client.Publish(topic, 1, true, body)
+	if !token.WaitTimeout(h.pubTimeout) {
+		err := fmt.Errorf("publish to %s timed out", topic)
+		mqttLogger.Error(err, "Retain state failed")
+		return err
+	}
+	return token.Error()
+}
+
+// mqttTopicLevel replaces the characters separating and matching topic levels.
+func mqttTopicLevel(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(value)
+}
+
+func (h *MQTTMsgHandler) HeartbeatInterval() time.Duration {
+	if h.presence == nil {
+		return 0
+	}
+	return h.presence.heartbeatInterval
+}
+
+// PublishPresence publishes a heartbeat retained on the status topic of its monitor.
+func (h *MQTTMsgHandler) PublishPresence(presence *Presence) error {
+	if h.presence == nil || presence.Heartbeat == nil {
+		return nil
+	}
+	data, err := json.Marshal(presence)
+	if err != nil {
+		return err
+	}
+	topic := fmt.Sprintf("%s/%s/%s", h.presence.topic, presence.Heartbeat.MonitorNamespace, presence.Heartbeat.MonitorName)
+	token := h.Client.Publish(topic, 1, true, data)
+	if !token.WaitTimeout(h.pubTimeout) {
+		return fmt.Errorf("publish to %s timed out", topic)
+	}
+	return token.Error()
+}
+
+// Close publishes the offline status, or NDEATH in Sparkplug mode, and disconnects.
+func (h *MQTTMsgHandler) Close() error {
+	var token mqtt.Token
+	switch {
+	case h.sparkplug != nil:
+		token = h.Client.Publish(h.sparkplug.topic(sparkplugNDeath, ""), 1, false, h.sparkplug.death())
+	case h.presence != nil:
+		token = h.Client.Publish(h.presence.topic, 1, true, h.presence.will)
+	}
+	var err error
+	if token != nil && !token.WaitTimeout(h.pubTimeout) {
+		err = fmt.Errorf("publish offline status timed out")
+	} else if token != nil {
+		err = token.Error()
+	}
+	h.Client.Disconnect(250)
+	return err
+}
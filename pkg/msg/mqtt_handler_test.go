@@ -2,16 +2,22 @@ package msg
 
 import (
 	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
 )
 
 func TestMQTTMsgHandler_Publish(t *testing.T) {
-	handler := NewMQTTMsgHandler(&monitorv1alpha1.MQTTBackendSpec{
+	handler, err := NewMQTTMsgHandler(&monitorv1alpha1.MQTTBackendSpec{
 		Host: "test.mosquitto.org",
 		Port: 1883,
 		Topic: "udogateway",
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	msg := &Message{
 		Op: NewResource,
 		Meta: &ResourceMeta{
@@ -19,11 +25,39 @@ func TestMQTTMsgHandler_Publish(t *testing.T) {
 			Namespace: "default",
 		},
 	}
-	d, err := msg.MarshalJSON(nil)
-	if err != nil {
-		t.Fail()
-	}
-	if err := handler.Publish(d); err != nil {
+	if err := handler.Publish(msg); err != nil {
 		t.Errorf("Pub failed")
 	}
 }
+
+func TestNewMsgHandlerOrExist_InvalidMQTT(t *testing.T) {
+	spec := monitorv1alpha1.MsgBackendSpec{MQTTBackend: &monitorv1alpha1.MQTTBackendSpec{Host: LocalBrokerHost, Topic: "gateway"}}
+	if handler, err := NewMsgHandlerOrExist(spec, nil, "default"); handler != nil || err == nil {
+		t.Errorf("local backend built without the embedded broker")
+	}
+
+	LocalBroker = &BrokerEndpoint{Address: "127.0.0.1:1"}
+	defer func() { LocalBroker = nil }()
+	spec.MQTTBackend.StateTopic = "gateway/{{.Name"
+	if handler, err := NewMsgHandlerOrExist(spec, nil, "default"); handler != nil || err == nil {
+		t.Errorf("backend built with an invalid state topic")
+	}
+}
+
+func TestMQTTMsgHandler_PublishUnreachable(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker("tcp://127.0.0.1:1")
+	handler := &MQTTMsgHandler{
+		Client:     mqtt.NewClient(opts),
+		topic:      "gateway",
+		pubTimeout: time.Second,
+		envelope:   NewEnvelope(nil, nil, false),
+	}
+	store := newTestStore(handler)
+	obj := newTestObject("Running")
+	store.OnResourceAdd(obj, obj)
+
+	if stats := store.Stats(); stats.PublishErrors == 0 || stats.Published != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
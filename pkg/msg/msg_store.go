@@ -1,157 +1,332 @@
 package msg
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/alecthomas/jsonschema"
 	"github.com/fusion-app/gateway/pkg/prom"
 	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/transform"
 	"github.com/fusion-app/gateway/pkg/utils"
 )
 
 type MessageCache struct {
 	Message *Message
 	Metrics map[string]interface{}
+
+	// hash of the object without ignored fields, used to detect changes
+	hash string
+	// seq counts the messages published for the resource
+	seq uint64
+	// key: metric field
+	metricStates map[string]*metricState
+	// key: transition name
+	transitions map[string]*TransitionState
+}
+
+func (c *MessageCache) sameState(hash string) bool {
+	return c.hash != "" && c.hash == hash
+}
+
+func (c *MessageCache) nextSeq() uint64 {
+	c.seq++
+	return c.seq
+}
+
+func (c *MessageCache) metricState(field string) *metricState {
+	if c.metricStates == nil {
+		c.metricStates = make(map[string]*metricState)
+	}
+	state, exists := c.metricStates[field]
+	if !exists {
+		state = newMetricState()
+		c.metricStates[field] = state
+	}
+	return state
 }
 
 type MessageStore struct {
 	logger   logr.Logger
 	handler  MsgHandler
 	schemaID string
+	// the monitor publishing through the store
+	monitorNamespace string
+	monitorName      string
+	// schemaMsg is the RegisterSchema message, sent again when the backend changes
+	schemaMsg *Message
+	filter    *stateFilter
+	// ignorePathError is the error parsing the ignore paths, only the defaults apply meanwhile
+	ignorePathError string
+	// key: metric field
+	policies        map[string]*monitorv1alpha1.MetricPolicy
+	transitions     []transitionRule
+	transitionsOnly bool
+	transform       transform.Transform
 	// key: namespacedName
 	cache map[string]*MessageCache
 	mtx   sync.Mutex
 
+	debounce time.Duration
+	limiter  *rate.Limiter
+	// key: namespacedName, updates waiting for the debounce window or the rate limit
+	pending map[string]*time.Timer
+
+	// closed stops all publishing once the job is cancelled
+	closed bool
+
+	// restored holds the checkpointed resources not seen since the restart, key: namespace/name
+	restored         map[string]*ResourceCheckpoint
+	restoredSchemaID string
+	// dirty is set when the state changed since the last checkpoint
+	dirty bool
+	// subscribers get the published messages besides the backend
+	subscribers map[*Subscription]struct{}
+	// epoch identifies the store in the positions of its messages, offset is the position of
+	// the last published message
+	epoch  string
+	offset uint64
+	// replay holds the last published messages for the subscribers resuming their stream, it is
+	// filled once replaying is set by the first snapshot or subscription
+	replay    []*Message
+	replaying bool
+
 	//stats
-	pubCount uint64
+	pubCount       uint64
+	pubErrCount    uint64
+	coalescedCount uint64
+	delayedCount   uint64
 }
 
-func NewMsgStore(ref *monitorv1alpha1.ResourceMonitor) *MessageStore {
-	return &MessageStore{
+// NewMsgStore publishes the messages of the monitor ref to handler, which may be nil.
+func NewMsgStore(ref *monitorv1alpha1.ResourceMonitor, handler MsgHandler) *MessageStore {
+	s := &MessageStore{
 		logger:   ctrl.Log.WithName("store"),
-		handler:  NewMsgHandlerOrExist(ref.Spec.MsgBackendSpec),
+		handler:  handler,
 		schemaID: "",
 		cache:    make(map[string]*MessageCache),
+		pending:  make(map[string]*time.Timer),
+
+		monitorNamespace: ref.GetNamespace(),
+		monitorName:      ref.GetName(),
+		epoch:            uuid.New().String(),
 	}
+	s.configure(&ref.Spec)
+	registerStore(s)
+	return s
 }
 
-func (s *MessageStore) OnResourceAdd(obj interface{}, u *unstructured.Unstructured) {
-	if s.schemaID == "" {
-		schemaID := utils.JSONSchemaID(u)
-		schemaObj := jsonschema.Reflect(obj)
-		schemaObj.Definitions["Extras"] = &jsonschema.Type{
-			Type: "array",
-			Extras: map[string]interface{}{
-				"template": map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"key": map[string]string{
-							"type": "string",
-						},
-						"val": map[string]string{
-							"type": "string",
-						},
-					},
-				},
-			},
-		}
-		schemaData, err := schemaObj.MarshalJSON()
-		if err != nil {
-			s.logger.Error(err, "Serialize JSON Schema failed")
-			return
-		}
-		msg := &Message{
-			Op:   RegisterSchema,
-			Data: schemaData,
+// Reconfigure applies the MsgBuilder and RateLimit of spec while keeping the cached state.
+func (s *MessageStore) Reconfigure(spec *monitorv1alpha1.ResourceMonitorSpec) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.configure(spec)
+}
+
+func (s *MessageStore) configure(spec *monitorv1alpha1.ResourceMonitorSpec) {
+	policies := make(map[string]*monitorv1alpha1.MetricPolicy)
+	for _, metric := range spec.MsgBuilder.Metrics {
+		if metric.Policy != nil {
+			policies[metric.Field] = metric.Policy.DeepCopy()
 		}
-		msgData, err := msg.MarshalJSON(nil)
-		if err != nil {
-			s.logger.Error(err, "Serialize Message failed")
+	}
+	builder := spec.MsgBuilder
+	filter, err := newStateFilter(builder.IgnorePaths, builder.DisableDefaultIgnorePaths, builder.StripIgnoredPaths)
+	s.ignorePathError = ""
+	if err != nil {
+		msgLogger.Error(err, "Invalid ignore path, only the defaults are applied")
+		s.ignorePathError = err.Error()
+		filter, _ = newStateFilter(nil, builder.DisableDefaultIgnorePaths, builder.StripIgnoredPaths)
+	}
+	s.filter = filter
+	s.policies = policies
+	s.transform = nil
+	if builder.Transform != nil {
+		if s.transform, err = transform.New(builder.Transform.JQ, builder.Transform.Template); err != nil {
+			msgLogger.Error(err, "Invalid transform, the objects are published as they are")
 		}
-		if err = s.handler.Publish(msgData); err != nil {
+	}
+	s.transitions = newTransitionRules(builder.Transitions)
+	s.transitionsOnly = builder.TransitionsOnly
+	s.debounce, s.limiter = newRateLimiter(spec.RateLimit)
+}
+
+// IgnorePathError returns the error of the configured ignore paths, empty when they are valid.
+func (s *MessageStore) IgnorePathError() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.ignorePathError
+}
+
+// SetHandler switches the backend, the schema is registered again on the new backend.
+func (s *MessageStore) SetHandler(handler MsgHandler) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.handler = handler
+	if s.schemaMsg != nil && s.handler != nil {
+		if err := s.handler.Publish(s.schemaMsg); err != nil {
 			s.logger.Error(err, "Register JSON Schema failed")
-			return
 		}
-		s.schemaID = schemaID
+	}
+}
+
+func (s *MessageStore) OnResourceAdd(obj interface{}, u *unstructured.Unstructured) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.addResource(obj, u)
+}
+
+// addResource publishes the New message of obj, s.mtx must be held by the caller. Nothing is
+// cached when the schema registration fails, so the next event of obj retries it.
+func (s *MessageStore) addResource(obj interface{}, u *unstructured.Unstructured) {
+	if s.closed {
+		return
+	}
+	if s.schemaID == "" && !s.registerSchema(obj, u) {
+		return
 	}
 
-	objRawData, err := json.Marshal(obj)
+	objRawData, hash, err := s.marshalState(obj)
 	if err != nil {
 		s.logger.Error(err, "Build Message failed")
 		return
 	}
 	msg := &Message{
-		Op: NewResource,
-		Meta: &ResourceMeta{
-			SchemaID:  s.schemaID,
-			Namespace: u.GetNamespace(),
-			Name:      u.GetName(),
-		},
+		Op:   NewResource,
+		Meta: s.resourceMeta(u.GetNamespace(), u.GetName(), u),
 		Data: objRawData,
 	}
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
 	key := cacheKey(s.schemaID, u.GetNamespace(), u.GetName())
+	msgCache := &MessageCache{
+		Message: msg,
+		Metrics: make(map[string]interface{}),
+		hash:    hash,
+	}
 	if oldCache, exists := s.cache[key]; exists {
-		if msg.Equal(oldCache.Message) {
+		sameResource := oldCache.Message.Meta.sameResource(msg.Meta)
+		if oldCache.sameState(hash) && sameResource {
 			return
 		}
+		msgCache.seq = oldCache.seq
+		if sameResource {
+			msgCache.transitions = oldCache.transitions
+		}
+	} else if restored, exists := s.restored[resourceKey(u.GetNamespace(), u.GetName())]; exists && s.schemaID == s.restoredSchemaID {
+		delete(s.restored, resourceKey(u.GetNamespace(), u.GetName()))
+		msgCache.seq = restored.Seq
+		// already published before the restart, only a difference is worth an Update
+		if restored.UID == u.GetUID() {
+			msgCache.transitions = restored.Transitions
+			if restored.Hash == hash {
+				s.cache[key] = msgCache
+				s.observeTransitions(msgCache, u, true)
+				return
+			}
+			msg.Op = UpdateResource
+		}
 	}
-	s.cache[key] = &MessageCache{
-		Message: msg,
-		Metrics: make(map[string]interface{}),
+	s.cache[key] = msgCache
+	s.dirty = true
+	if msg.Op == NewResource || !s.transitionsOnly {
+		resourceMsg, err := s.marshalResource(&Message{
+			Op:   msg.Op,
+			Meta: msg.Meta.stamped(msgCache.nextSeq()),
+			Data: msg.Data,
+		}, nil)
+		// a failed transform skips the message, the transitions are still observed
+		if err != nil {
+			s.logger.Error(err, "Serialize Message failed")
+		} else {
+			s.publish(resourceMsg)
+		}
 	}
-	msgData, err := msg.MarshalJSON(nil)
+	s.observeTransitions(msgCache, u, msg.Op != NewResource)
+}
+
+// registerSchema publishes the JSON Schema of obj, unless the restored checkpoint shows it was
+// registered already. s.mtx must be held by the caller.
+func (s *MessageStore) registerSchema(obj interface{}, u *unstructured.Unstructured) bool {
+	schemaID := utils.JSONSchemaID(u)
+	schemaObj := jsonschema.Reflect(obj)
+	schemaObj.Definitions["Extras"] = &jsonschema.Type{
+		Type: "array",
+		Extras: map[string]interface{}{
+			"template": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"key": map[string]string{
+						"type": "string",
+					},
+					"val": map[string]string{
+						"type": "string",
+					},
+				},
+			},
+		},
+	}
+	schemaData, err := schemaObj.MarshalJSON()
 	if err != nil {
-		s.logger.Error(err, "Serialize Message failed")
+		s.logger.Error(err, "Serialize JSON Schema failed")
+		return false
+	}
+	msg := &Message{
+		Op:      RegisterSchema,
+		Data:    schemaData,
+		dropped: s.onDropped,
+	}
+	if schemaID != s.restoredSchemaID && s.handler != nil {
+		if err = s.handler.Publish(msg); err != nil {
+			s.logger.Error(err, "Register JSON Schema failed")
+			atomic.AddUint64(&s.pubErrCount, 1)
+			return false
+		}
+		atomic.AddUint64(&s.pubCount, 1)
 	}
-	_ = s.handler.Publish(msgData)
+	s.schemaID = schemaID
+	s.schemaMsg = msg
+	s.dirty = true
+	return true
 }
 
 func (s *MessageStore) OnResourceUpdate(obj interface{}, u *unstructured.Unstructured) {
-	objRawData, err := json.Marshal(obj)
+	objRawData, hash, err := s.marshalState(obj)
 	if err != nil {
 		s.logger.Error(err, "Build Message failed")
 		return
 	}
-	msg := &Message{
-		Op: UpdateResource,
-		Meta: &ResourceMeta{
-			SchemaID:  s.schemaID,
-			Namespace: u.GetNamespace(),
-			Name:      u.GetName(),
-		},
-		Data: objRawData,
-	}
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 	key := cacheKey(s.schemaID, u.GetNamespace(), u.GetName())
-	oldCache, exists := s.cache[key]
-	if exists {
-		if msg.Equal(oldCache.Message) {
-			return
-		}
-		msgData, err := msg.MarshalJSON(oldCache.Metrics)
-		if err != nil {
-			s.logger.Error(err, "Serialize Message failed")
-		}
-		_ = s.handler.Publish(msgData)
-	} else {
-		s.cache[key] = &MessageCache{
-			Message: msg,
-			Metrics: make(map[string]interface{}),
-		}
-		msgData, err := msg.MarshalJSON(nil)
-		if err != nil {
-			s.logger.Error(err, "Serialize Message failed")
-		}
-		_ = s.handler.Publish(msgData)
+	msgCache, exists := s.cache[key]
+	if s.schemaID == "" || !exists {
+		// the New message of obj never went out, the schema registration failed
+		s.addResource(obj, u)
+		return
+	}
+	if msgCache.sameState(hash) {
+		return
+	}
+	msgCache.Message = &Message{
+		Op:   UpdateResource,
+		Meta: s.resourceMeta(u.GetNamespace(), u.GetName(), u),
+		Data: objRawData,
+	}
+	msgCache.hash = hash
+	s.dirty = true
+	s.observeTransitions(msgCache, u, true)
+	if !s.transitionsOnly {
+		s.scheduleUpdate(key)
 	}
 }
 
@@ -159,57 +334,190 @@ func (s *MessageStore) OnMetricUpdate(r *prom.MetricResult) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 	key := cacheKey(s.schemaID, r.ResNamespace, r.ResName)
-	oldCache, exists := s.cache[key]
-	if exists {
-		if reflect.DeepEqual(oldCache.Metrics, r.Fields) {
-			return
-		}
-		oldCache.Metrics = r.Fields
-		msgData, err := oldCache.Message.MarshalJSON(r.Fields)
-		if err != nil {
-			s.logger.Error(err, "Serialize Message failed")
+	msgCache, exists := s.cache[key]
+	if !exists {
+		// the result of a poll in flight when the resource was deleted or unselected
+		return
+	}
+
+	now := time.Now()
+	metrics := make(map[string]interface{}, len(msgCache.Metrics))
+	for field, val := range msgCache.Metrics {
+		metrics[field] = val
+	}
+	changed := false
+	for field, val := range r.Fields {
+		value, ok := toFloat(val)
+		if !ok {
+			if !reflect.DeepEqual(metrics[field], val) {
+				metrics[field] = val
+				changed = true
+			}
+			continue
 		}
-		_ = s.handler.Publish(msgData)
-	} else {
-		msg := &Message{
-			Op: UpdateResource,
-			Meta: &ResourceMeta{
-				SchemaID:  s.schemaID,
-				Namespace: r.ResNamespace,
-				Name:      r.ResName,
-			},
+		state := msgCache.metricState(field)
+		policy := s.policies[field]
+		for _, alert := range state.evaluate(field, policy, value, now) {
+			s.publishAlert(msgCache, alert)
 		}
-		s.cache[key] = &MessageCache{
-			Message: msg,
-			Metrics: r.Fields,
+		if state.shouldPublish(policy, value, now) {
+			state.markPublished(value, now)
+			metrics[field] = value
+			changed = true
 		}
-		msgData, err := msg.MarshalJSON(r.Fields)
-		if err != nil {
-			s.logger.Error(err, "Serialize Message failed")
-		}
-		_ = s.handler.Publish(msgData)
 	}
+	if !changed {
+		return
+	}
+	msgCache.Metrics = metrics
+	s.scheduleUpdate(key)
 }
 
-func (s *MessageStore) OnResourceDel(obj interface{}, u *unstructured.Unstructured) {
-	objRawData, err := json.Marshal(obj.(ctrl.ObjectMeta))
+func (s *MessageStore) publishAlert(msgCache *MessageCache, alert *Alert) {
+	alertData, err := json.Marshal(alert)
 	if err != nil {
+		s.logger.Error(err, "Serialize Alert failed")
 		return
 	}
 	msg := &Message{
-		Op: DelResource,
-		Meta: &ResourceMeta{
-			SchemaID:  s.schemaID,
-			Namespace: u.GetNamespace(),
-			Name:      u.GetName(),
-		},
-		Data: objRawData,
+		Op:   AlertResource,
+		Meta: msgCache.Message.Meta.stamped(msgCache.nextSeq()),
+		Data: alertData,
+	}
+	s.publish(msg)
+}
+
+// OnResourceDel publishes a Delete carrying the final state of obj, or the cached state when obj
+// can't be serialized, and forgets the resource. finalStateUnknown is set for the tombstones of
+// deletes missed by the informer.
+func (s *MessageStore) OnResourceDel(obj interface{}, u *unstructured.Unstructured, finalStateUnknown bool) {
+	reason := ResourceDeleted
+	if finalStateUnknown {
+		reason = ResourceDeletedFinalStateUnknown
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	msgCache := s.evict(u)
+	data, _, err := s.marshalState(obj)
+	if err != nil {
+		if msgCache == nil {
+			s.logger.Error(err, "Build Message failed")
+			return
+		}
+		data = msgCache.Message.Data
+	}
+	seq := uint64(1)
+	metrics := map[string]interface{}(nil)
+	if msgCache != nil {
+		seq = msgCache.nextSeq()
+		metrics = msgCache.Metrics
+	}
+	s.publishDelete(&Message{
+		Op:     DelResource,
+		Meta:   s.resourceMeta(u.GetNamespace(), u.GetName(), u).stamped(seq),
+		Data:   data,
+		Reason: reason,
+	}, metrics)
+}
+
+// OnResourceUnselected publishes a Delete carrying the last known state of a resource which no
+// longer matches the selector, and forgets it.
+func (s *MessageStore) OnResourceUnselected(u *unstructured.Unstructured) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	msgCache := s.evict(u)
+	if msgCache == nil {
+		return
+	}
+	s.publishDelete(&Message{
+		Op:     DelResource,
+		Meta:   msgCache.Message.Meta.stamped(msgCache.nextSeq()),
+		Data:   msgCache.Message.Data,
+		Reason: ResourceUnselected,
+	}, msgCache.Metrics)
+}
+
+// evict forgets the resource u and drops its pending update, it returns the cached state if any.
+// s.mtx must be held by the caller.
+func (s *MessageStore) evict(u *unstructured.Unstructured) *MessageCache {
+	delete(s.restored, resourceKey(u.GetNamespace(), u.GetName()))
+	key := cacheKey(s.schemaID, u.GetNamespace(), u.GetName())
+	if timer, exists := s.pending[key]; exists {
+		timer.Stop()
+		delete(s.pending, key)
+	}
+	msgCache, exists := s.cache[key]
+	if !exists {
+		return nil
 	}
-	msgData, err := msg.MarshalJSON(nil)
+	delete(s.cache, key)
+	s.dirty = true
+	return msgCache
+}
+
+func (s *MessageStore) publishDelete(msg *Message, metrics map[string]interface{}) {
+	resourceMsg, err := s.marshalResource(msg, metrics)
 	if err != nil {
 		s.logger.Error(err, "Serialize Message failed")
+		return
 	}
-	_ = s.handler.Publish(msgData)
+	s.publish(resourceMsg)
+}
+
+// Close reports the monitor offline and drops the pending updates, nothing is published by the
+// store afterwards.
+func (s *MessageStore) Close() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.closed {
+		s.publishHeartbeat(Offline)
+		unregisterStore(s)
+	}
+	s.closed = true
+	for sub := range s.subscribers {
+		s.dropSubscriber(sub, ErrStoreClosed)
+	}
+	for key, timer := range s.pending {
+		timer.Stop()
+		delete(s.pending, key)
+	}
+}
+
+// marshalState returns the payload of obj and the hash of its state without ignored fields.
+func (s *MessageStore) marshalState(obj interface{}) ([]byte, string, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, stateData, err := s.filter.apply(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(stateData)
+	return payload, hex.EncodeToString(sum[:]), nil
+}
+
+// resourceMeta describes the object namespace/name, u may be nil when the object isn't at hand.
+// s.mtx must be held by the caller.
+func (s *MessageStore) resourceMeta(namespace, name string, u *unstructured.Unstructured) *ResourceMeta {
+	meta := &ResourceMeta{
+		SchemaID:         s.schemaID,
+		Namespace:        namespace,
+		Name:             name,
+		MonitorNamespace: s.monitorNamespace,
+		MonitorName:      s.monitorName,
+		GatewayID:        GatewayID,
+	}
+	if u != nil {
+		meta.UID = u.GetUID()
+		meta.ResourceVersion = u.GetResourceVersion()
+		meta.Generation = u.GetGeneration()
+	}
+	return meta
+}
+
+func resourceKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
 }
 
 func cacheKey(schemaID, namespace, name string) string {
@@ -0,0 +1,252 @@
+package msg
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/fusion-app/gateway/pkg/prom"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+type recordHandler struct {
+	mtx  sync.Mutex
+	msgs [][]byte
+}
+
+func (h *recordHandler) Publish(msg *Message) error {
+	encoded, err := NewEnvelope(nil, nil, false).Encode(msg)
+	if err != nil {
+		return err
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.msgs = append(h.msgs, encoded.Body)
+	return nil
+}
+
+func (h *recordHandler) count() int {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return len(h.msgs)
+}
+
+// flakyHandler fails the first publishes.
+type flakyHandler struct {
+	recordHandler
+	failures int
+}
+
+func (h *flakyHandler) Publish(msg *Message) error {
+	if h.failures > 0 {
+		h.failures--
+		return errors.New("backend unavailable")
+	}
+	return h.recordHandler.Publish(msg)
+}
+
+func newTestStore(handler MsgHandler) *MessageStore {
+	return &MessageStore{
+		logger:  ctrl.Log.WithName("store"),
+		handler: handler,
+		cache:   make(map[string]*MessageCache),
+		pending: make(map[string]*time.Timer),
+	}
+}
+
+func newTestObject(phase string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      "test",
+		},
+		"status": map[string]interface{}{
+			"phase": phase,
+		},
+	}}
+}
+
+func TestMessageStore_Debounce(t *testing.T) {
+	handler := &recordHandler{}
+	store := newTestStore(handler)
+	store.debounce = time.Millisecond * 50
+	created := newTestObject("Created")
+	store.OnResourceAdd(created, created)
+	added := handler.count()
+
+	for _, phase := range []string{"Pending", "Scheduling", "Running"} {
+		obj := newTestObject(phase)
+		store.OnResourceUpdate(obj, obj)
+	}
+	time.Sleep(time.Millisecond * 150)
+
+	if handler.count()-added != 1 {
+		t.Fatalf("expected 1 coalesced message, got %d", handler.count()-added)
+	}
+	if stats := store.Stats(); stats.Coalesced != 2 {
+		t.Errorf("expected 2 coalesced updates, got %d", stats.Coalesced)
+	}
+}
+
+func TestMessageStore_RateLimit(t *testing.T) {
+	handler := &recordHandler{}
+	store := newTestStore(handler)
+	created := newTestObject("Created")
+	store.OnResourceAdd(created, created)
+	added := handler.count()
+	store.limiter = rate.NewLimiter(rate.Limit(10), 1)
+
+	for _, phase := range []string{"Pending", "Running"} {
+		obj := newTestObject(phase)
+		store.OnResourceUpdate(obj, obj)
+	}
+	if handler.count()-added != 1 {
+		t.Fatalf("expected 1 message before the limit is refilled, got %d", handler.count()-added)
+	}
+	time.Sleep(time.Millisecond * 250)
+
+	if handler.count()-added != 2 {
+		t.Fatalf("expected the delayed update to be published, got %d", handler.count()-added)
+	}
+	if stats := store.Stats(); stats.Delayed != 1 {
+		t.Errorf("expected 1 delayed update, got %d", stats.Delayed)
+	}
+}
+
+func TestMessageStore_PublishErrors(t *testing.T) {
+	handler := &flakyHandler{failures: 1}
+	store := newTestStore(handler)
+	obj := newTestObject("Running")
+	store.OnResourceAdd(obj, obj)
+	if stats := store.Stats(); stats.PublishErrors != 1 || handler.count() != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// the failed schema registration is retried, and the New message sent instead of the Update
+	store.OnResourceUpdate(obj, obj)
+	var ops []ResourceOp
+	for _, data := range handler.msgs {
+		msg := &struct {
+			Op   ResourceOp    `json:"op"`
+			Meta *ResourceMeta `json:"meta"`
+		}{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			t.Fatal(err)
+		}
+		ops = append(ops, msg.Op)
+		if msg.Op == NewResource && msg.Meta.SchemaID == "" {
+			t.Errorf("New message without schema ID")
+		}
+	}
+	if len(ops) != 2 || ops[0] != RegisterSchema || ops[1] != NewResource {
+		t.Errorf("expected the schema and the New message, got %v", ops)
+	}
+	if stats := store.Stats(); stats.PublishErrors != 1 || stats.Published != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestMessageStore_ResourceMeta(t *testing.T) {
+	handler := &recordHandler{}
+	store := newTestStore(handler)
+	obj := newTestObject("Pending")
+	obj.SetUID("uid-1")
+	store.OnResourceAdd(obj, obj)
+	obj = newTestObject("Running")
+	obj.SetUID("uid-1")
+	store.OnResourceUpdate(obj, obj)
+	recreated := newTestObject("Running")
+	recreated.SetUID("uid-2")
+	store.OnResourceAdd(recreated, recreated)
+
+	// the schema registration comes first
+	if handler.count() != 4 {
+		t.Fatalf("expected 4 messages, got %d", handler.count())
+	}
+	for i, expected := range []struct {
+		op  ResourceOp
+		uid string
+		seq uint64
+	}{
+		{NewResource, "uid-1", 1},
+		{UpdateResource, "uid-1", 2},
+		{NewResource, "uid-2", 3},
+	} {
+		msg := &struct {
+			Op   ResourceOp    `json:"op"`
+			Meta *ResourceMeta `json:"meta"`
+		}{}
+		if err := json.Unmarshal(handler.msgs[i+1], msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Op != expected.op || string(msg.Meta.UID) != expected.uid || msg.Meta.Seq != expected.seq {
+			t.Errorf("message %d: expected %s of %s with seq %d, got %s of %s with seq %d", i, expected.op,
+				expected.uid, expected.seq, msg.Op, msg.Meta.UID, msg.Meta.Seq)
+		}
+		if msg.Meta.Timestamp.IsZero() {
+			t.Errorf("message %d: expected an emit timestamp", i)
+		}
+	}
+}
+
+func TestMessageStore_OnResourceDel(t *testing.T) {
+	handler := &recordHandler{}
+	store := newTestStore(handler)
+	obj := newTestObject("Running")
+	store.OnResourceAdd(obj, obj)
+	store.OnMetricUpdate(&prom.MetricResult{ResNamespace: "default", ResName: "test", Fields: map[string]interface{}{"cpu_use": 0.5}})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+		Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+	}
+	deleted, finalStateUnknown := utils.UnwrapTombstone(toolscache.DeletedFinalStateUnknown{Key: "default/test", Obj: pod})
+	if !finalStateUnknown || deleted != pod {
+		t.Fatalf("expected the tombstone to be unwrapped")
+	}
+	store.OnResourceDel(deleted, utils.ToUnstructured(deleted), finalStateUnknown)
+
+	if len(store.cache) != 0 {
+		t.Errorf("expected the deleted resource to be evicted")
+	}
+	// a poll in flight during the delete
+	published := handler.count()
+	store.OnMetricUpdate(&prom.MetricResult{ResNamespace: "default", ResName: "test", Fields: map[string]interface{}{"cpu_use": 0.7}})
+	if len(store.cache) != 0 || handler.count() != published {
+		t.Errorf("expected the metrics of the deleted resource to be dropped")
+	}
+	msg := &struct {
+		Op     ResourceOp    `json:"op"`
+		Meta   *ResourceMeta `json:"meta"`
+		Data   []byte        `json:"data"`
+		Reason DeleteReason  `json:"reason"`
+	}{}
+	if err := json.Unmarshal(handler.msgs[handler.count()-1], msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Op != DelResource || msg.Reason != ResourceDeletedFinalStateUnknown {
+		t.Errorf("expected a Delete for %s, got %s for %s", ResourceDeletedFinalStateUnknown, msg.Op, msg.Reason)
+	}
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(msg.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := data["status"].(map[string]interface{})
+	if status["phase"] != string(corev1.PodSucceeded) {
+		t.Errorf("expected the final state, got %v", data)
+	}
+	if extras, _ := data["extras"].(map[string]interface{}); extras["cpu_use"] != 0.5 {
+		t.Errorf("expected the last metrics, got %v", data)
+	}
+}
@@ -0,0 +1,189 @@
+package msg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/topic"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+var natsLogger = ctrl.Log.WithName("nats")
+
+const defaultJetStreamAckTimeout = time.Second * 5
+
+// NATSMsgHandler publishes to core NATS, or to JetStream waiting for the acks.
+type NATSMsgHandler struct {
+	conn      *nats.Conn
+	jetStream nats.JetStreamContext
+	stream    string
+	subject   *template.Template
+	envelope  *Envelope
+}
+
+// NewNATSMsgHandler reads the credentials from the Secrets in namespace, the connection is retried
+// in the background when no server is reachable.
+func NewNATSMsgHandler(spec *monitorv1alpha1.NATSBackendSpec, reader client.Reader, namespace string) (*NATSMsgHandler, error) {
+	subject, err := topic.Parse(spec.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	opts := []nats.Option{
+		nats.Name("k8s-gateway-" + GatewayID),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			natsLogger.Error(err, "Disconnected")
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			natsLogger.Info("Reconnected", "server", conn.ConnectedUrl())
+		}),
+	}
+	if spec.Credentials != nil {
+		creds, err := utils.SecretValue(ctx, reader, namespace, spec.Credentials)
+		if err != nil {
+			return nil, err
+		}
+		userJWT, err := nkeys.ParseDecoratedJWT(creds)
+		if err != nil {
+			return nil, err
+		}
+		keyPair, err := nkeys.ParseDecoratedNKey(creds)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.UserJWT(func() (string, error) { return userJWT, nil }, keyPair.Sign))
+	} else if spec.NKey != nil {
+		seed, err := utils.SecretValue(ctx, reader, namespace, spec.NKey)
+		if err != nil {
+			return nil, err
+		}
+		keyPair, err := nkeys.FromSeed(bytes.TrimSpace(seed))
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err := keyPair.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Nkey(publicKey, keyPair.Sign))
+	}
+	if spec.TLSConfig != nil {
+		tlsConfig, err := utils.TLSClientConfig(ctx, reader, namespace, spec.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	conn, err := nats.Connect(strings.Join(spec.Servers, ","), opts...)
+	if err != nil {
+		return nil, err
+	}
+	h := &NATSMsgHandler{
+		conn:     conn,
+		subject:  subject,
+		envelope: NewEnvelope(spec.Envelope, spec.Encoding, true),
+	}
+	if spec.JetStream != nil {
+		ackTimeout := defaultJetStreamAckTimeout
+		if spec.JetStream.AckTimeout != nil && spec.JetStream.AckTimeout.Duration > 0 {
+			ackTimeout = spec.JetStream.AckTimeout.Duration
+		}
+		if h.jetStream, err = conn.JetStream(nats.MaxWait(ackTimeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		h.stream = spec.JetStream.Stream
+	}
+	return h, nil
+}
+
+func (h *NATSMsgHandler) Publish(msg *Message) error {
+	natsMsg, err := h.natsMsg(msg)
+	if err != nil {
+		natsLogger.Error(err, "Encode message failed")
+		return err
+	}
+	if h.jetStream == nil {
+		if err := h.conn.PublishMsg(natsMsg); err != nil {
+			natsLogger.Error(err, "Publish failed", "subject", natsMsg.Subject)
+			return err
+		}
+		return nil
+	}
+
+	pubOpts := []nats.PubOpt{nats.MsgId(deduplicationID(msg))}
+	if h.stream != "" {
+		pubOpts = append(pubOpts, nats.ExpectStream(h.stream))
+	}
+	ack, err := h.jetStream.PublishMsg(natsMsg, pubOpts...)
+	if err != nil {
+		natsLogger.Error(err, "Publish failed", "subject", natsMsg.Subject)
+		return err
+	}
+	if ack.Duplicate {
+		natsLogger.V(1).Info("Duplicate message dropped by the stream", "subject", natsMsg.Subject, "stream", ack.Stream)
+	}
+	return nil
+}
+
+func (h *NATSMsgHandler) natsMsg(msg *Message) (*nats.Msg, error) {
+	encoded, err := h.envelope.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	subject, err := renderTopic(h.subject, msg, subjectToken)
+	if err != nil {
+		return nil, err
+	}
+	natsMsg := nats.NewMsg(subject)
+	natsMsg.Data = encoded.Body
+	if len(encoded.Attributes) > 0 {
+		// binary CloudEvents, headers need a NATS 2.2 server
+		natsMsg.Header.Set("Content-Type", encoded.ContentType)
+		for name, value := range encoded.Attributes {
+			natsMsg.Header.Set("ce-"+name, value)
+		}
+	}
+	return natsMsg, nil
+}
+
+// Close flushes the pending messages and closes the connection.
+func (h *NATSMsgHandler) Close() error {
+	err := h.conn.FlushTimeout(time.Second * 3)
+	h.conn.Close()
+	return err
+}
+
+// subjectToken replaces the characters separating and matching subject tokens.
+func subjectToken(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(value)
+}
+
+// deduplicationID identifies a message for the de-duplication window of a stream, a redelivered
+// message keeps its ID. Messages without a resource are identified by their payload.
+func deduplicationID(msg *Message) string {
+	if msg.Meta != nil && msg.Meta.Name != "" {
+		return messageID(msg.Meta)
+	}
+	sum := sha256.Sum256(msg.Data)
+	return fmt.Sprintf("%s:%s", msg.Op, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,56 @@
+package msg
+
+import (
+	"testing"
+	"text/template"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/topic"
+)
+
+func TestRenderSubject(t *testing.T) {
+	tmpl, err := topic.Parse("k8s.{{.Kind}}.{{.Namespace}}.{{.Name}}.{{lower .Op}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Op: UpdateResource, Meta: &ResourceMeta{SchemaID: "apps/v1/Deployment", Namespace: "default", Name: "web.v2"}}
+	if subject, err := renderTopic(tmpl, msg, subjectToken); err != nil || subject != "k8s.Deployment.default.web_v2.update" {
+		t.Errorf("subject = %s, %v", subject, err)
+	}
+	schemaMsg := &Message{Op: RegisterSchema, Data: []byte(`{}`)}
+	if subject, err := renderTopic(tmpl, schemaMsg, subjectToken); err != nil || subject != "k8s._._._.registerschema" {
+		t.Errorf("subject = %s, %v", subject, err)
+	}
+}
+
+func TestDeduplicationID(t *testing.T) {
+	msg := &Message{Op: UpdateResource, Meta: &ResourceMeta{Namespace: "default", Name: "web", UID: "1234", Seq: 7}}
+	if id := deduplicationID(msg); id != "1234:7" {
+		t.Errorf("id = %s", id)
+	}
+	schemaMsg := &Message{Op: RegisterSchema, Data: []byte(`{}`)}
+	if deduplicationID(schemaMsg) != deduplicationID(&Message{Op: RegisterSchema, Data: []byte(`{}`)}) {
+		t.Errorf("the ID of a schema isn't stable")
+	}
+}
+
+func TestNATSMsgHandler_BinaryHeaders(t *testing.T) {
+	h := &NATSMsgHandler{
+		subject: template.Must(template.New("subject").Parse("k8s.{{.Name}}")),
+		envelope: NewEnvelope(&monitorv1alpha1.EnvelopeSpec{
+			Format: monitorv1alpha1.CloudEventsEnvelope,
+			Mode:   monitorv1alpha1.BinaryMode,
+		}, nil, true),
+	}
+	natsMsg, err := h.natsMsg(newTestMessage("web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if natsMsg.Subject != "k8s.web" || natsMsg.Header.Get("ce-subject") != "default/web" ||
+		natsMsg.Header.Get("Content-Type") != jsonContentType {
+		t.Errorf("msg = %s %v", natsMsg.Subject, natsMsg.Header)
+	}
+	if string(natsMsg.Data) != `{"status":{"phase":"Running"}}` {
+		t.Errorf("data = %s", natsMsg.Data)
+	}
+}
@@ -0,0 +1,78 @@
+package msg
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// transformInput is what the jq program or the template of a transform sees.
+type transformInput struct {
+	Op      ResourceOp             `json:"op"`
+	Meta    *ResourceMeta          `json:"meta"`
+	Object  json.RawMessage        `json:"object"`
+	Metrics map[string]interface{} `json:"metrics"`
+}
+
+// marshalResource builds the published New, Update or Delete message, the payload is rendered by
+// the transform when one is configured. s.mtx must be held by the caller.
+func (s *MessageStore) marshalResource(msg *Message, metrics map[string]interface{}) (*Message, error) {
+	if s.transform == nil {
+		return msg.withExtras(metrics)
+	}
+	data, err := s.render(msg, metrics)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Op:     msg.Op,
+		Meta:   msg.Meta,
+		Data:   data,
+		Reason: msg.Reason,
+	}, nil
+}
+
+func (s *MessageStore) render(msg *Message, metrics map[string]interface{}) ([]byte, error) {
+	object := json.RawMessage(msg.Data)
+	if len(object) == 0 {
+		object = json.RawMessage("null")
+	}
+	if metrics == nil {
+		metrics = map[string]interface{}{}
+	}
+	// the round trip turns the input into the plain JSON values expected by jq
+	raw, err := json.Marshal(&transformInput{Op: msg.Op, Meta: msg.Meta, Object: object, Metrics: metrics})
+	if err != nil {
+		return nil, err
+	}
+	var input interface{}
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return nil, err
+	}
+	return s.transform.Render(input)
+}
+
+// Preview returns the payload which would be published for obj with its cached metrics.
+func (s *MessageStore) Preview(obj interface{}, u *unstructured.Unstructured) ([]byte, error) {
+	objRawData, _, err := s.marshalState(obj)
+	if err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	msg := &Message{
+		Op:   UpdateResource,
+		Meta: s.resourceMeta(u.GetNamespace(), u.GetName(), u),
+		Data: objRawData,
+	}
+	var metrics map[string]interface{}
+	if msgCache, exists := s.cache[cacheKey(s.schemaID, u.GetNamespace(), u.GetName())]; exists {
+		msg.Meta = msg.Meta.stamped(msgCache.seq)
+		metrics = msgCache.Metrics
+	}
+	if s.transform == nil {
+		// the payload of the message as it is published
+		return (&Message{Data: msg.Data}).payload(metrics)
+	}
+	return s.render(msg, metrics)
+}
@@ -0,0 +1,74 @@
+package msg
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/fusion-app/gateway/pkg/transform"
+)
+
+func TestMessageStore_Transform(t *testing.T) {
+	handler := &recordHandler{}
+	store := newTestStore(handler)
+	var err error
+	store.transform, err = transform.New(`{op: .op, name: .meta.name, phase: .object.status.phase, cpu: .metrics.cpu_use}`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := newTestObject("Running")
+	store.OnResourceAdd(obj, obj)
+	store.cache[cacheKey(store.schemaID, "default", "test")].Metrics["cpu_use"] = 0.5
+
+	msg := &struct {
+		Data []byte `json:"data"`
+	}{}
+	if err := json.Unmarshal(handler.msgs[1], msg); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"cpu":null,"name":"test","op":"New","phase":"Running"}`; string(msg.Data) != expected {
+		t.Errorf("expected payload %s, got %s", expected, msg.Data)
+	}
+
+	payload, err := store.Preview(obj, obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"cpu":0.5,"name":"test","op":"Update","phase":"Running"}`; string(payload) != expected {
+		t.Errorf("expected preview %s, got %s", expected, payload)
+	}
+}
+
+func TestMessageStore_TransformFailed(t *testing.T) {
+	handler := &recordHandler{}
+	store := newTestStore(handler)
+	var err error
+	store.transform, err = transform.New(`.object.status.phase | ascii_downcase`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sub, err := store.Subscribe(SubscriptionFilter{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := newTestObject("Running")
+	unstructured.RemoveNestedField(obj.Object, "status")
+	store.OnResourceAdd(obj, obj)
+
+	// only the schema registration
+	if handler.count() != 1 {
+		t.Fatalf("expected the failed message to be skipped, got %d messages", handler.count())
+	}
+	select {
+	case msg := <-sub.C:
+		t.Errorf("unexpected %s delivered", msg.Op)
+	default:
+	}
+
+	obj = newTestObject("Running")
+	store.OnResourceUpdate(obj, obj)
+	if msg := <-sub.C; msg.Op != UpdateResource || string(msg.Data) != `"running"` {
+		t.Errorf("message = %s %s", msg.Op, msg.Data)
+	}
+}
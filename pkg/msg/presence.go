@@ -0,0 +1,96 @@
+package msg
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type PresenceState string
+
+const (
+	Online  PresenceState = "online"
+	Offline PresenceState = "offline"
+)
+
+// Presence is published on the status topic of the gateway, and with Heartbeat set on the status
+// topic of a monitor.
+type Presence struct {
+	State     PresenceState `json:"state"`
+	GatewayID string        `json:"gateway_id"`
+	// Timestamp is unset in the last will
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Heartbeat *Heartbeat `json:"heartbeat,omitempty"`
+}
+
+type Heartbeat struct {
+	MonitorNamespace string `json:"monitor_namespace"`
+	MonitorName      string `json:"monitor_name"`
+	// Selected is the number of resources selected by the monitor
+	Selected int `json:"selected"`
+	// LastOffset is the offset of the last message of the monitor, the position the subscription
+	// API resumes from
+	LastOffset uint64 `json:"last_offset"`
+	Published  uint64 `json:"published"`
+}
+
+// PresenceHandler is a MsgHandler announcing the presence of the gateway, the monitors report
+// their heartbeats through it.
+type PresenceHandler interface {
+	PublishPresence(*Presence) error
+	// HeartbeatInterval is 0 if no heartbeats are wanted
+	HeartbeatInterval() time.Duration
+}
+
+// HeartbeatInterval of the backend, 0 if it takes no heartbeats.
+func (s *MessageStore) HeartbeatInterval() time.Duration {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if handler, ok := s.handler.(PresenceHandler); ok {
+		return handler.HeartbeatInterval()
+	}
+	return 0
+}
+
+// PublishHeartbeat reports the state of the monitor to a PresenceHandler backend.
+func (s *MessageStore) PublishHeartbeat() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.closed {
+		s.publishHeartbeat(Online)
+	}
+}
+
+// publishHeartbeat, s.mtx must be held by the caller.
+func (s *MessageStore) publishHeartbeat(state PresenceState) {
+	handler, ok := s.handler.(PresenceHandler)
+	if !ok || handler.HeartbeatInterval() == 0 {
+		return
+	}
+	now := time.Now()
+	presence := &Presence{
+		State:     state,
+		GatewayID: GatewayID,
+		Timestamp: &now,
+		Heartbeat: &Heartbeat{
+			MonitorNamespace: s.monitorNamespace,
+			MonitorName:      s.monitorName,
+			Selected:         s.selectedCount(),
+			LastOffset:       s.offset,
+			Published:        atomic.LoadUint64(&s.pubCount),
+		},
+	}
+	if err := handler.PublishPresence(presence); err != nil {
+		s.logger.Error(err, "Publish heartbeat failed")
+	}
+}
+
+// selectedCount counts the cached resources whose object is known, s.mtx must be held by the caller.
+func (s *MessageStore) selectedCount() int {
+	selected := 0
+	for _, msgCache := range s.cache {
+		if msgCache.Message.Data != nil {
+			selected++
+		}
+	}
+	return selected
+}
@@ -0,0 +1,65 @@
+package msg
+
+import (
+	"testing"
+	"time"
+)
+
+type presenceRecordHandler struct {
+	recordHandler
+	presences []*Presence
+}
+
+func (h *presenceRecordHandler) PublishPresence(presence *Presence) error {
+	h.presences = append(h.presences, presence)
+	return nil
+}
+
+func (h *presenceRecordHandler) HeartbeatInterval() time.Duration {
+	return time.Second
+}
+
+func TestMessageStore_Heartbeat(t *testing.T) {
+	handler := &presenceRecordHandler{}
+	store := newTestStore(handler)
+	store.monitorNamespace, store.monitorName = "default", "pods"
+	if store.HeartbeatInterval() != time.Second {
+		t.Errorf("HeartbeatInterval = %v", store.HeartbeatInterval())
+	}
+
+	obj := newTestObject("Pending")
+	store.OnResourceAdd(obj, obj)
+	other := newTestObject("Pending")
+	other.SetName("other")
+	store.OnResourceAdd(other, other)
+	// an entry without object doesn't count
+	store.cache[cacheKey(store.schemaID, "default", "restored")] = &MessageCache{
+		Message: &Message{Op: NewResource, Meta: &ResourceMeta{Namespace: "default", Name: "restored"}},
+	}
+	store.OnResourceUpdate(newTestObject("Running"), newTestObject("Running"))
+	store.PublishHeartbeat()
+	store.Close()
+	store.PublishHeartbeat()
+
+	if len(handler.presences) != 2 {
+		t.Fatalf("presences = %d, want 2", len(handler.presences))
+	}
+	online, offline := handler.presences[0], handler.presences[1]
+	if online.State != Online || offline.State != Offline {
+		t.Errorf("states = %s, %s", online.State, offline.State)
+	}
+	heartbeat := online.Heartbeat
+	if heartbeat.MonitorName != "pods" || heartbeat.Selected != 2 || heartbeat.LastOffset != 3 ||
+		heartbeat.Published != uint64(handler.count()) {
+		t.Errorf("heartbeat = %+v, published %d", heartbeat, handler.count())
+	}
+}
+
+func TestMessageStore_HeartbeatUnsupported(t *testing.T) {
+	store := newTestStore(&recordHandler{})
+	if store.HeartbeatInterval() != 0 {
+		t.Errorf("HeartbeatInterval = %v", store.HeartbeatInterval())
+	}
+	store.PublishHeartbeat()
+	store.Close()
+}
@@ -0,0 +1,300 @@
+package msg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	protoPackage   = "fusionapp.gateway"
+	protoValueType = "google.protobuf.Value"
+)
+
+// protoSchema is the protobuf message generated from a registered JSON Schema.
+type protoSchema struct {
+	message protoreflect.MessageDescriptor
+	file    *descriptorpb.FileDescriptorProto
+}
+
+// newProtoSchema generates one message per object definition of the JSON Schema, fields are
+// numbered in the order of their sorted names. Values without a usable type become
+// google.protobuf.Value.
+func newProtoSchema(schemaData []byte) (*protoSchema, error) {
+	schema := map[string]interface{}{}
+	if err := json.Unmarshal(schemaData, &schema); err != nil {
+		return nil, err
+	}
+	definitions, _ := schema["definitions"].(map[string]interface{})
+	b := &protoBuilder{definitions: definitions, messages: make(map[string]*descriptorpb.DescriptorProto)}
+	rootName := b.messageName(schema, "Root")
+	if rootName == "" {
+		return nil, fmt.Errorf("the schema doesn't describe an object")
+	}
+	addExtrasField(b.messages[rootName])
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(strings.ToLower(rootName) + ".proto"),
+		Package:    proto.String(protoPackage),
+		Dependency: []string{"google/protobuf/struct.proto"},
+		Syntax:     proto.String("proto3"),
+	}
+	names := make([]string, 0, len(b.messages))
+	for name := range b.messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file.MessageType = append(file.MessageType, b.messages[name])
+	}
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &protoSchema{message: fd.Messages().ByName(protoreflect.Name(rootName)), file: file}, nil
+}
+
+// addExtrasField adds the metrics spliced into the payloads, see Message.payload, which the
+// schema of the object doesn't describe.
+func addExtrasField(message *descriptorpb.DescriptorProto) {
+	usedNames := make(map[string]bool)
+	var number int32
+	for _, field := range message.Field {
+		if field.GetJsonName() == "extras" {
+			return
+		}
+		usedNames[strings.ToLower(field.GetName())] = true
+		if field.GetNumber() > number {
+			number = field.GetNumber()
+		}
+	}
+	message.Field = append(message.Field, &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(uniqueIdentifier("extras", usedNames)),
+		JsonName: proto.String("extras"),
+		Number:   proto.Int32(number + 1),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+		TypeName: proto.String("." + protoValueType),
+	})
+}
+
+func (s *protoSchema) fullName() string {
+	return string(s.message.FullName())
+}
+
+type protoBuilder struct {
+	definitions map[string]interface{}
+	// key: message name
+	messages map[string]*descriptorpb.DescriptorProto
+}
+
+// messageName returns the message generated for an object schema, or "" if the schema is no object
+// with properties. name is used for inline objects.
+func (b *protoBuilder) messageName(schema map[string]interface{}, name string) string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name = strings.TrimPrefix(ref, "#/definitions/")
+		schema, _ = b.definitions[name].(map[string]interface{})
+		if schema == nil {
+			return ""
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	if schema["type"] != "object" || len(properties) == 0 {
+		return ""
+	}
+	name = protoIdentifier(name, true)
+	if _, exists := b.messages[name]; exists {
+		return name
+	}
+	message := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	// registered before the fields, recursive definitions refer to it
+	b.messages[name] = message
+
+	propNames := make([]string, 0, len(properties))
+	for propName := range properties {
+		propNames = append(propNames, propName)
+	}
+	sort.Strings(propNames)
+	usedNames := make(map[string]bool)
+	for i, propName := range propNames {
+		propSchema, _ := properties[propName].(map[string]interface{})
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(uniqueIdentifier(protoIdentifier(propName, false), usedNames)),
+			JsonName: proto.String(propName),
+			Number:   proto.Int32(int32(i + 1)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if propSchema["type"] == "array" {
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			propSchema, _ = propSchema["items"].(map[string]interface{})
+			if propSchema["type"] == "array" {
+				// repeated fields can't nest
+				propSchema = nil
+			}
+		}
+		b.setFieldType(field, propSchema, name+protoIdentifier(propName, true))
+		message.Field = append(message.Field, field)
+	}
+	return name
+}
+
+func (b *protoBuilder) setFieldType(field *descriptorpb.FieldDescriptorProto, schema map[string]interface{}, inlineName string) {
+	if schema != nil {
+		if messageName := b.messageName(schema, inlineName); messageName != "" {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(fmt.Sprintf(".%s.%s", protoPackage, messageName))
+			return
+		}
+		if ref, ok := schema["$ref"].(string); ok {
+			schema, _ = b.definitions[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+		}
+		switch schema["type"] {
+		case "string":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+			return
+		case "integer":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+			return
+		case "number":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()
+			return
+		case "boolean":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+			return
+		}
+	}
+	field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	field.TypeName = proto.String("." + protoValueType)
+}
+
+func protoIdentifier(name string, exported bool) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) && i > 0):
+			if i == 0 && exported {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 || name[0] >= '0' && name[0] <= '9' {
+		return "X" + b.String()
+	}
+	return b.String()
+}
+
+func uniqueIdentifier(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[strings.ToLower(unique)]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// marshalProto converts decoded JSON into the message of the schema, values which don't match the
+// schema are dropped.
+func marshalProto(descriptor protoreflect.MessageDescriptor, value interface{}) ([]byte, error) {
+	if descriptor == nil {
+		protoValue, err := structpb.NewValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(protoValue)
+	}
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("payload is no object")
+	}
+	message := dynamicpb.NewMessage(descriptor)
+	setProtoFields(message, obj)
+	return proto.Marshal(message)
+}
+
+func setProtoFields(message protoreflect.Message, obj map[string]interface{}) {
+	fields := message.Descriptor().Fields()
+	for key, value := range obj {
+		field := fields.ByJSONName(key)
+		if field == nil || value == nil {
+			continue
+		}
+		if field.IsList() {
+			items, ok := value.([]interface{})
+			if !ok {
+				continue
+			}
+			list := message.Mutable(field).List()
+			for _, item := range items {
+				if protoValue, ok := protoFieldValue(field, list.NewElement, item); ok {
+					list.Append(protoValue)
+				}
+			}
+			continue
+		}
+		newMessage := func() protoreflect.Value { return message.NewField(field) }
+		if protoValue, ok := protoFieldValue(field, newMessage, value); ok {
+			message.Set(field, protoValue)
+		}
+	}
+}
+
+func protoFieldValue(field protoreflect.FieldDescriptor, newMessage func() protoreflect.Value, value interface{}) (protoreflect.Value, bool) {
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		if str, ok := value.(string); ok {
+			return protoreflect.ValueOfString(str), true
+		}
+		data, _ := json.Marshal(value)
+		return protoreflect.ValueOfString(string(data)), true
+	case protoreflect.Int64Kind:
+		switch v := value.(type) {
+		case float64:
+			if v == math.Trunc(v) {
+				return protoreflect.ValueOfInt64(int64(v)), true
+			}
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return protoreflect.ValueOfInt64(i), true
+			}
+		}
+	case protoreflect.DoubleKind:
+		if f, ok := value.(float64); ok {
+			return protoreflect.ValueOfFloat64(f), true
+		}
+	case protoreflect.BoolKind:
+		if b, ok := value.(bool); ok {
+			return protoreflect.ValueOfBool(b), true
+		}
+	case protoreflect.MessageKind:
+		if field.Message().FullName() == protoValueType {
+			protoValue, err := structpb.NewValue(value)
+			if err != nil {
+				return protoreflect.Value{}, false
+			}
+			msgValue := newMessage()
+			proto.Merge(msgValue.Message().Interface(), protoValue)
+			return msgValue, true
+		}
+		if obj, ok := value.(map[string]interface{}); ok {
+			msgValue := newMessage()
+			setProtoFields(msgValue.Message(), obj)
+			return msgValue, true
+		}
+	}
+	return protoreflect.Value{}, false
+}
@@ -0,0 +1,142 @@
+package msg
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+// Stats counts the Update messages held back by the debounce window and the rate limit, and the
+// messages the backend failed to publish.
+type Stats struct {
+	Published     uint64
+	Coalesced     uint64
+	Delayed       uint64
+	PublishErrors uint64
+}
+
+func newRateLimiter(spec *monitorv1alpha1.RateLimitSpec) (time.Duration, *rate.Limiter) {
+	if spec == nil {
+		return 0, nil
+	}
+	var debounce time.Duration
+	if spec.DebounceWindow != nil {
+		debounce = spec.DebounceWindow.Duration
+	}
+	if spec.MaxRate <= 0 {
+		return debounce, nil
+	}
+	burst := spec.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return debounce, rate.NewLimiter(rate.Limit(spec.MaxRate), burst)
+}
+
+func (s *MessageStore) Stats() Stats {
+	return Stats{
+		Published:     atomic.LoadUint64(&s.pubCount),
+		Coalesced:     atomic.LoadUint64(&s.coalescedCount),
+		Delayed:       atomic.LoadUint64(&s.delayedCount),
+		PublishErrors: atomic.LoadUint64(&s.pubErrCount),
+	}
+}
+
+// scheduleUpdate publishes the cached state of key as an Update message, immediately when
+// neither debounce nor rate limit applies, otherwise once the window is over.
+// s.mtx must be held by the caller.
+func (s *MessageStore) scheduleUpdate(key string) {
+	if _, exists := s.pending[key]; exists {
+		atomic.AddUint64(&s.coalescedCount, 1)
+		return
+	}
+	if s.debounce == 0 {
+		if s.limiter == nil || s.limiter.Allow() {
+			s.publishCached(key)
+			return
+		}
+		atomic.AddUint64(&s.delayedCount, 1)
+	}
+	s.delayUpdate(key, s.debounce)
+}
+
+// delayUpdate flushes key after delay, or once the rate limit allows it when delay is zero.
+func (s *MessageStore) delayUpdate(key string, delay time.Duration) {
+	limited := delay == 0
+	if limited {
+		delay = time.Duration(float64(time.Second) / float64(s.limiter.Limit()))
+	}
+	s.pending[key] = time.AfterFunc(delay, func() {
+		s.flushUpdate(key, limited)
+	})
+}
+
+// flushUpdate publishes key unless the rate limit delays it again, limited tells whether it was
+// already counted as delayed.
+func (s *MessageStore) flushUpdate(key string, limited bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, exists := s.pending[key]; !exists {
+		return
+	}
+	delete(s.pending, key)
+	if s.limiter != nil && !s.limiter.Allow() {
+		if !limited {
+			atomic.AddUint64(&s.delayedCount, 1)
+		}
+		s.delayUpdate(key, 0)
+		return
+	}
+	s.publishCached(key)
+}
+
+// publishCached sends the latest object state of key together with its metrics.
+// s.mtx must be held by the caller.
+func (s *MessageStore) publishCached(key string) {
+	msgCache, exists := s.cache[key]
+	if !exists {
+		return
+	}
+	s.dirty = true
+	msg := &Message{
+		Op:   msgCache.Message.Op,
+		Meta: msgCache.Message.Meta.stamped(msgCache.nextSeq()),
+		Data: msgCache.Message.Data,
+	}
+	resourceMsg, err := s.marshalResource(msg, msgCache.Metrics)
+	if err != nil {
+		s.logger.Error(err, "Serialize Message failed")
+		return
+	}
+	s.publish(resourceMsg)
+}
+
+// onDropped counts a message the backend accepted but failed to deliver afterwards.
+func (s *MessageStore) onDropped() {
+	atomic.AddUint64(&s.pubErrCount, 1)
+}
+
+// publish sends msg to the subscribers and the backend unless the store is closed, s.mtx must be
+// held by the caller.
+func (s *MessageStore) publish(msg *Message) {
+	if s.closed {
+		return
+	}
+	if s.schemaMsg != nil && s.transform == nil {
+		msg.schema = s.schemaMsg.Data
+	}
+	s.deliver(msg)
+	if s.handler == nil {
+		return
+	}
+	msg.dropped = s.onDropped
+	if err := s.handler.Publish(msg); err != nil {
+		s.logger.Error(err, "Publish message failed", "op", msg.Op)
+		atomic.AddUint64(&s.pubErrCount, 1)
+		return
+	}
+	atomic.AddUint64(&s.pubCount, 1)
+}
@@ -0,0 +1,146 @@
+package msg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/topic"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+var redisLogger = ctrl.Log.WithName("redis")
+
+// RedisStreamMsgHandler appends the messages to a stream, optionally keeping the latest payload of
+// each resource in a hash updated in the same transaction.
+type RedisStreamMsgHandler struct {
+	client     *redis.Client
+	stream     *template.Template
+	maxLen     int64
+	latestHash *template.Template
+	envelope   *Envelope
+}
+
+// NewRedisStreamMsgHandler reads the password from a Secret in namespace.
+func NewRedisStreamMsgHandler(spec *monitorv1alpha1.RedisStreamBackendSpec, reader client.Reader, namespace string) (*RedisStreamMsgHandler, error) {
+	stream, err := topic.Parse(spec.Stream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream: %v", err)
+	}
+	var latestHash *template.Template
+	if spec.LatestHash != "" {
+		if latestHash, err = topic.Parse(spec.LatestHash); err != nil {
+			return nil, fmt.Errorf("invalid latest hash: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	opts := &redis.Options{Addr: spec.Address, DB: spec.DB}
+	if spec.Password != nil {
+		password, err := utils.SecretValue(ctx, reader, namespace, spec.Password)
+		if err != nil {
+			return nil, err
+		}
+		opts.Password = string(password)
+	}
+	if spec.TLSConfig != nil {
+		if opts.TLSConfig, err = utils.TLSClientConfig(ctx, reader, namespace, spec.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+	return &RedisStreamMsgHandler{
+		client:     redis.NewClient(opts),
+		stream:     stream,
+		maxLen:     spec.MaxLen,
+		latestHash: latestHash,
+		envelope:   NewEnvelope(spec.Envelope, spec.Encoding, false),
+	}, nil
+}
+
+func (h *RedisStreamMsgHandler) Publish(msg *Message) error {
+	encoded, err := h.envelope.Encode(msg)
+	if err != nil {
+		redisLogger.Error(err, "Encode message failed")
+		return err
+	}
+	stream, err := renderTopic(h.stream, msg, redisKeyPart)
+	if err != nil {
+		redisLogger.Error(err, "Render stream key failed")
+		return err
+	}
+	args := &redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: h.maxLen,
+		Values:       streamValues(msg, encoded),
+	}
+	var hash string
+	if h.latestHash != nil && isResourceOp(msg.Op) && msg.Meta != nil {
+		if hash, err = renderTopic(h.latestHash, msg, redisKeyPart); err != nil {
+			redisLogger.Error(err, "Render latest hash key failed")
+			return err
+		}
+	}
+
+	_, err = h.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.XAdd(args)
+		if hash == "" {
+			return nil
+		}
+		field := resourceKey(msg.Meta.Namespace, msg.Meta.Name)
+		if msg.Op == DelResource {
+			pipe.HDel(hash, field)
+		} else {
+			pipe.HSet(hash, field, encoded.Body)
+		}
+		return nil
+	})
+	if err != nil {
+		redisLogger.Error(err, "Publish failed", "stream", stream)
+		return err
+	}
+	return nil
+}
+
+// streamValues are the fields of a stream entry, the empty ones are left out.
+func streamValues(msg *Message, encoded *Encoded) map[string]interface{} {
+	values := map[string]interface{}{
+		"op":           string(msg.Op),
+		"content_type": encoded.ContentType,
+		"payload":      encoded.Body,
+	}
+	if meta := msg.Meta; meta != nil {
+		values["schema_id"] = meta.SchemaID
+		values["namespace"] = meta.Namespace
+		values["name"] = meta.Name
+		values["uid"] = string(meta.UID)
+		values["seq"] = strconv.FormatUint(meta.Seq, 10)
+	}
+	if msg.Reason != "" {
+		values["reason"] = string(msg.Reason)
+	}
+	for name, value := range values {
+		if value == "" {
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// redisKeyPart keeps the value, only an empty one becomes "_".
+func redisKeyPart(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return value
+}
+
+func (h *RedisStreamMsgHandler) Close() error {
+	return h.client.Close()
+}
@@ -0,0 +1,105 @@
+package msg
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+func newTestRedisHandler(t *testing.T, spec *monitorv1alpha1.RedisStreamBackendSpec) (*miniredis.Miniredis, *RedisStreamMsgHandler) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Address = server.Addr()
+	handler, err := NewRedisStreamMsgHandler(spec, nil, "default")
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return server, handler
+}
+
+func TestRedisStreamMsgHandler_Publish(t *testing.T) {
+	server, handler := newTestRedisHandler(t, &monitorv1alpha1.RedisStreamBackendSpec{
+		Stream:     "k8s:{{.Kind}}",
+		LatestHash: "k8s:{{.Kind}}:latest",
+	})
+	defer server.Close()
+	defer handler.Close()
+
+	msg := newTestMessage("web")
+	msg.Meta.Seq = 3
+	if err := handler.Publish(msg); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := server.Stream("k8s:Pod")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("entries = %v, err = %v", entries, err)
+	}
+	values := entryValues(entries[0])
+	if values["op"] != "New" || values["schema_id"] != "v1/Pod" || values["namespace"] != "default" ||
+		values["name"] != "web" || values["seq"] != "3" {
+		t.Errorf("values = %v", values)
+	}
+	if _, ok := values["uid"]; ok {
+		t.Errorf("empty uid is kept: %v", values)
+	}
+	if latest := server.HGet("k8s:Pod:latest", "default/web"); latest != values["payload"] {
+		t.Errorf("latest = %s, want %s", latest, values["payload"])
+	}
+
+	msg = newTestMessage("web")
+	msg.Op = DelResource
+	if err := handler.Publish(msg); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists("k8s:Pod:latest") {
+		t.Errorf("latest state kept after Delete")
+	}
+	if entries, _ := server.Stream("k8s:Pod"); len(entries) != 2 {
+		t.Errorf("entries = %d, want 2", len(entries))
+	}
+}
+
+func TestRedisStreamMsgHandler_MaxLen(t *testing.T) {
+	server, handler := newTestRedisHandler(t, &monitorv1alpha1.RedisStreamBackendSpec{
+		Stream: "k8s",
+		MaxLen: 2,
+	})
+	defer server.Close()
+	defer handler.Close()
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := handler.Publish(newTestMessage(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := server.Stream("k8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// miniredis trims exactly, Redis keeps whole nodes with MAXLEN ~
+	if len(entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(entries))
+	}
+	if name := entryValues(entries[1])["name"]; name != "d" {
+		t.Errorf("last entry = %s", name)
+	}
+}
+
+func entryValues(entry miniredis.StreamEntry) map[string]string {
+	values := map[string]string{}
+	for i := 0; i+1 < len(entry.Values); i += 2 {
+		values[entry.Values[i]] = entry.Values[i+1]
+	}
+	return values
+}
+
+func TestRedisKeyPart(t *testing.T) {
+	if redisKeyPart("") != "_" || redisKeyPart("a:b") != "a:b" {
+		t.Errorf("redisKeyPart = %q, %q", redisKeyPart(""), redisKeyPart("a:b"))
+	}
+}
@@ -0,0 +1,517 @@
+package msg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+const (
+	sparkplugNamespace = "spBv1.0"
+	sparkplugRebirth   = "Node Control/Rebirth"
+	sparkplugBdSeq     = "bdSeq"
+)
+
+// Sparkplug B message types
+const (
+	sparkplugNBirth = "NBIRTH"
+	sparkplugNDeath = "NDEATH"
+	sparkplugNCmd   = "NCMD"
+	sparkplugDBirth = "DBIRTH"
+	sparkplugDData  = "DDATA"
+	sparkplugDDeath = "DDEATH"
+)
+
+// Sparkplug B data types
+const (
+	sparkplugInt64   uint32 = 4
+	sparkplugUInt64  uint32 = 8
+	sparkplugDouble  uint32 = 10
+	sparkplugBoolean uint32 = 11
+	sparkplugString  uint32 = 12
+)
+
+// sparkplugMetric is one metric of a Sparkplug B payload, Value is an int64, uint64, float64, bool
+// or string matching the Datatype.
+type sparkplugMetric struct {
+	Name     string
+	Datatype uint32
+	IsNull   bool
+	Value    interface{}
+}
+
+type sparkplugPayload struct {
+	Timestamp uint64
+	Metrics   []sparkplugMetric
+	// Seq is nil for NDEATH
+	Seq *uint64
+}
+
+// marshal encodes the payload as the Sparkplug B Payload protobuf message, each metric carries
+// the timestamp of the payload.
+func (p *sparkplugPayload) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, p.Timestamp)
+	for _, metric := range p.Metrics {
+		var m []byte
+		m = protowire.AppendTag(m, 1, protowire.BytesType)
+		m = protowire.AppendString(m, metric.Name)
+		m = protowire.AppendTag(m, 3, protowire.VarintType)
+		m = protowire.AppendVarint(m, p.Timestamp)
+		m = protowire.AppendTag(m, 4, protowire.VarintType)
+		m = protowire.AppendVarint(m, uint64(metric.Datatype))
+		if metric.IsNull {
+			m = protowire.AppendTag(m, 7, protowire.VarintType)
+			m = protowire.AppendVarint(m, 1)
+		} else {
+			switch v := metric.Value.(type) {
+			case int64:
+				m = protowire.AppendTag(m, 11, protowire.VarintType)
+				m = protowire.AppendVarint(m, uint64(v))
+			case uint64:
+				m = protowire.AppendTag(m, 11, protowire.VarintType)
+				m = protowire.AppendVarint(m, v)
+			case float64:
+				m = protowire.AppendTag(m, 13, protowire.Fixed64Type)
+				m = protowire.AppendFixed64(m, math.Float64bits(v))
+			case bool:
+				m = protowire.AppendTag(m, 14, protowire.VarintType)
+				m = protowire.AppendVarint(m, protowire.EncodeBool(v))
+			case string:
+				m = protowire.AppendTag(m, 15, protowire.BytesType)
+				m = protowire.AppendString(m, v)
+			}
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+	if p.Seq != nil {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, *p.Seq)
+	}
+	return b
+}
+
+// unmarshalSparkplugPayload decodes the fields of a Sparkplug B payload the gateway uses, e.g. of
+// an NCMD message.
+func unmarshalSparkplugPayload(data []byte) (*sparkplugPayload, error) {
+	payload := &sparkplugPayload{}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			payload.Timestamp = varint
+		case num == 2 && typ == protowire.BytesType:
+			metric, err := unmarshalSparkplugMetric(value)
+			if err != nil {
+				return err
+			}
+			payload.Metrics = append(payload.Metrics, *metric)
+		case num == 3 && typ == protowire.VarintType:
+			seq := varint
+			payload.Seq = &seq
+		}
+		return nil
+	})
+	return payload, err
+}
+
+func unmarshalSparkplugMetric(data []byte) (*sparkplugMetric, error) {
+	metric := &sparkplugMetric{}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch num {
+		case 1:
+			metric.Name = string(value)
+		case 4:
+			metric.Datatype = uint32(varint)
+		case 7:
+			metric.IsNull = protowire.DecodeBool(varint)
+		case 10, 11:
+			if metric.Datatype == sparkplugUInt64 {
+				metric.Value = varint
+			} else {
+				metric.Value = int64(varint)
+			}
+		case 13:
+			metric.Value = math.Float64frombits(varint)
+		case 14:
+			metric.Value = protowire.DecodeBool(varint)
+		case 15:
+			metric.Value = string(value)
+		}
+		return nil
+	})
+	return metric, err
+}
+
+// consumeFields calls fn for each field of a protobuf message, value is set for length-delimited
+// fields and varint for the other wire types.
+func consumeFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			varint, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			varint = uint64(v)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := fn(num, typ, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sparkplugNode publishes the resource messages as a Sparkplug B edge node, each resource is a
+// device born with its New message. Alert, Transition and RegisterSchema messages have no
+// Sparkplug counterpart and are dropped, the fields and metrics they derive from go out as DDATA.
+type sparkplugNode struct {
+	groupID    string
+	edgeNodeID string
+	send       func(topic string, qos byte, payload []byte) error
+
+	mtx sync.Mutex
+	// bdSeq of the session, the NBIRTH and the will NDEATH of a connection carry the same one
+	bdSeq uint64
+	seq   uint64
+	// key: device ID
+	devices map[string]map[string]sparkplugMetric
+	// key: sha256 of the JSON Schema
+	schemas map[string]*sparkplugSchema
+}
+
+func newSparkplugNode(spec *monitorv1alpha1.SparkplugSpec) *sparkplugNode {
+	edgeNodeID := spec.EdgeNodeID
+	if edgeNodeID == "" {
+		edgeNodeID = GatewayID
+	}
+	return &sparkplugNode{
+		groupID:    sparkplugID(spec.GroupID),
+		edgeNodeID: sparkplugID(edgeNodeID),
+		// a restart still tells the NDEATH of the previous process apart
+		bdSeq:   uint64(time.Now().Unix() % 256),
+		devices: make(map[string]map[string]sparkplugMetric),
+		schemas: make(map[string]*sparkplugSchema),
+	}
+}
+
+func (n *sparkplugNode) topic(messageType, deviceID string) string {
+	topic := strings.Join([]string{sparkplugNamespace, n.groupID, messageType, n.edgeNodeID}, "/")
+	if deviceID != "" {
+		topic += "/" + deviceID
+	}
+	return topic
+}
+
+// death is the NDEATH payload registered as the last will.
+func (n *sparkplugNode) death() []byte {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	payload := &sparkplugPayload{
+		Timestamp: uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		Metrics:   []sparkplugMetric{{Name: sparkplugBdSeq, Datatype: sparkplugUInt64, Value: n.bdSeq}},
+	}
+	return payload.marshal()
+}
+
+// nextSession increments bdSeq before a reconnect and returns the NDEATH of the new session, so
+// the will of the new connection isn't mistaken for the one of the lost connection.
+func (n *sparkplugNode) nextSession() []byte {
+	n.mtx.Lock()
+	n.bdSeq = (n.bdSeq + 1) % 256
+	n.mtx.Unlock()
+	return n.death()
+}
+
+// birth publishes NBIRTH, which restarts the sequence numbers, and DBIRTH for every known device.
+// Called on each connect and on a rebirth request.
+func (n *sparkplugNode) birth() error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.seq = 0
+	err := n.publishPayload(sparkplugNBirth, "", []sparkplugMetric{
+		{Name: sparkplugBdSeq, Datatype: sparkplugUInt64, Value: n.bdSeq},
+		{Name: sparkplugRebirth, Datatype: sparkplugBoolean, Value: false},
+	})
+	if err != nil {
+		return err
+	}
+	deviceIDs := make([]string, 0, len(n.devices))
+	for deviceID := range n.devices {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+	for _, deviceID := range deviceIDs {
+		if err := n.publishPayload(sparkplugDBirth, deviceID, sortedMetrics(n.devices[deviceID])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command handles an NCMD payload, a rebirth request publishes the births again.
+func (n *sparkplugNode) command(data []byte) error {
+	payload, err := unmarshalSparkplugPayload(data)
+	if err != nil {
+		return err
+	}
+	for _, metric := range payload.Metrics {
+		if metric.Name == sparkplugRebirth && metric.Value == true {
+			return n.birth()
+		}
+	}
+	return nil
+}
+
+func (n *sparkplugNode) Publish(msg *Message) error {
+	if !isResourceOp(msg.Op) || msg.Meta == nil {
+		return nil
+	}
+	deviceID := sparkplugDeviceID(msg.Meta)
+	if msg.Op == DelResource {
+		n.mtx.Lock()
+		defer n.mtx.Unlock()
+		if _, exists := n.devices[deviceID]; !exists {
+			return nil
+		}
+		delete(n.devices, deviceID)
+		return n.publishPayload(sparkplugDDeath, deviceID, nil)
+	}
+
+	metrics, err := n.metrics(msg)
+	if err != nil {
+		return err
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	born, exists := n.devices[deviceID]
+	if msg.Op == NewResource || !exists || !coversMetrics(born, metrics) {
+		// a device can't report metrics missing in its birth, it's born again instead
+		n.devices[deviceID] = metrics
+		return n.publishPayload(sparkplugDBirth, deviceID, sortedMetrics(metrics))
+	}
+	var changed []sparkplugMetric
+	for name, metric := range metrics {
+		if born[name] != metric {
+			changed = append(changed, metric)
+		}
+	}
+	for name, metric := range born {
+		if _, exists := metrics[name]; !exists {
+			// removed fields are reported as null and stay part of the birth
+			metric = sparkplugMetric{Name: name, Datatype: metric.Datatype, IsNull: true}
+			metrics[name] = metric
+			if !born[name].IsNull {
+				changed = append(changed, metric)
+			}
+		}
+	}
+	n.devices[deviceID] = metrics
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Name < changed[j].Name })
+	return n.publishPayload(sparkplugDData, deviceID, changed)
+}
+
+// publishPayload sends a message with the next sequence number, n.mtx must be held by the caller.
+func (n *sparkplugNode) publishPayload(messageType, deviceID string, metrics []sparkplugMetric) error {
+	seq := n.seq
+	n.seq = (n.seq + 1) % 256
+	payload := &sparkplugPayload{
+		Timestamp: uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		Metrics:   metrics,
+		Seq:       &seq,
+	}
+	return n.send(n.topic(messageType, deviceID), 0, payload.marshal())
+}
+
+// metrics flattens the payload of msg into metrics named by the path of the fields, e.g.
+// "status/phase" or "extras/cpu". The registered schema tells integers from doubles and gives
+// the type of null fields.
+func (n *sparkplugNode) metrics(msg *Message) (map[string]sparkplugMetric, error) {
+	metrics := make(map[string]sparkplugMetric)
+	if !json.Valid(msg.Data) {
+		// e.g. rendered by a template
+		metrics["payload"] = sparkplugMetric{Name: "payload", Datatype: sparkplugString, Value: string(msg.Data)}
+		return metrics, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(msg.Data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	schema := n.schema(msg.schema)
+	schema.flatten(metrics, "", value, schema.root)
+	return metrics, nil
+}
+
+func (n *sparkplugNode) schema(schemaData []byte) *sparkplugSchema {
+	if schemaData == nil {
+		return &sparkplugSchema{}
+	}
+	sum := sha256.Sum256(schemaData)
+	key := string(sum[:])
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if schema, exists := n.schemas[key]; exists {
+		return schema
+	}
+	schema := &sparkplugSchema{}
+	if err := json.Unmarshal(schemaData, &schema.root); err == nil {
+		schema.definitions, _ = schema.root["definitions"].(map[string]interface{})
+	}
+	n.schemas[key] = schema
+	return schema
+}
+
+// sparkplugSchema looks up the JSON Schema type of the flattened fields.
+type sparkplugSchema struct {
+	root        map[string]interface{}
+	definitions map[string]interface{}
+}
+
+func (s *sparkplugSchema) resolve(schema map[string]interface{}) map[string]interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		schema, _ = s.definitions[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+	}
+	return schema
+}
+
+// child returns the schema of a property or an array item, nil if unknown.
+func (s *sparkplugSchema) child(schema map[string]interface{}, key string) map[string]interface{} {
+	schema = s.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+	if schema["type"] == "array" {
+		items, _ := schema["items"].(map[string]interface{})
+		return items
+	}
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		if property, ok := properties[key].(map[string]interface{}); ok {
+			return property
+		}
+	}
+	additional, _ := schema["additionalProperties"].(map[string]interface{})
+	return additional
+}
+
+func (s *sparkplugSchema) flatten(metrics map[string]sparkplugMetric, name string, value interface{}, schema map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			s.flatten(metrics, joinMetricName(name, key), child, s.child(schema, key))
+		}
+		return
+	case []interface{}:
+		for i, item := range v {
+			s.flatten(metrics, joinMetricName(name, strconv.Itoa(i)), item, s.child(schema, ""))
+		}
+		return
+	}
+	if name == "" {
+		name = "value"
+	}
+	metric := sparkplugMetric{Name: name}
+	schemaType, _ := s.resolve(schema)["type"].(string)
+	switch v := value.(type) {
+	case nil:
+		switch schemaType {
+		case "string":
+			metric.Datatype = sparkplugString
+		case "integer":
+			metric.Datatype = sparkplugInt64
+		case "number":
+			metric.Datatype = sparkplugDouble
+		case "boolean":
+			metric.Datatype = sparkplugBoolean
+		default:
+			// no type to declare in the birth
+			return
+		}
+		metric.IsNull = true
+	case json.Number:
+		if i, err := v.Int64(); err == nil && schemaType == "integer" {
+			metric.Datatype, metric.Value = sparkplugInt64, i
+		} else {
+			f, _ := v.Float64()
+			metric.Datatype, metric.Value = sparkplugDouble, f
+		}
+	case bool:
+		metric.Datatype, metric.Value = sparkplugBoolean, v
+	case string:
+		metric.Datatype, metric.Value = sparkplugString, v
+	}
+	metrics[name] = metric
+}
+
+func joinMetricName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// coversMetrics reports whether every metric was declared with the same type in the birth.
+func coversMetrics(born, metrics map[string]sparkplugMetric) bool {
+	for name, metric := range metrics {
+		if declared, exists := born[name]; !exists || declared.Datatype != metric.Datatype {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedMetrics(metrics map[string]sparkplugMetric) []sparkplugMetric {
+	sorted := make([]sparkplugMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		sorted = append(sorted, metric)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// sparkplugDeviceID is <Kind>:<namespace>:<name>, or <Kind>:<name> for cluster scoped resources.
+func sparkplugDeviceID(meta *ResourceMeta) string {
+	kind := meta.SchemaID[strings.LastIndex(meta.SchemaID, "/")+1:]
+	parts := []string{kind, meta.Namespace, meta.Name}
+	if meta.Namespace == "" {
+		parts = []string{kind, meta.Name}
+	}
+	return sparkplugID(strings.Join(parts, ":"))
+}
+
+// sparkplugID replaces the characters reserved in the topic.
+func sparkplugID(id string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(id)
+}
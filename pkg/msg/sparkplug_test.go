@@ -0,0 +1,178 @@
+package msg
+
+import (
+	"testing"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+type sparkplugRecord struct {
+	topic   string
+	payload *sparkplugPayload
+}
+
+func newTestSparkplugNode(t *testing.T) (*sparkplugNode, *[]sparkplugRecord) {
+	node := newSparkplugNode(&monitorv1alpha1.SparkplugSpec{GroupID: "factory", EdgeNodeID: "gw/1"})
+	var records []sparkplugRecord
+	node.send = func(topic string, qos byte, data []byte) error {
+		payload, err := unmarshalSparkplugPayload(data)
+		if err != nil {
+			t.Fatalf("decode payload of %s: %v", topic, err)
+		}
+		records = append(records, sparkplugRecord{topic: topic, payload: payload})
+		return nil
+	}
+	return node, &records
+}
+
+func metricsByName(payload *sparkplugPayload) map[string]sparkplugMetric {
+	metrics := make(map[string]sparkplugMetric)
+	for _, metric := range payload.Metrics {
+		metrics[metric.Name] = metric
+	}
+	return metrics
+}
+
+func TestSparkplugPayload_Marshal(t *testing.T) {
+	seq := uint64(7)
+	payload := &sparkplugPayload{
+		Timestamp: 1600000000000,
+		Seq:       &seq,
+		Metrics: []sparkplugMetric{
+			{Name: "a", Datatype: sparkplugInt64, Value: int64(-3)},
+			{Name: "b", Datatype: sparkplugDouble, Value: 1.5},
+			{Name: "c", Datatype: sparkplugBoolean, Value: true},
+			{Name: "d", Datatype: sparkplugString, Value: "x"},
+			{Name: "e", Datatype: sparkplugString, IsNull: true},
+			{Name: "f", Datatype: sparkplugUInt64, Value: uint64(200)},
+		},
+	}
+	decoded, err := unmarshalSparkplugPayload(payload.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Timestamp != payload.Timestamp || decoded.Seq == nil || *decoded.Seq != seq {
+		t.Errorf("header = %d/%v", decoded.Timestamp, decoded.Seq)
+	}
+	if len(decoded.Metrics) != len(payload.Metrics) {
+		t.Fatalf("metrics = %v", decoded.Metrics)
+	}
+	for i, metric := range payload.Metrics {
+		if decoded.Metrics[i] != metric {
+			t.Errorf("metric %d = %+v, want %+v", i, decoded.Metrics[i], metric)
+		}
+	}
+}
+
+func TestSparkplugNode_Publish(t *testing.T) {
+	node, records := newTestSparkplugNode(t)
+	if err := node.birth(); err != nil {
+		t.Fatal(err)
+	}
+	schema := []byte(`{"$ref":"#/definitions/Pod","definitions":{"Pod":{"type":"object","properties":{
+		"status":{"$ref":"#/definitions/Status"}}},
+		"Status":{"type":"object","properties":{"phase":{"type":"string"},"restarts":{"type":"integer"},"reason":{"type":"string"}}}}}`)
+	meta := &ResourceMeta{SchemaID: "v1/Pod", Namespace: "default", Name: "web"}
+	publish := func(op ResourceOp, data string) {
+		if err := node.Publish(&Message{Op: op, Meta: meta, Data: []byte(data), schema: schema}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(NewResource, `{"status":{"phase":"Pending","restarts":0,"reason":null},"extras":{"cpu":2}}`)
+	publish(UpdateResource, `{"status":{"phase":"Running","restarts":0,"reason":null},"extras":{"cpu":2}}`)
+	publish(UpdateResource, `{"status":{"phase":"Running","restarts":0},"extras":{"cpu":2}}`)
+	publish(UpdateResource, `{"status":{"phase":"Running","restarts":0,"ready":true},"extras":{"cpu":2}}`)
+	publish(DelResource, `{}`)
+	publish(AlertResource, `{}`)
+
+	want := []string{
+		"spBv1.0/factory/NBIRTH/gw_1",
+		"spBv1.0/factory/DBIRTH/gw_1/Pod:default:web",
+		"spBv1.0/factory/DDATA/gw_1/Pod:default:web",
+		"spBv1.0/factory/DBIRTH/gw_1/Pod:default:web",
+		"spBv1.0/factory/DDEATH/gw_1/Pod:default:web",
+	}
+	if len(*records) != len(want) {
+		t.Fatalf("records = %v", *records)
+	}
+	for i, record := range *records {
+		if record.topic != want[i] {
+			t.Errorf("topic %d = %s, want %s", i, record.topic, want[i])
+		}
+		if *record.payload.Seq != uint64(i) {
+			t.Errorf("seq %d = %d", i, *record.payload.Seq)
+		}
+	}
+
+	birth := metricsByName((*records)[1].payload)
+	if m := birth["status/restarts"]; m.Datatype != sparkplugInt64 || m.Value != int64(0) {
+		t.Errorf("restarts = %+v", m)
+	}
+	if m := birth["status/reason"]; m.Datatype != sparkplugString || !m.IsNull {
+		t.Errorf("reason = %+v", m)
+	}
+	if m := birth["extras/cpu"]; m.Datatype != sparkplugDouble || m.Value != 2.0 {
+		t.Errorf("cpu = %+v", m)
+	}
+	// only the changed phase, the removed reason was already null
+	data := (*records)[2].payload.Metrics
+	if len(data) != 1 || data[0].Name != "status/phase" || data[0].Value != "Running" {
+		t.Errorf("DDATA metrics = %+v", data)
+	}
+	rebirth := metricsByName((*records)[3].payload)
+	if m := rebirth["status/ready"]; m.Value != true {
+		t.Errorf("ready = %+v", m)
+	}
+}
+
+func TestSparkplugNode_Rebirth(t *testing.T) {
+	node, records := newTestSparkplugNode(t)
+	meta := &ResourceMeta{SchemaID: "v1/Node", Name: "n1"}
+	if err := node.Publish(&Message{Op: NewResource, Meta: meta, Data: []byte(`{"ready":true}`)}); err != nil {
+		t.Fatal(err)
+	}
+	command := &sparkplugPayload{Metrics: []sparkplugMetric{{Name: sparkplugRebirth, Datatype: sparkplugBoolean, Value: true}}}
+	if err := node.command(command.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*records) != 3 {
+		t.Fatalf("records = %v", *records)
+	}
+	nbirth, dbirth := (*records)[1], (*records)[2]
+	if nbirth.topic != "spBv1.0/factory/NBIRTH/gw_1" || *nbirth.payload.Seq != 0 {
+		t.Errorf("NBIRTH = %s %d", nbirth.topic, *nbirth.payload.Seq)
+	}
+	if m := metricsByName(nbirth.payload)[sparkplugBdSeq]; m.Value != node.bdSeq {
+		t.Errorf("bdSeq = %+v", m)
+	}
+	if dbirth.topic != "spBv1.0/factory/DBIRTH/gw_1/Node:n1" || *dbirth.payload.Seq != 1 {
+		t.Errorf("DBIRTH = %s %d", dbirth.topic, *dbirth.payload.Seq)
+	}
+}
+
+func TestSparkplugNode_NextSession(t *testing.T) {
+	node, records := newTestSparkplugNode(t)
+	node.bdSeq = 255
+	death, err := unmarshalSparkplugPayload(node.nextSession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bdSeq wraps around like seq
+	if m := metricsByName(death)[sparkplugBdSeq]; m.Value != uint64(0) {
+		t.Errorf("NDEATH bdSeq = %+v", m)
+	}
+	if err := node.birth(); err != nil {
+		t.Fatal(err)
+	}
+	if m := metricsByName((*records)[0].payload)[sparkplugBdSeq]; m.Value != uint64(0) {
+		t.Errorf("NBIRTH bdSeq = %+v", m)
+	}
+	// a rebirth keeps the session
+	command := &sparkplugPayload{Metrics: []sparkplugMetric{{Name: sparkplugRebirth, Datatype: sparkplugBoolean, Value: true}}}
+	if err := node.command(command.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if m := metricsByName((*records)[1].payload)[sparkplugBdSeq]; m.Value != uint64(0) {
+		t.Errorf("rebirth bdSeq = %+v", m)
+	}
+}
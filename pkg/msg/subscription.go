@@ -0,0 +1,225 @@
+package msg
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLagged closes a subscription which did not keep up with the published messages, the
+// subscriber has to subscribe again and gets a fresh snapshot.
+var ErrLagged = errors.New("subscriber lagged behind")
+
+// ErrResumeExpired is returned when the messages following a position are no longer held by the
+// replay buffer, or the position is from another run of the monitor.
+var ErrResumeExpired = errors.New("resume position expired")
+
+// replayBufferSize is the number of messages kept for the subscribers resuming their stream.
+const replayBufferSize = 1024
+
+// ErrStoreClosed closes the subscriptions of a store whose monitor was stopped or restarted.
+var ErrStoreClosed = errors.New("monitor stopped")
+
+var (
+	// key: namespace/name of the monitor
+	storeRegistry = make(map[string]*MessageStore)
+	storeMtx      sync.Mutex
+)
+
+// LookupStore returns the store of the running monitor namespace/name, nil if there is none.
+func LookupStore(namespace, name string) *MessageStore {
+	storeMtx.Lock()
+	defer storeMtx.Unlock()
+	return storeRegistry[resourceKey(namespace, name)]
+}
+
+func registerStore(s *MessageStore) {
+	storeMtx.Lock()
+	defer storeMtx.Unlock()
+	storeRegistry[resourceKey(s.monitorNamespace, s.monitorName)] = s
+}
+
+// unregisterStore keeps the store of a renewed job registered.
+func unregisterStore(s *MessageStore) {
+	storeMtx.Lock()
+	defer storeMtx.Unlock()
+	key := resourceKey(s.monitorNamespace, s.monitorName)
+	if storeRegistry[key] == s {
+		delete(storeRegistry, key)
+	}
+}
+
+// SubscriptionFilter selects the messages of a subscription, empty fields match everything.
+type SubscriptionFilter struct {
+	Namespace string
+	Name      string
+	Ops       []ResourceOp
+}
+
+func (f *SubscriptionFilter) match(msg *Message) bool {
+	if msg.Meta == nil {
+		return false
+	}
+	if f.Namespace != "" && msg.Meta.Namespace != f.Namespace || f.Name != "" && msg.Meta.Name != f.Name {
+		return false
+	}
+	if len(f.Ops) == 0 {
+		return true
+	}
+	for _, op := range f.Ops {
+		if msg.Op == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the messages published by a store, C is closed once Err is set.
+type Subscription struct {
+	C <-chan *Message
+	// Position follows the snapshot or the replayed messages, the messages on C come next
+	Position Position
+
+	ch     chan *Message
+	filter SubscriptionFilter
+	err    error
+}
+
+// Err tells why C was closed.
+func (sub *Subscription) Err() error {
+	return sub.err
+}
+
+// Position of a message in the stream of a store, the epoch identifies the store so a position
+// is worthless once the monitor is restarted.
+type Position struct {
+	Epoch  string
+	Offset uint64
+}
+
+// Offset of the message in the stream of the store which published it.
+func (m *Message) Offset() uint64 {
+	return m.offset
+}
+
+// Snapshot returns the current state of the selected resources as New messages, and the position
+// to resume from for the messages following that state.
+func (s *MessageStore) Snapshot(filter SubscriptionFilter) ([]*Message, Position, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return nil, Position{}, ErrStoreClosed
+	}
+	s.replaying = true
+	return s.snapshot(filter), Position{Epoch: s.epoch, Offset: s.offset}, nil
+}
+
+// Subscribe returns the current state of the selected resources as New messages, followed on
+// C by the messages published afterwards. A subscriber more than buffer messages behind is
+// dropped with ErrLagged.
+func (s *MessageStore) Subscribe(filter SubscriptionFilter, buffer int) ([]*Message, *Subscription, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return nil, nil, ErrStoreClosed
+	}
+	return s.snapshot(filter), s.subscribe(filter, buffer), nil
+}
+
+// Resume returns the selected messages published after from, followed on C by the next ones.
+// ErrResumeExpired is returned if they aren't all held by the replay buffer anymore.
+func (s *MessageStore) Resume(filter SubscriptionFilter, buffer int, from Position) ([]*Message, *Subscription, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return nil, nil, ErrStoreClosed
+	}
+	if from.Epoch != s.epoch || from.Offset > s.offset {
+		return nil, nil, ErrResumeExpired
+	}
+	if len(s.replay) == 0 && from.Offset != s.offset || len(s.replay) > 0 && from.Offset+1 < s.replay[0].offset {
+		return nil, nil, ErrResumeExpired
+	}
+	var replayed []*Message
+	for _, msg := range s.replay {
+		if msg.offset > from.Offset && filter.match(msg) {
+			replayed = append(replayed, msg)
+		}
+	}
+	return replayed, s.subscribe(filter, buffer), nil
+}
+
+// snapshot, s.mtx must be held by the caller.
+func (s *MessageStore) snapshot(filter SubscriptionFilter) []*Message {
+	var snapshot []*Message
+	for _, msgCache := range s.cache {
+		msg, err := s.marshalResource(&Message{
+			Op:   NewResource,
+			Meta: msgCache.Message.Meta.stamped(msgCache.seq),
+			Data: msgCache.Message.Data,
+		}, msgCache.Metrics)
+		if err != nil {
+			s.logger.Error(err, "Serialize Message failed")
+			continue
+		}
+		if filter.match(msg) {
+			msg.offset = s.offset
+			snapshot = append(snapshot, msg)
+		}
+	}
+	return snapshot
+}
+
+// subscribe, s.mtx must be held by the caller. The replay buffer is filled from the first
+// subscription or snapshot on.
+func (s *MessageStore) subscribe(filter SubscriptionFilter, buffer int) *Subscription {
+	ch := make(chan *Message, buffer)
+	sub := &Subscription{
+		C:        ch,
+		Position: Position{Epoch: s.epoch, Offset: s.offset},
+		ch:       ch,
+		filter:   filter,
+	}
+	if s.subscribers == nil {
+		s.subscribers = make(map[*Subscription]struct{})
+	}
+	s.subscribers[sub] = struct{}{}
+	s.replaying = true
+	return sub
+}
+
+// Unsubscribe stops the delivery to sub, C is left open.
+func (s *MessageStore) Unsubscribe(sub *Subscription) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.subscribers, sub)
+}
+
+// deliver passes msg to the subscribers and the replay buffer, s.mtx must be held by the caller.
+func (s *MessageStore) deliver(msg *Message) {
+	s.offset++
+	msg.offset = s.offset
+	if s.replaying {
+		if len(s.replay) == replayBufferSize {
+			copy(s.replay, s.replay[1:])
+			s.replay = s.replay[:replayBufferSize-1]
+		}
+		s.replay = append(s.replay, msg)
+	}
+	for sub := range s.subscribers {
+		if !sub.filter.match(msg) {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+		default:
+			s.dropSubscriber(sub, ErrLagged)
+		}
+	}
+}
+
+// dropSubscriber closes C of sub, s.mtx must be held by the caller.
+func (s *MessageStore) dropSubscriber(sub *Subscription, err error) {
+	delete(s.subscribers, sub)
+	sub.err = err
+	close(sub.ch)
+}
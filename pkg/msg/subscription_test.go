@@ -0,0 +1,110 @@
+package msg
+
+import (
+	"testing"
+)
+
+func TestMessageStore_Subscribe(t *testing.T) {
+	store := newTestStore(nil)
+	running := newTestObject("Running")
+	store.OnResourceAdd(running, running)
+
+	snapshot, sub, err := store.Subscribe(SubscriptionFilter{Ops: []ResourceOp{NewResource, UpdateResource}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot) != 1 || snapshot[0].Op != NewResource || snapshot[0].Meta.Name != "test" || snapshot[0].Meta.Seq != 1 {
+		t.Fatalf("snapshot = %v", snapshot)
+	}
+
+	succeeded := newTestObject("Succeeded")
+	store.OnResourceUpdate(succeeded, succeeded)
+	msg := <-sub.C
+	if msg.Op != UpdateResource || msg.Meta.Seq != 2 {
+		t.Errorf("message = %s %d", msg.Op, msg.Meta.Seq)
+	}
+	// filtered out
+	store.OnResourceDel(succeeded, succeeded, false)
+	select {
+	case msg := <-sub.C:
+		t.Errorf("unexpected %s", msg.Op)
+	default:
+	}
+
+	store.Close()
+	if _, ok := <-sub.C; ok || sub.Err() != ErrStoreClosed {
+		t.Errorf("subscription not closed: %v", sub.Err())
+	}
+	if _, _, err := store.Subscribe(SubscriptionFilter{}, 1); err != ErrStoreClosed {
+		t.Errorf("Subscribe after Close: %v", err)
+	}
+}
+
+func TestMessageStore_SubscribeLagged(t *testing.T) {
+	store := newTestStore(nil)
+	_, sub, err := store.Subscribe(SubscriptionFilter{Name: "test"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, phase := range []string{"Pending", "Running", "Succeeded"} {
+		obj := newTestObject(phase)
+		store.OnResourceUpdate(obj, obj)
+	}
+	<-sub.C
+	if _, ok := <-sub.C; ok || sub.Err() != ErrLagged {
+		t.Errorf("lagged subscription not closed: %v", sub.Err())
+	}
+}
+
+func TestLookupStore(t *testing.T) {
+	store := newTestStore(nil)
+	store.monitorNamespace, store.monitorName = "default", "pods"
+	registerStore(store)
+	if LookupStore("default", "pods") != store {
+		t.Fatalf("store not registered")
+	}
+	renewed := newTestStore(nil)
+	renewed.monitorNamespace, renewed.monitorName = "default", "pods"
+	registerStore(renewed)
+	store.Close()
+	if LookupStore("default", "pods") != renewed {
+		t.Errorf("renewed store unregistered by the old one")
+	}
+	renewed.Close()
+	if LookupStore("default", "pods") != nil {
+		t.Errorf("closed store still registered")
+	}
+}
+
+func TestMessageStore_Resume(t *testing.T) {
+	store := newTestStore(nil)
+	_, sub, err := store.Subscribe(SubscriptionFilter{}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, phase := range []string{"Pending", "Running", "Succeeded"} {
+		obj := newTestObject(phase)
+		store.OnResourceUpdate(obj, obj)
+	}
+	first := <-sub.C
+	store.Unsubscribe(sub)
+
+	replayed, resumed, err := store.Resume(SubscriptionFilter{}, 8, Position{Offset: first.Offset()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 2 || replayed[0].Offset() != first.Offset()+1 || resumed.Position.Offset != replayed[1].Offset() {
+		t.Errorf("replayed = %d, position = %v", len(replayed), resumed.Position)
+	}
+	if _, _, err := store.Resume(SubscriptionFilter{}, 8, Position{Epoch: "other", Offset: first.Offset()}); err != ErrResumeExpired {
+		t.Errorf("Resume of another epoch: %v", err)
+	}
+
+	for i := 0; i < replayBufferSize; i++ {
+		obj := newTestObject(string(rune('a' + i%26)))
+		store.OnResourceUpdate(obj, obj)
+	}
+	if _, _, err := store.Resume(SubscriptionFilter{}, 8, Position{Offset: first.Offset()}); err != ErrResumeExpired {
+		t.Errorf("Resume out of the replay buffer: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package msg
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// topicData is the input of the templates building subjects and routing keys.
+type topicData struct {
+	Op               string
+	Kind             string
+	Namespace        string
+	Name             string
+	MonitorNamespace string
+	MonitorName      string
+	GatewayID        string
+}
+
+// renderTopic executes tmpl with the values of msg made valid tokens by token.
+func renderTopic(tmpl *template.Template, msg *Message, token func(string) string) (string, error) {
+	data := &topicData{Op: string(msg.Op), GatewayID: GatewayID}
+	if meta := msg.Meta; meta != nil {
+		data.Kind = schemaKind(meta.SchemaID)
+		data.Namespace, data.Name = meta.Namespace, meta.Name
+		data.MonitorNamespace, data.MonitorName = meta.MonitorNamespace, meta.MonitorName
+	}
+	for _, value := range []*string{&data.Op, &data.Kind, &data.Namespace, &data.Name, &data.MonitorNamespace, &data.MonitorName, &data.GatewayID} {
+		*value = token(*value)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
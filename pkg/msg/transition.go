@@ -0,0 +1,134 @@
+package msg
+
+import (
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+// Transition is the data of a Transition message
+type Transition struct {
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Duration is the seconds spent in the previous state
+	Duration float64 `json:"duration"`
+	// ResourceVersion of the object which triggered the transition
+	ResourceVersion string `json:"resource_version"`
+}
+
+// TransitionState is the last observed value of a watched field.
+type TransitionState struct {
+	Value string    `json:"value"`
+	Since time.Time `json:"since"`
+}
+
+type transitionRule struct {
+	name          string
+	path          string
+	conditionType string
+}
+
+func newTransitionRules(specs []monitorv1alpha1.TransitionSpec) []transitionRule {
+	rules := make([]transitionRule, 0, len(specs))
+	for _, spec := range specs {
+		rule := transitionRule{name: spec.Name, path: spec.Path, conditionType: spec.ConditionType}
+		if rule.name == "" {
+			rule.name = spec.ConditionType
+		}
+		if rule.name == "" {
+			rule.name = spec.Path
+		}
+		if rule.conditionType == "" && rule.path == "" {
+			msgLogger.Info("Transition without a path or a condition type is ignored", "name", spec.Name)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// observe returns the current value of the watched field, and when it changed if the object tells.
+func (r transitionRule) observe(u *unstructured.Unstructured) (string, time.Time, error) {
+	if r.conditionType == "" {
+		value, err := utils.JSONPathValue(u.Object, r.path)
+		return value, time.Time{}, err
+	}
+	conditions, _, err := unstructured.NestedSlice(u.Object, "status", "conditions")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	for _, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if !ok || condition["type"] != r.conditionType {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		lastTransition, _ := condition["lastTransitionTime"].(string)
+		since, _ := time.Parse(time.RFC3339, lastTransition)
+		return status, since, nil
+	}
+	return "", time.Time{}, nil
+}
+
+// observeTransitions records the watched fields of u and, when emit is set, publishes a Transition
+// for every field which changed. s.mtx must be held by the caller.
+func (s *MessageStore) observeTransitions(msgCache *MessageCache, u *unstructured.Unstructured, emit bool) {
+	now := time.Now()
+	for _, rule := range s.transitions {
+		value, since, err := rule.observe(u)
+		if err != nil {
+			s.logger.Error(err, "Read transition field failed", "transition", rule.name)
+			continue
+		}
+		if since.IsZero() || since.After(now) {
+			since = now
+		}
+		if msgCache.transitions == nil {
+			msgCache.transitions = make(map[string]*TransitionState)
+		}
+		state, exists := msgCache.transitions[rule.name]
+		if !exists {
+			msgCache.transitions[rule.name] = &TransitionState{Value: value, Since: since}
+			s.dirty = true
+			continue
+		}
+		if state.Value == value {
+			continue
+		}
+		if since.Before(state.Since) {
+			// the condition changed before the previous value was observed
+			since = now
+		}
+		transition := &Transition{
+			Name:            rule.name,
+			From:            state.Value,
+			To:              value,
+			Duration:        since.Sub(state.Since).Seconds(),
+			ResourceVersion: u.GetResourceVersion(),
+		}
+		state.Value, state.Since = value, since
+		s.dirty = true
+		if emit {
+			s.publishTransition(msgCache, transition)
+		}
+	}
+}
+
+func (s *MessageStore) publishTransition(msgCache *MessageCache, transition *Transition) {
+	transitionData, err := json.Marshal(transition)
+	if err != nil {
+		s.logger.Error(err, "Serialize Transition failed")
+		return
+	}
+	msg := &Message{
+		Op:   TransitionResource,
+		Meta: msgCache.Message.Meta.stamped(msgCache.nextSeq()),
+		Data: transitionData,
+	}
+	s.publish(msg)
+}
@@ -0,0 +1,61 @@
+package msg
+
+import (
+	"encoding/json"
+	"testing"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+func TestMessageStore_Transitions(t *testing.T) {
+	handler := &recordHandler{}
+	store := newTestStore(handler)
+	store.transitions = newTransitionRules([]monitorv1alpha1.TransitionSpec{
+		{Path: "status.phase"},
+		{ConditionType: "Ready"},
+	})
+	store.transitionsOnly = true
+
+	obj := newTestObject("Pending")
+	store.OnResourceAdd(obj, obj)
+	obj = newTestObject("Running")
+	obj.Object["status"].(map[string]interface{})["conditions"] = []interface{}{
+		map[string]interface{}{
+			"type":               "Ready",
+			"status":             "True",
+			"lastTransitionTime": "2021-06-01T00:00:00Z",
+		},
+	}
+	obj.SetResourceVersion("2")
+	store.OnResourceUpdate(obj, obj)
+
+	// RegisterSchema and New, then a Transition per watched field instead of the Update
+	if handler.count() != 4 {
+		t.Fatalf("expected 4 messages, got %d", handler.count())
+	}
+	transitions := make(map[string]*Transition)
+	for _, msgData := range handler.msgs[2:] {
+		msg := &struct {
+			Op   ResourceOp `json:"op"`
+			Data []byte     `json:"data"`
+		}{}
+		if err := json.Unmarshal(msgData, msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Op != TransitionResource {
+			t.Fatalf("expected a Transition, got %s", msg.Op)
+		}
+		transition := &Transition{}
+		if err := json.Unmarshal(msg.Data, transition); err != nil {
+			t.Fatal(err)
+		}
+		transitions[transition.Name] = transition
+	}
+	if phase := transitions["status.phase"]; phase == nil || phase.From != "Pending" || phase.To != "Running" ||
+		phase.ResourceVersion != "2" {
+		t.Errorf("unexpected phase transition %+v", phase)
+	}
+	if ready := transitions["Ready"]; ready == nil || ready.From != "" || ready.To != "True" || ready.Duration < 0 {
+		t.Errorf("unexpected Ready transition %+v", ready)
+	}
+}
@@ -2,21 +2,62 @@ package msg
 
 import (
 	"encoding/json"
-	"reflect"
+	"os"
+	"time"
 
 	"github.com/wI2L/jsondiff"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// GatewayID identifies the gateway instance in the meta of every message, the hostname by default.
+var GatewayID, _ = os.Hostname()
+
 type Message struct {
 	Op   ResourceOp    `json:"op"`
 	Meta *ResourceMeta `json:"meta,omitempty"`
 	Data []byte        `json:"data,omitempty"`
+	// Reason tells why a resource was deleted
+	Reason DeleteReason `json:"reason,omitempty"`
+	// ContentType and ContentEncoding are set when the data isn't plain JSON
+	ContentType     string `json:"content_type,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+
+	// schema is the registered JSON Schema, the Protobuf encoding is generated from it
+	schema []byte
+	// offset is the position of the message in the stream of the store, see Position
+	offset uint64
+	// dropped is called when a backend delivering asynchronously gives the message up
+	dropped func()
 }
 
 type ResourceMeta struct {
-	SchemaID  string `json:"schema_id"`
-	Namespace string `json:"namespace"`
-	Name      string `json:"name"`
+	SchemaID        string    `json:"schema_id"`
+	Namespace       string    `json:"namespace"`
+	Name            string    `json:"name"`
+	UID             types.UID `json:"uid,omitempty"`
+	ResourceVersion string    `json:"resource_version,omitempty"`
+	Generation      int64     `json:"generation,omitempty"`
+	// Timestamp is when the gateway emitted the message
+	Timestamp        time.Time `json:"timestamp"`
+	MonitorNamespace string    `json:"monitor_namespace"`
+	MonitorName      string    `json:"monitor_name"`
+	GatewayID        string    `json:"gateway_id,omitempty"`
+	// Seq increases by one with every message of the resource, a gap means a lost message
+	Seq uint64 `json:"seq"`
+}
+
+// stamped returns a copy of the meta for a message emitted now.
+func (m *ResourceMeta) stamped(seq uint64) *ResourceMeta {
+	meta := *m
+	meta.Timestamp = time.Now()
+	meta.Seq = seq
+	return &meta
+}
+
+// sameResource reports whether both metas identify the same object, a recreated object has a new UID.
+func (m *ResourceMeta) sameResource(other *ResourceMeta) bool {
+	return m.SchemaID == other.SchemaID && m.Namespace == other.Namespace &&
+		m.Name == other.Name && m.UID == other.UID
 }
 
 type ResourceOp string
@@ -26,9 +67,58 @@ const (
 	NewResource    ResourceOp = "New"
 	DelResource    ResourceOp = "Delete"
 	UpdateResource ResourceOp = "Update"
+	AlertResource  ResourceOp = "Alert"
+	// TransitionResource reports a change of a watched field
+	TransitionResource ResourceOp = "Transition"
 )
 
-func (m *Message) MarshalJSON(extras map[string]interface{}) ([]byte, error) {
+type DeleteReason string
+
+const (
+	ResourceDeleted DeleteReason = "Deleted"
+	// ResourceDeletedFinalStateUnknown is a delete missed by the informer, the data may be stale
+	ResourceDeletedFinalStateUnknown DeleteReason = "DeletedFinalStateUnknown"
+	// ResourceUnselected is a resource which no longer matches the selector
+	ResourceUnselected DeleteReason = "Unselected"
+)
+
+type AlertState string
+
+const (
+	AlertFiring   AlertState = "firing"
+	AlertResolved AlertState = "resolved"
+)
+
+// Alert is the data of an Alert message
+type Alert struct {
+	Rule      string     `json:"rule"`
+	Field     string     `json:"field"`
+	State     AlertState `json:"state"`
+	Severity  string     `json:"severity,omitempty"`
+	Operator  string     `json:"operator"`
+	Threshold float64    `json:"threshold"`
+	Value     float64    `json:"value"`
+	// Since is when the value crossed the bound
+	Since time.Time `json:"since"`
+}
+
+// withExtras returns a copy of the message with extras spliced into the data.
+func (m *Message) withExtras(extras map[string]interface{}) (*Message, error) {
+	newData, err := m.payload(extras)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Op:     m.Op,
+		Meta:   m.Meta,
+		Data:   newData,
+		Reason: m.Reason,
+	}, nil
+}
+
+// payload splices extras into a copy of the data.
+func (m *Message) payload(extras map[string]interface{}) ([]byte, error) {
 	newData := make([]byte, len(m.Data))
 	copy(newData, m.Data)
 	if extras != nil && len(extras) > 0 {
@@ -38,23 +128,20 @@ func (m *Message) MarshalJSON(extras map[string]interface{}) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		if len(newData) == 2 {
+		if len(newData) <= 2 {
 			newData = extraBytes
 		} else {
 			newData[len(newData)-1] = ','
 			newData = append(newData, extraBytes[1:]...)
 		}
 	}
-
-	return json.Marshal(&Message{
-		Op:   m.Op,
-		Meta: m.Meta,
-		Data: newData,
-	})
+	return newData, nil
 }
 
+// Equal reports whether both messages carry the same state of the same resource, regardless of the op.
 func (m *Message) Equal(other *Message) bool {
-	if m.Op != other.Op || !reflect.DeepEqual(m.Meta, other.Meta) || (len(m.Data) == 0 || len(other.Data) == 0) {
+	if m.Meta == nil || other.Meta == nil || !m.Meta.sameResource(other.Meta) ||
+		(len(m.Data) == 0 || len(other.Data) == 0) {
 		return false
 	}
 
@@ -0,0 +1,424 @@
+package msg
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+var webhookLogger = ctrl.Log.WithName("webhook")
+
+const (
+	// WebhookSignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of
+	// "<X-Gateway-Timestamp>.<body>" keyed with the signing key
+	WebhookSignatureHeader = "X-Gateway-Signature"
+	// WebhookTimestampHeader is the unix time of the request in seconds
+	WebhookTimestampHeader = "X-Gateway-Timestamp"
+
+	cloudEventsBatchContentType = "application/cloudevents-batch+json"
+	webhookQueueSize            = 1024
+
+	defaultWebhookTimeout    = time.Second * 10
+	defaultWebhookMaxRetries = 3
+	defaultWebhookMinBackoff = time.Millisecond * 500
+	defaultWebhookMaxBackoff = time.Second * 30
+	defaultWebhookBatchWait  = time.Second
+)
+
+// WebhookMsgHandler POSTs the messages to an HTTP endpoint. Publish only queues the message, a
+// single worker delivers them in order, retries and hands the failed ones to the dead letter sink.
+type WebhookMsgHandler struct {
+	url        string
+	client     *http.Client
+	headers    map[string]string
+	signingKey []byte
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	batchSize  int
+	batchWait  time.Duration
+	deadLetter deadLetterSink
+	envelope   *Envelope
+
+	mtx    sync.Mutex
+	closed bool
+	queue  chan *Encoded
+	// stop aborts the backoffs once the handler is closed
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewWebhookMsgHandler reads the headers and the signing key from the Secrets in namespace.
+func NewWebhookMsgHandler(spec *monitorv1alpha1.WebhookBackendSpec, reader client.Reader, namespace string) (*WebhookMsgHandler, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWebhookTimeout)
+	defer cancel()
+	headers := make(map[string]string)
+	if spec.HeadersSecret != "" {
+		secret := &corev1.Secret{}
+		if err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: spec.HeadersSecret}, secret); err != nil {
+			return nil, err
+		}
+		for name, value := range secret.Data {
+			headers[name] = string(value)
+		}
+	}
+	var signingKey []byte
+	if spec.SigningKey != nil {
+		var err error
+		if signingKey, err = utils.SecretValue(ctx, reader, namespace, spec.SigningKey); err != nil {
+			return nil, err
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if spec.TLSConfig != nil {
+		tlsConfig, err := utils.TLSClientConfig(ctx, reader, namespace, spec.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return newWebhookMsgHandler(spec, transport, headers, signingKey), nil
+}
+
+func newWebhookMsgHandler(spec *monitorv1alpha1.WebhookBackendSpec, transport http.RoundTripper, headers map[string]string, signingKey []byte) *WebhookMsgHandler {
+	h := &WebhookMsgHandler{
+		url:        spec.URL,
+		client:     &http.Client{Transport: transport, Timeout: defaultWebhookTimeout},
+		headers:    headers,
+		signingKey: signingKey,
+		maxRetries: defaultWebhookMaxRetries,
+		minBackoff: defaultWebhookMinBackoff,
+		maxBackoff: defaultWebhookMaxBackoff,
+		batchSize:  1,
+		envelope:   NewEnvelope(spec.Envelope, spec.Encoding, true),
+		queue:      make(chan *Encoded, webhookQueueSize),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		h.client.Timeout = spec.Timeout.Duration
+	}
+	if spec.MaxRetries != nil && *spec.MaxRetries >= 0 {
+		h.maxRetries = *spec.MaxRetries
+	}
+	if spec.MinBackoff != nil && spec.MinBackoff.Duration > 0 {
+		h.minBackoff = spec.MinBackoff.Duration
+	}
+	if spec.MaxBackoff != nil && spec.MaxBackoff.Duration > 0 {
+		h.maxBackoff = spec.MaxBackoff.Duration
+	}
+	if batch := spec.Batch; batch != nil && batch.MaxSize > 1 {
+		h.batchSize = batch.MaxSize
+		h.batchWait = defaultWebhookBatchWait
+		if batch.MaxWait != nil && batch.MaxWait.Duration > 0 {
+			h.batchWait = batch.MaxWait.Duration
+		}
+	}
+	if deadLetter := spec.DeadLetter; deadLetter != nil {
+		switch {
+		case deadLetter.URL != "":
+			h.deadLetter = &httpDeadLetterSink{url: deadLetter.URL, client: h.client}
+		case deadLetter.Path != "":
+			h.deadLetter = &fileDeadLetterSink{path: deadLetter.Path}
+		}
+	}
+	go h.run()
+	return h
+}
+
+// Publish queues msg, a full queue sends it to the dead letter sink.
+func (h *WebhookMsgHandler) Publish(msg *Message) error {
+	encoded, err := h.envelope.Encode(msg)
+	if err != nil {
+		webhookLogger.Error(err, "Encode message failed")
+		return err
+	}
+	encoded.dropped = msg.dropped
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.closed {
+		return fmt.Errorf("webhook backend %s is closed", h.url)
+	}
+	select {
+	case h.queue <- encoded:
+		return nil
+	default:
+		err := fmt.Errorf("webhook queue is full")
+		h.dropped([]*Encoded{encoded}, 0, err)
+		return err
+	}
+}
+
+// Close delivers the queued messages, each with a single attempt.
+func (h *WebhookMsgHandler) Close() error {
+	h.mtx.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.stop)
+		close(h.queue)
+	}
+	h.mtx.Unlock()
+	select {
+	case <-h.done:
+		return nil
+	case <-time.After(h.client.Timeout * 2):
+		return fmt.Errorf("webhook messages to %s still pending", h.url)
+	}
+}
+
+func (h *WebhookMsgHandler) run() {
+	defer close(h.done)
+	for encoded := range h.queue {
+		batch := []*Encoded{encoded}
+		if h.batchSize > 1 && batchable(encoded) {
+			batch = h.collect(batch)
+		}
+		if len(batch) > 0 {
+			h.deliver(batch)
+		}
+	}
+}
+
+// collect adds the messages arriving within the batch window, a message which can't be batched
+// flushes the batch and is sent alone.
+func (h *WebhookMsgHandler) collect(batch []*Encoded) []*Encoded {
+	timer := time.NewTimer(h.batchWait)
+	defer timer.Stop()
+	for len(batch) < h.batchSize {
+		select {
+		case encoded, ok := <-h.queue:
+			if !ok {
+				return batch
+			}
+			if !batchable(encoded) {
+				h.deliver(batch)
+				h.deliver([]*Encoded{encoded})
+				return nil
+			}
+			batch = append(batch, encoded)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// batchable tells the JSON messages without binary CloudEvents headers.
+func batchable(encoded *Encoded) bool {
+	return len(encoded.Attributes) == 0 && encoded.ContentEncoding == "" && json.Valid(encoded.Body)
+}
+
+func (h *WebhookMsgHandler) deliver(batch []*Encoded) {
+	for attempt := 0; ; attempt++ {
+		status, retryAfter, err := h.post(batch)
+		if err == nil {
+			return
+		}
+		retryable := status == 0 || status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt >= h.maxRetries {
+			h.givenUp(batch, status, err)
+			return
+		}
+		delay := h.minBackoff << uint(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		// the worker is shared by the monitors publishing to the URL, a long Retry-After would stall them all
+		if delay > h.maxBackoff || delay <= 0 {
+			delay = h.maxBackoff
+		}
+		webhookLogger.V(1).Info("Retry webhook request", "url", h.url, "status", status, "delay", delay, "error", err.Error())
+		select {
+		case <-time.After(delay):
+		case <-h.stop:
+			h.givenUp(batch, status, err)
+			return
+		}
+	}
+}
+
+// givenUp drops a batch Publish had accepted, the stores of its messages count the failure.
+func (h *WebhookMsgHandler) givenUp(batch []*Encoded, status int, err error) {
+	h.dropped(batch, status, err)
+	for _, encoded := range batch {
+		if encoded.dropped != nil {
+			encoded.dropped()
+		}
+	}
+}
+
+// post sends the batch, status is 0 if no response was received.
+func (h *WebhookMsgHandler) post(batch []*Encoded) (status int, retryAfter time.Duration, err error) {
+	first := batch[0]
+	body, contentType := first.Body, first.ContentType
+	if len(batch) > 1 {
+		bodies := make([]json.RawMessage, 0, len(batch))
+		contentType = cloudEventsBatchContentType
+		for _, encoded := range batch {
+			bodies = append(bodies, encoded.Body)
+			if encoded.ContentType != cloudEventsContentType {
+				contentType = jsonContentType
+			}
+		}
+		if body, err = json.Marshal(bodies); err != nil {
+			return 0, 0, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	for name, value := range h.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if len(batch) == 1 {
+		if first.ContentEncoding != "" {
+			req.Header.Set("Content-Encoding", first.ContentEncoding)
+		}
+		for name, value := range first.Attributes {
+			req.Header.Set("ce-"+name, value)
+		}
+	}
+	if h.signingKey != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(h.signingKey, timestamp, body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, 0, nil
+	}
+	return resp.StatusCode, parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("webhook responded %s", resp.Status)
+}
+
+func signWebhook(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseRetryAfter reads the delay in seconds or the HTTP date of a Retry-After header.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func (h *WebhookMsgHandler) dropped(batch []*Encoded, status int, err error) {
+	if h.deadLetter == nil {
+		webhookLogger.Error(err, "Message dropped", "url", h.url, "status", status, "count", len(batch))
+		return
+	}
+	for _, encoded := range batch {
+		letter := &DeadLetter{
+			Timestamp:       time.Now(),
+			URL:             h.url,
+			StatusCode:      status,
+			Error:           err.Error(),
+			ContentType:     encoded.ContentType,
+			ContentEncoding: encoded.ContentEncoding,
+			Attributes:      encoded.Attributes,
+			Body:            encoded.Body,
+		}
+		if err := h.deadLetter.write(letter); err != nil {
+			webhookLogger.Error(err, "Write dead letter failed", "url", h.url)
+		}
+	}
+}
+
+// DeadLetter is a message which couldn't be delivered, the body is base64 in JSON.
+type DeadLetter struct {
+	Timestamp       time.Time         `json:"timestamp"`
+	URL             string            `json:"url"`
+	StatusCode      int               `json:"status_code,omitempty"`
+	Error           string            `json:"error"`
+	ContentType     string            `json:"content_type"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Attributes      map[string]string `json:"attributes,omitempty"`
+	Body            []byte            `json:"body"`
+}
+
+type deadLetterSink interface {
+	write(letter *DeadLetter) error
+}
+
+// httpDeadLetterSink POSTs each dead letter once.
+type httpDeadLetterSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpDeadLetterSink) write(letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, jsonContentType, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("dead letter sink responded %s", resp.Status)
+	}
+	return nil
+}
+
+// fileDeadLetterSink appends the dead letters to a file as JSON lines.
+type fileDeadLetterSink struct {
+	path string
+	mtx  sync.Mutex
+}
+
+func (s *fileDeadLetterSink) write(letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
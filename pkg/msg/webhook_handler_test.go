@@ -0,0 +1,245 @@
+package msg
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+type webhookServer struct {
+	*httptest.Server
+	mtx      sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	// statuses are returned in order, 200 once exhausted
+	statuses []int
+	// retryAfter is sent with the failures when set
+	retryAfter string
+}
+
+func newWebhookServer(statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		if status != http.StatusOK && s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *webhookServer) count() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.requests)
+}
+
+func newTestMessage(name string) *Message {
+	return &Message{
+		Op:   NewResource,
+		Meta: &ResourceMeta{SchemaID: "v1/Pod", Namespace: "default", Name: name},
+		Data: []byte(`{"status":{"phase":"Running"}}`),
+	}
+}
+
+func TestWebhookMsgHandler_Sign(t *testing.T) {
+	server := newWebhookServer()
+	defer server.Close()
+	handler := newWebhookMsgHandler(&monitorv1alpha1.WebhookBackendSpec{URL: server.URL}, http.DefaultTransport,
+		map[string]string{"Authorization": "Bearer token"}, []byte("secret"))
+	if err := handler.Publish(newTestMessage("web")); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.count() != 1 {
+		t.Fatalf("requests = %d", server.count())
+	}
+	req, body := server.requests[0], server.bodies[0]
+	if req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("Content-Type") != jsonContentType {
+		t.Errorf("headers = %v", req.Header)
+	}
+	signature := "sha256=" + signWebhook([]byte("secret"), req.Header.Get(WebhookTimestampHeader), body)
+	if req.Header.Get(WebhookSignatureHeader) != signature {
+		t.Errorf("signature = %s, want %s", req.Header.Get(WebhookSignatureHeader), signature)
+	}
+	if err := handler.Publish(newTestMessage("web")); err == nil {
+		t.Errorf("Publish after Close succeeded")
+	}
+}
+
+func TestWebhookMsgHandler_Retry(t *testing.T) {
+	server := newWebhookServer(http.StatusServiceUnavailable, http.StatusBadGateway)
+	defer server.Close()
+	handler := newWebhookMsgHandler(&monitorv1alpha1.WebhookBackendSpec{
+		URL:        server.URL,
+		MinBackoff: &metav1.Duration{Duration: time.Millisecond},
+		Envelope:   &monitorv1alpha1.EnvelopeSpec{Format: monitorv1alpha1.CloudEventsEnvelope, Mode: monitorv1alpha1.BinaryMode},
+	}, http.DefaultTransport, nil, nil)
+	if err := handler.Publish(newTestMessage("web")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && server.count() < 3; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	handler.Close()
+
+	if server.count() != 3 {
+		t.Fatalf("requests = %d, want 3", server.count())
+	}
+	req := server.requests[2]
+	if req.Header.Get("ce-type") != "io.fusion-app.pod.new" || req.Header.Get("ce-subject") != "default/web" {
+		t.Errorf("headers = %v", req.Header)
+	}
+	if string(server.bodies[2]) != `{"status":{"phase":"Running"}}` {
+		t.Errorf("body = %s", server.bodies[2])
+	}
+}
+
+func TestWebhookMsgHandler_DeadLetter(t *testing.T) {
+	server := newWebhookServer(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusBadRequest)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	maxRetries := 1
+	handler := newWebhookMsgHandler(&monitorv1alpha1.WebhookBackendSpec{
+		URL:        server.URL,
+		MaxRetries: &maxRetries,
+		MinBackoff: &metav1.Duration{Duration: time.Millisecond},
+		DeadLetter: &monitorv1alpha1.DeadLetterSpec{Path: path},
+	}, http.DefaultTransport, nil, nil)
+	// the first one fails twice, the second one isn't retried after a 400
+	handler.Publish(newTestMessage("a"))
+	handler.Publish(newTestMessage("b"))
+	for i := 0; i < 100 && server.count() < 3; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	handler.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var letters []*DeadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		letter := &DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), letter); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, letter)
+	}
+	if server.count() != 3 || len(letters) != 2 {
+		t.Fatalf("requests = %d, dead letters = %d", server.count(), len(letters))
+	}
+	if letters[0].StatusCode != http.StatusInternalServerError || letters[1].StatusCode != http.StatusBadRequest {
+		t.Errorf("status codes = %d, %d", letters[0].StatusCode, letters[1].StatusCode)
+	}
+	encoded := &rawJSONMessage{}
+	if err := json.Unmarshal(letters[1].Body, encoded); err != nil || encoded.Meta.Name != "b" {
+		t.Errorf("dead letter body = %s", letters[1].Body)
+	}
+}
+
+func TestWebhookMsgHandler_Batch(t *testing.T) {
+	server := newWebhookServer()
+	defer server.Close()
+	handler := newWebhookMsgHandler(&monitorv1alpha1.WebhookBackendSpec{
+		URL:      server.URL,
+		Batch:    &monitorv1alpha1.WebhookBatchSpec{MaxSize: 3, MaxWait: &metav1.Duration{Duration: time.Second}},
+		Envelope: &monitorv1alpha1.EnvelopeSpec{Format: monitorv1alpha1.CloudEventsEnvelope},
+	}, http.DefaultTransport, nil, nil)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		handler.Publish(newTestMessage(name))
+	}
+	handler.Close()
+
+	if server.count() != 2 {
+		t.Fatalf("requests = %d, want 2", server.count())
+	}
+	if contentType := server.requests[0].Header.Get("Content-Type"); contentType != cloudEventsBatchContentType {
+		t.Errorf("Content-Type = %s", contentType)
+	}
+	var events []map[string]interface{}
+	if err := json.Unmarshal(server.bodies[0], &events); err != nil || len(events) != 3 {
+		t.Fatalf("batch = %s", server.bodies[0])
+	}
+	if events[2]["subject"] != "default/c" {
+		t.Errorf("event = %v", events[2])
+	}
+	if server.requests[1].Header.Get("Content-Type") != cloudEventsContentType {
+		t.Errorf("the last event isn't sent alone: %s", server.bodies[1])
+	}
+}
+
+func TestWebhookMsgHandler_RetryAfterCapped(t *testing.T) {
+	server := newWebhookServer(http.StatusTooManyRequests)
+	server.retryAfter = "86400"
+	defer server.Close()
+	handler := newWebhookMsgHandler(&monitorv1alpha1.WebhookBackendSpec{
+		URL:        server.URL,
+		MaxBackoff: &metav1.Duration{Duration: time.Millisecond * 50},
+	}, http.DefaultTransport, nil, nil)
+	defer handler.Close()
+	if err := handler.Publish(newTestMessage("web")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && server.count() < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if server.count() != 2 {
+		t.Fatalf("retry not sent within the max backoff, requests = %d", server.count())
+	}
+}
+
+func TestWebhookMsgHandler_DroppedCounted(t *testing.T) {
+	server := newWebhookServer(http.StatusInternalServerError, http.StatusBadRequest)
+	defer server.Close()
+	maxRetries := 0
+	handler := newWebhookMsgHandler(&monitorv1alpha1.WebhookBackendSpec{URL: server.URL, MaxRetries: &maxRetries},
+		http.DefaultTransport, nil, nil)
+	store := newTestStore(handler)
+	obj := newTestObject("Running")
+	// the schema is dropped after a 500 and the New message after a 400
+	store.OnResourceAdd(obj, obj)
+	handler.Close()
+
+	if stats := store.Stats(); stats.PublishErrors != 2 {
+		t.Errorf("dropped messages not counted, stats = %+v", stats)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("3"); d != time.Second*3 {
+		t.Errorf("delay = %v", d)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(date); d < time.Second*58 || d > time.Minute {
+		t.Errorf("delay = %v", d)
+	}
+	if d := parseRetryAfter("soon"); d != 0 {
+		t.Errorf("delay = %v", d)
+	}
+}
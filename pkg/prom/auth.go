@@ -0,0 +1,77 @@
+package prom
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+type authRoundTripper struct {
+	bearerToken string
+	username    string
+	password    string
+	next        http.RoundTripper
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.bearerToken == "" && rt.username == "" {
+		return rt.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if rt.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rt.bearerToken)
+	} else {
+		req.SetBasicAuth(rt.username, rt.password)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// newRoundTripper builds the transport used to reach a metric endpoint, resolving credentials
+// and CA bundle from Secrets in the monitor namespace.
+func newRoundTripper(ctx context.Context, reader client.Reader, namespace string, bearerToken *corev1.SecretKeySelector,
+	basicAuth *monitorv1alpha1.BasicAuth, tlsSpec *monitorv1alpha1.TLSConfig) (http.RoundTripper, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	rt := &authRoundTripper{next: transport}
+
+	if tlsSpec != nil {
+		tlsConfig, err := utils.TLSClientConfig(ctx, reader, namespace, tlsSpec)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if bearerToken != nil {
+		token, err := utils.SecretValue(ctx, reader, namespace, bearerToken)
+		if err != nil {
+			return nil, err
+		}
+		rt.bearerToken = strings.TrimSpace(string(token))
+	} else if basicAuth != nil {
+		username, err := utils.SecretValue(ctx, reader, namespace, &basicAuth.Username)
+		if err != nil {
+			return nil, err
+		}
+		password, err := utils.SecretValue(ctx, reader, namespace, &basicAuth.Password)
+		if err != nil {
+			return nil, err
+		}
+		rt.username, rt.password = string(username), string(password)
+	}
+	return rt, nil
+}
@@ -5,26 +5,62 @@ import (
 	"fmt"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/prometheus/client_golang/api"
-	"github.com/prometheus/client_golang/api/prometheus/v1"
-	"github.com/prometheus/common/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
 )
 
+const DefaultInterval = time.Second * 20
+
+// MetricSource polls the metrics of the selected objects and pushes them as MetricResult.
+type MetricSource interface {
+	// AddQuery does nothing when the query of obj exists, so it may be retried on every update
+	AddQuery(obj *unstructured.Unstructured, field, metric string)
+	DeleteQuery(namespace, name, metric string)
+	Start()
+	Stop()
+}
+
+// NewMetricSource builds the MetricSource configured in src, it returns nil when no source is configured.
+func NewMetricSource(parentCtx context.Context, resultCh chan<- *MetricResult, src *monitorv1alpha1.MsgSource,
+	reader client.Reader, namespace string) (MetricSource, error) {
+	switch {
+	case src.PrometheusSource != nil:
+		fetcher, err := newPromFetcher(parentCtx, reader, namespace, src.PrometheusSource)
+		if err != nil {
+			return nil, err
+		}
+		return newMetricWorker(parentCtx, resultCh, fetcher, src.PrometheusSource.Interval), nil
+	case src.ResourceMetricsSource != nil:
+		fetcher := newResourceMetricsFetcher(reader)
+		return newMetricWorker(parentCtx, resultCh, fetcher, src.ResourceMetricsSource.Interval), nil
+	case src.ScrapeSource != nil:
+		fetcher, err := newScrapeFetcher(parentCtx, reader, namespace, src.ScrapeSource)
+		if err != nil {
+			return nil, err
+		}
+		return newMetricWorker(parentCtx, resultCh, fetcher, src.ScrapeSource.Interval), nil
+	}
+	return nil, nil
+}
+
 type MetricQuery struct {
 	Field        string `json:"field"`
 	Metric       string `json:"metric"`
+	Query        string `json:"query"`
 	ResName      string `json:"res_name"`
 	ResNamespace string `json:"res_namespace"`
 }
 
-func newMetricQuery(namespace, name, metric string) string {
-	return fmt.Sprintf("%s{exported_namespace=\"%s\",name=\"%s\"}", metric, namespace, name)
+func queryKey(namespace, name, metric string) string {
+	return fmt.Sprintf("%s/%s/%s", namespace, name, metric)
 }
 
 type MetricResult struct {
@@ -33,35 +69,42 @@ type MetricResult struct {
 	Fields       map[string]interface{} `json:"fields"`
 }
 
+// metricFetcher knows how to reach one kind of metric backend. Queries sharing the same
+// query string are fetched together, so a single request can serve several metrics.
+type metricFetcher interface {
+	newQuery(obj *unstructured.Unstructured, metric string) (string, error)
+	fetch(ctx context.Context, query string, metrics []string) (map[string]float64, error)
+}
+
 type MetricWorker struct {
-	promClient v1.API
-	logger     logr.Logger
+	fetcher  metricFetcher
+	logger   logr.Logger
+	interval time.Duration
+	// key: namespace/name/metric
 	queryStore map[string]*MetricQuery
 
 	cancel    context.CancelFunc
 	ctx       context.Context
 	parentCtx context.Context
 	stopped   chan struct{}
-	resultCh  chan<- *MetricResult
-	mtx       sync.RWMutex
+	// state is workerIdle until Start or Stop claims the worker
+	state    int32
+	resultCh chan<- *MetricResult
+	mtx      sync.RWMutex
 }
 
-func NewMetricWorker(parentCtx context.Context, resultCh chan<- *MetricResult, cfg *monitorv1alpha1.PrometheusDataSource) *MetricWorker {
-	logger := ctrl.Log.WithName("metric")
-
-	client, err := api.NewClient(api.Config{
-		Address: fmt.Sprintf("%s://%s:%d", cfg.Scheme, cfg.Host, cfg.Port),
-	})
-	if err != nil {
-		logger.Error(err, "Creating promClient failed")
-		return nil
+func newMetricWorker(parentCtx context.Context, resultCh chan<- *MetricResult, fetcher metricFetcher, interval *metav1.Duration) *MetricWorker {
+	pollInterval := DefaultInterval
+	if interval != nil && interval.Duration > 0 {
+		pollInterval = interval.Duration
 	}
 
 	cancelContext, cancelFunc := context.WithCancel(parentCtx)
 
 	return &MetricWorker{
-		promClient: v1.NewAPI(client),
-		logger:     logger,
+		fetcher:    fetcher,
+		logger:     ctrl.Log.WithName("metric"),
+		interval:   pollInterval,
 		queryStore: make(map[string]*MetricQuery),
 		cancel:     cancelFunc,
 		ctx:        cancelContext,
@@ -71,31 +114,51 @@ func NewMetricWorker(parentCtx context.Context, resultCh chan<- *MetricResult, c
 	}
 }
 
-func (h *MetricWorker) AddQuery(namespace, name, field, metric string) {
-	query := newMetricQuery(namespace, name, metric)
+func (h *MetricWorker) AddQuery(obj *unstructured.Unstructured, field, metric string) {
+	key := queryKey(obj.GetNamespace(), obj.GetName(), metric)
 	h.mtx.RLock()
-	_, exists := h.queryStore[query]
+	_, exists := h.queryStore[key]
 	h.mtx.RUnlock()
-	if !exists {
-		h.mtx.Lock()
-		defer h.mtx.Unlock()
-		h.queryStore[query] = &MetricQuery{
-			Field:        field,
-			Metric:       metric,
-			ResName:      name,
-			ResNamespace: namespace,
-		}
+	if exists {
+		return
+	}
+	query, err := h.fetcher.newQuery(obj, metric)
+	if err == errNoAddress {
+		h.logger.V(1).Info("Metric query postponed until the object has an address", "namespace", obj.GetNamespace(), "name", obj.GetName())
+		return
+	}
+	if err != nil {
+		h.logger.Error(err, "Build metric query failed", "metric", metric)
+		return
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.queryStore[key] = &MetricQuery{
+		Field:        field,
+		Metric:       metric,
+		Query:        query,
+		ResName:      obj.GetName(),
+		ResNamespace: obj.GetNamespace(),
 	}
 }
 
 func (h *MetricWorker) DeleteQuery(namespace, name, metric string) {
-	query := newMetricQuery(namespace, name, metric)
 	h.mtx.Lock()
 	defer h.mtx.Unlock()
-	delete(h.queryStore, query)
+	delete(h.queryStore, queryKey(namespace, name, metric))
 }
 
-func (h *MetricWorker) Start(interval time.Duration) {
+const (
+	workerIdle int32 = iota
+	workerStarted
+	workerStopped
+)
+
+// Start polls until Stop, it returns right away when Stop was called first.
+func (h *MetricWorker) Start() {
+	if !atomic.CompareAndSwapInt32(&h.state, workerIdle, workerStarted) {
+		return
+	}
 	delay := 100 + rand.Intn(400)
 	select {
 	case <-time.After(time.Duration(delay) * time.Millisecond):
@@ -104,7 +167,7 @@ func (h *MetricWorker) Start(interval time.Duration) {
 		return
 	}
 
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(h.interval)
 	defer ticker.Stop()
 
 mainLoop:
@@ -135,8 +198,12 @@ mainLoop:
 	close(h.stopped)
 }
 
+// Stop waits for the in-flight poll, if the worker ever started.
 func (h *MetricWorker) Stop() {
 	h.cancel()
+	if atomic.CompareAndSwapInt32(&h.state, workerIdle, workerStopped) {
+		return
+	}
 	<-h.stopped
 }
 
@@ -145,35 +212,38 @@ func (h *MetricWorker) doMetric() {
 	defer h.mtx.RUnlock()
 	resultCache := make(map[string]*MetricResult)
 
-	for query, queryObj := range h.queryStore {
-		queryRes, warnings, err := h.promClient.Query(h.parentCtx, query, time.Now())
+	queryGroups := make(map[string][]*MetricQuery)
+	for _, queryObj := range h.queryStore {
+		queryGroups[queryObj.Query] = append(queryGroups[queryObj.Query], queryObj)
+	}
+
+	for query, queryObjs := range queryGroups {
+		metrics := make([]string, 0, len(queryObjs))
+		for _, queryObj := range queryObjs {
+			metrics = append(metrics, queryObj.Metric)
+		}
+		values, err := h.fetcher.fetch(h.parentCtx, query, metrics)
 		if err != nil {
-			h.logger.Error(err, "Querying Prometheus failed")
+			h.logger.Error(err, "Fetching metrics failed", "query", query)
 			continue
 		}
-		if len(warnings) > 0 {
-			h.logger.Info("Querying Prometheus", "warnings", warnings)
-		}
-		var queryVal float64
-		//h.logger.Info("Querying Prometheus", "query", query, "result", queryRes)
-		if queryRes.Type() == model.ValVector {
-			vec := queryRes.(model.Vector)
-			if len(vec) == 0 {
+
+		for _, queryObj := range queryObjs {
+			queryVal, exists := values[queryObj.Metric]
+			if !exists {
 				continue
 			}
-			queryVal = float64(vec[0].Value)
-		}
-
-		resultCacheKey := fmt.Sprintf("%s/%s", queryObj.ResNamespace, queryObj.ResName)
-		if result, exists := resultCache[resultCacheKey]; exists {
-			result.Fields[queryObj.Field] = queryVal
-		} else {
-			fields := make(map[string]interface{})
-			fields[queryObj.Field] = queryVal
-			resultCache[resultCacheKey] = &MetricResult{
-				ResName:      queryObj.ResName,
-				ResNamespace: queryObj.ResNamespace,
-				Fields:       fields,
+			resultCacheKey := fmt.Sprintf("%s/%s", queryObj.ResNamespace, queryObj.ResName)
+			if result, exists := resultCache[resultCacheKey]; exists {
+				result.Fields[queryObj.Field] = queryVal
+			} else {
+				fields := make(map[string]interface{})
+				fields[queryObj.Field] = queryVal
+				resultCache[resultCacheKey] = &MetricResult{
+					ResName:      queryObj.ResName,
+					ResNamespace: queryObj.ResNamespace,
+					Fields:       fields,
+				}
 			}
 		}
 	}
@@ -9,6 +9,9 @@ import (
 	"github.com/prometheus/client_golang/api"
 	"github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
 )
 
 func TestFetchMetrics(t *testing.T) {
@@ -35,4 +38,53 @@ func TestFetchMetrics(t *testing.T) {
 		vec := result.(model.Vector)
 		fmt.Printf("Result:\n%v\n", vec[0].Value)
 	}
-}
\ No newline at end of file
+}
+
+func TestPromFetcherNewQuery(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      "droid-14",
+		},
+		"status": map[string]interface{}{
+			"nodeName": "edge-1",
+		},
+	}}
+	fetcher := &promFetcher{cfg: &monitorv1alpha1.PrometheusDataSource{}}
+	query, err := fetcher.newQuery(obj, "kubevirt_vmi_vcpu_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `kubevirt_vmi_vcpu_seconds{exported_namespace="default",name="droid-14"}`; query != expected {
+		t.Errorf("expected %s, got %s", expected, query)
+	}
+
+	fetcher.cfg = &monitorv1alpha1.PrometheusDataSource{
+		NamespaceLabel: "namespace",
+		NameLabel:      "vmi",
+		LabelMapping:   map[string]string{"node": ".status.nodeName"},
+	}
+	query, err = fetcher.newQuery(obj, "kubevirt_vmi_vcpu_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `kubevirt_vmi_vcpu_seconds{namespace="default",vmi="droid-14",node="edge-1"}`; query != expected {
+		t.Errorf("expected %s, got %s", expected, query)
+	}
+}
+
+func TestMetricWorker_StopBeforeStart(t *testing.T) {
+	worker := newMetricWorker(context.Background(), make(chan *MetricResult), &promFetcher{}, nil)
+	stopped := make(chan struct{})
+	go func() {
+		worker.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 3):
+		t.Fatal("Stop of a worker never started blocked")
+	}
+	// a late Start returns right away
+	worker.Start()
+}
@@ -0,0 +1,100 @@
+package prom
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+const (
+	DefaultNamespaceLabel = "exported_namespace"
+	DefaultNameLabel      = "name"
+)
+
+var promLogger = ctrl.Log.WithName("prometheus")
+
+// promFetcher runs one instant PromQL query per metric.
+type promFetcher struct {
+	promClient v1.API
+	cfg        *monitorv1alpha1.PrometheusDataSource
+}
+
+func newPromFetcher(ctx context.Context, secretReader client.Reader, namespace string, cfg *monitorv1alpha1.PrometheusDataSource) (*promFetcher, error) {
+	roundTripper, err := newRoundTripper(ctx, secretReader, namespace, cfg.BearerToken, cfg.BasicAuth, cfg.TLSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading Prometheus credentials failed: %w", err)
+	}
+	promClient, err := api.NewClient(api.Config{
+		Address:      fmt.Sprintf("%s://%s:%d", cfg.Scheme, cfg.Host, cfg.Port),
+		RoundTripper: roundTripper,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating promClient failed: %w", err)
+	}
+	return &promFetcher{
+		promClient: v1.NewAPI(promClient),
+		cfg:        cfg,
+	}, nil
+}
+
+// newQuery builds the PromQL selector of metric for obj according to the label mapping.
+func (f *promFetcher) newQuery(obj *unstructured.Unstructured, metric string) (string, error) {
+	namespaceLabel, nameLabel := f.cfg.NamespaceLabel, f.cfg.NameLabel
+	if namespaceLabel == "" {
+		namespaceLabel = DefaultNamespaceLabel
+	}
+	if nameLabel == "" {
+		nameLabel = DefaultNameLabel
+	}
+	matchers := []string{
+		fmt.Sprintf("%s=%q", namespaceLabel, obj.GetNamespace()),
+		fmt.Sprintf("%s=%q", nameLabel, obj.GetName()),
+	}
+
+	labels := make([]string, 0, len(f.cfg.LabelMapping))
+	for label := range f.cfg.LabelMapping {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		val, err := utils.JSONPathValue(obj.Object, f.cfg.LabelMapping[label])
+		if err != nil {
+			return "", err
+		}
+		matchers = append(matchers, fmt.Sprintf("%s=%q", label, val))
+	}
+	return fmt.Sprintf("%s{%s}", metric, strings.Join(matchers, ",")), nil
+}
+
+func (f *promFetcher) fetch(ctx context.Context, query string, metrics []string) (map[string]float64, error) {
+	queryRes, warnings, err := f.promClient.Query(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(warnings) > 0 {
+		promLogger.Info("Querying Prometheus", "warnings", warnings)
+	}
+	values := make(map[string]float64)
+	if queryRes.Type() == model.ValVector {
+		vec := queryRes.(model.Vector)
+		if len(vec) == 0 {
+			return values, nil
+		}
+		for _, metric := range metrics {
+			values[metric] = float64(vec[0].Value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,89 @@
+package prom
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ResourceMetricCPU    = "cpu"
+	ResourceMetricMemory = "memory"
+)
+
+var resourceMetricsGV = schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"}
+
+// resourceMetricsFetcher reads PodMetrics and NodeMetrics from the metrics.k8s.io API,
+// the query string is "<Kind>/<namespace>/<name>" of the metrics object.
+type resourceMetricsFetcher struct {
+	reader client.Reader
+}
+
+func newResourceMetricsFetcher(reader client.Reader) *resourceMetricsFetcher {
+	return &resourceMetricsFetcher{reader: reader}
+}
+
+func (f *resourceMetricsFetcher) newQuery(obj *unstructured.Unstructured, metric string) (string, error) {
+	if metric != ResourceMetricCPU && metric != ResourceMetricMemory {
+		return "", fmt.Errorf("unsupported resource metric %s", metric)
+	}
+	switch obj.GetKind() {
+	case "Pod":
+		return fmt.Sprintf("PodMetrics/%s/%s", obj.GetNamespace(), obj.GetName()), nil
+	case "Node":
+		return fmt.Sprintf("NodeMetrics//%s", obj.GetName()), nil
+	}
+	return "", fmt.Errorf("metrics.k8s.io does not serve metrics of %s", obj.GetKind())
+}
+
+func (f *resourceMetricsFetcher) fetch(ctx context.Context, query string, metrics []string) (map[string]float64, error) {
+	parts := strings.SplitN(query, "/", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid resource metrics query %s", query)
+	}
+	metricsObj := &unstructured.Unstructured{}
+	metricsObj.SetGroupVersionKind(resourceMetricsGV.WithKind(parts[0]))
+	if err := f.reader.Get(ctx, client.ObjectKey{Namespace: parts[1], Name: parts[2]}, metricsObj); err != nil {
+		return nil, err
+	}
+
+	var usages []interface{}
+	if parts[0] == "NodeMetrics" {
+		usages = append(usages, metricsObj.Object["usage"])
+	} else {
+		containers, _, _ := unstructured.NestedSlice(metricsObj.Object, "containers")
+		for _, container := range containers {
+			if containerMap, ok := container.(map[string]interface{}); ok {
+				usages = append(usages, containerMap["usage"])
+			}
+		}
+	}
+
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		var total float64
+		for _, usage := range usages {
+			usageMap, ok := usage.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			raw, ok := usageMap[metric].(string)
+			if !ok {
+				continue
+			}
+			quantity, err := resource.ParseQuantity(raw)
+			if err != nil {
+				return nil, err
+			}
+			// cpu is reported in cores and memory in bytes
+			total += float64(quantity.MilliValue()) / 1000
+		}
+		values[metric] = total
+	}
+	return values, nil
+}
@@ -0,0 +1,103 @@
+package prom
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const podMetricsBody = `{
+  "apiVersion": "metrics.k8s.io/v1beta1",
+  "kind": "PodMetrics",
+  "metadata": {"namespace": "default", "name": "web"},
+  "containers": [
+    {"name": "app", "usage": {"cpu": "250m", "memory": "64Mi"}},
+    {"name": "sidecar", "usage": {"cpu": "50m", "memory": "16Mi"}}
+  ]
+}`
+
+const nodeMetricsBody = `{
+  "apiVersion": "metrics.k8s.io/v1beta1",
+  "kind": "NodeMetrics",
+  "metadata": {"name": "edge-1"},
+  "usage": {"cpu": "2", "memory": "1Gi"}
+}`
+
+func newResourceMetricsClient(t *testing.T, url string) client.Client {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.AddSpecific(resourceMetricsGV.WithKind("PodMetrics"), resourceMetricsGV.WithResource("pods"),
+		resourceMetricsGV.WithResource("pod"), meta.RESTScopeNamespace)
+	mapper.AddSpecific(resourceMetricsGV.WithKind("NodeMetrics"), resourceMetricsGV.WithResource("nodes"),
+		resourceMetricsGV.WithResource("node"), meta.RESTScopeRoot)
+	c, err := client.New(&rest.Config{Host: url}, client.Options{Scheme: runtime.NewScheme(), Mapper: mapper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestResourceMetricsFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/metrics.k8s.io/v1beta1/namespaces/default/pods/web":
+			fmt.Fprint(w, podMetricsBody)
+		case "/apis/metrics.k8s.io/v1beta1/nodes/edge-1":
+			fmt.Fprint(w, nodeMetricsBody)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`)
+		}
+	}))
+	defer server.Close()
+	fetcher := newResourceMetricsFetcher(newResourceMetricsClient(t, server.URL))
+
+	pod := &unstructured.Unstructured{}
+	pod.SetKind("Pod")
+	pod.SetNamespace("default")
+	pod.SetName("web")
+	query, err := fetcher.newQuery(pod, ResourceMetricCPU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := fetcher.fetch(context.Background(), query, []string{ResourceMetricCPU, ResourceMetricMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the usages of the containers are summed up
+	if values[ResourceMetricCPU] != 0.3 || values[ResourceMetricMemory] != 80*1024*1024 {
+		t.Errorf("unexpected pod values %v", values)
+	}
+
+	node := &unstructured.Unstructured{}
+	node.SetKind("Node")
+	node.SetName("edge-1")
+	query, err = fetcher.newQuery(node, ResourceMetricMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err = fetcher.fetch(context.Background(), query, []string{ResourceMetricCPU, ResourceMetricMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[ResourceMetricCPU] != 2 || values[ResourceMetricMemory] != 1024*1024*1024 {
+		t.Errorf("unexpected node values %v", values)
+	}
+
+	pod.SetName("gone")
+	query, _ = fetcher.newQuery(pod, ResourceMetricCPU)
+	if _, err := fetcher.fetch(context.Background(), query, []string{ResourceMetricCPU}); err == nil {
+		t.Errorf("expected an error for missing pod metrics")
+	}
+	if _, err := fetcher.newQuery(pod, "network"); err == nil {
+		t.Errorf("expected an error for an unsupported metric")
+	}
+}
@@ -0,0 +1,120 @@
+package prom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/utils"
+)
+
+const DefaultScrapePath = "/metrics"
+
+// errNoAddress is returned until the object is assigned an address, AddQuery is retried on its updates.
+var errNoAddress = errors.New("no address yet")
+
+// defaultAddressPaths locates the address to scrape for well-known kinds.
+var defaultAddressPaths = map[string]string{
+	"Pod":                    "{.status.podIP}",
+	"Service":                "{.spec.clusterIP}",
+	"VirtualMachineInstance": "{.status.interfaces[0].ipAddress}",
+}
+
+// scrapeFetcher scrapes a Prometheus text format endpoint exposed by the selected object,
+// the query string is the URL to scrape.
+type scrapeFetcher struct {
+	httpClient *http.Client
+	cfg        *monitorv1alpha1.ScrapeDataSource
+}
+
+func newScrapeFetcher(ctx context.Context, secretReader client.Reader, namespace string, cfg *monitorv1alpha1.ScrapeDataSource) (*scrapeFetcher, error) {
+	roundTripper, err := newRoundTripper(ctx, secretReader, namespace, cfg.BearerToken, nil, cfg.TLSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading scrape credentials failed: %w", err)
+	}
+	return &scrapeFetcher{
+		httpClient: &http.Client{
+			Transport: roundTripper,
+			Timeout:   time.Second * 10,
+		},
+		cfg: cfg,
+	}, nil
+}
+
+func (f *scrapeFetcher) newQuery(obj *unstructured.Unstructured, metric string) (string, error) {
+	addressPath := f.cfg.Address
+	if addressPath == "" {
+		addressPath = defaultAddressPaths[obj.GetKind()]
+	}
+	if addressPath == "" {
+		return "", fmt.Errorf("no scrape address known for %s", obj.GetKind())
+	}
+	host, err := utils.JSONPathValue(obj.Object, addressPath)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return "", errNoAddress
+	}
+	scheme, path := f.cfg.Scheme, f.cfg.Path
+	if scheme == "" {
+		scheme = "http"
+	}
+	if path == "" {
+		path = DefaultScrapePath
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(f.cfg.Port)), path), nil
+}
+
+// fetch sums up all series of each metric, so labelled series such as per-vcpu counters
+// are reported as one value of the object.
+func (f *scrapeFetcher) fetch(ctx context.Context, query string, metrics []string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(expfmt.FmtText))
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape %s returned %s", query, resp.Status)
+	}
+
+	parser := expfmt.TextParser{}
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		family, exists := families[metric]
+		if !exists {
+			continue
+		}
+		var total float64
+		for _, m := range family.GetMetric() {
+			switch {
+			case m.GetGauge() != nil:
+				total += m.GetGauge().GetValue()
+			case m.GetCounter() != nil:
+				total += m.GetCounter().GetValue()
+			case m.GetUntyped() != nil:
+				total += m.GetUntyped().GetValue()
+			}
+		}
+		values[metric] = total
+	}
+	return values, nil
+}
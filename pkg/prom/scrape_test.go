@@ -0,0 +1,83 @@
+package prom
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+const scrapeBody = `# TYPE kubevirt_vmi_vcpu_seconds counter
+kubevirt_vmi_vcpu_seconds{id="0"} 1.5
+kubevirt_vmi_vcpu_seconds{id="1"} 2.5
+# TYPE memory_available_bytes gauge
+memory_available_bytes 1024
+`
+
+func newScrapePod(ip string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": "Pod",
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      "droid-14",
+		},
+		"status": map[string]interface{}{
+			"podIP": ip,
+		},
+	}}
+}
+
+func TestScrapeFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/custom" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, scrapeBody)
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	fetcher, err := newScrapeFetcher(context.Background(), nil, "default", &monitorv1alpha1.ScrapeDataSource{
+		Port: portNum,
+		Path: "/custom",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultCh := make(chan *MetricResult, 1)
+	worker := newMetricWorker(context.Background(), resultCh, fetcher, nil)
+
+	// a new pod has no IP, its query is built by a later update
+	worker.AddQuery(newScrapePod(""), "cpu", "kubevirt_vmi_vcpu_seconds")
+	if len(worker.queryStore) != 0 {
+		t.Fatalf("expected no query without address, got %d", len(worker.queryStore))
+	}
+	pod := newScrapePod(host)
+	worker.AddQuery(pod, "cpu", "kubevirt_vmi_vcpu_seconds")
+	worker.AddQuery(pod, "memory", "memory_available_bytes")
+	worker.AddQuery(pod, "missing", "not_exported")
+
+	worker.doMetric()
+	result := <-resultCh
+	// the series of a metric are summed up
+	if result.Fields["cpu"] != 4.0 || result.Fields["memory"] != 1024.0 {
+		t.Errorf("unexpected fields %v", result.Fields)
+	}
+	if _, exists := result.Fields["missing"]; exists {
+		t.Errorf("a metric not exported should be skipped")
+	}
+
+	query, _ := fetcher.newQuery(pod, "memory_available_bytes")
+	if _, err := fetcher.fetch(context.Background(), query+"-gone", []string{"memory_available_bytes"}); err == nil {
+		t.Errorf("expected an error of a failed scrape")
+	}
+}
@@ -0,0 +1,256 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	gatewayv1 "github.com/fusion-app/gateway/api/gateway/v1"
+	"github.com/fusion-app/gateway/pkg/msg"
+	"github.com/fusion-app/gateway/pkg/stream"
+)
+
+var rpcLogger = ctrl.Log.WithName("grpc")
+
+const subscriptionBuffer = 256
+
+var (
+	opsToProto = map[msg.ResourceOp]gatewayv1.Op{
+		msg.NewResource:        gatewayv1.Op_OP_NEW,
+		msg.UpdateResource:     gatewayv1.Op_OP_UPDATE,
+		msg.DelResource:        gatewayv1.Op_OP_DELETE,
+		msg.AlertResource:      gatewayv1.Op_OP_ALERT,
+		msg.TransitionResource: gatewayv1.Op_OP_TRANSITION,
+	}
+	opsFromProto = map[gatewayv1.Op]msg.ResourceOp{}
+)
+
+func init() {
+	for op, protoOp := range opsToProto {
+		opsFromProto[protoOp] = op
+	}
+}
+
+// Server implements the Subscription service of api/gateway/v1 on the message stores of the
+// running monitors.
+type Server struct {
+	gatewayv1.UnimplementedSubscriptionServer
+
+	addr       string
+	authorizer stream.Authorizer
+	// lookup returns the store of a running monitor
+	lookup func(namespace, name string) *msg.MessageStore
+}
+
+func NewServer(addr string, authorizer stream.Authorizer) *Server {
+	return &Server{addr: addr, authorizer: authorizer, lookup: msg.LookupStore}
+}
+
+// Start serves until ctx is done.
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	gatewayv1.RegisterSubscriptionServer(server, s)
+	errCh := make(chan error, 1)
+	go func() {
+		rpcLogger.Info("Serving the subscription API", "address", s.addr)
+		errCh <- server.Serve(listener)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		// the subscriptions never end by themselves
+		server.Stop()
+		return nil
+	}
+}
+
+func (s *Server) GetState(ctx context.Context, req *gatewayv1.GetStateRequest) (*gatewayv1.GetStateResponse, error) {
+	store, err := s.store(ctx, req.GetMonitor())
+	if err != nil {
+		return nil, err
+	}
+	snapshot, position, err := store.Snapshot(subscriptionFilter(req.GetFilter()))
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	resp := &gatewayv1.GetStateResponse{ResumeToken: encodeResumeToken(position)}
+	for _, m := range snapshot {
+		resp.Messages = append(resp.Messages, toProto(m))
+	}
+	return resp, nil
+}
+
+func (s *Server) Subscribe(req *gatewayv1.SubscribeRequest, srv gatewayv1.Subscription_SubscribeServer) error {
+	store, err := s.store(srv.Context(), req.GetMonitor())
+	if err != nil {
+		return err
+	}
+	filter := subscriptionFilter(req.GetFilter())
+	var (
+		msgs    []*msg.Message
+		sub     *msg.Subscription
+		resumed bool
+	)
+	if req.GetResumeToken() != "" {
+		from, err := decodeResumeToken(req.GetResumeToken())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		msgs, sub, err = store.Resume(filter, subscriptionBuffer, from)
+		resumed = err == nil
+		if err != nil && err != msg.ErrResumeExpired {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+	}
+	if !resumed {
+		if msgs, sub, err = store.Subscribe(filter, subscriptionBuffer); err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+	}
+	defer store.Unsubscribe(sub)
+
+	for _, m := range msgs {
+		event := &gatewayv1.Event{Event: &gatewayv1.Event_Message{Message: toProto(m)}}
+		if resumed {
+			event.ResumeToken = encodeResumeToken(msg.Position{Epoch: sub.Position.Epoch, Offset: m.Offset()})
+		}
+		if err := srv.Send(event); err != nil {
+			return err
+		}
+	}
+	if err := srv.Send(&gatewayv1.Event{
+		Event:       &gatewayv1.Event_Synced{Synced: &gatewayv1.Synced{Resumed: resumed}},
+		ResumeToken: encodeResumeToken(sub.Position),
+	}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case m, ok := <-sub.C:
+			if !ok {
+				// a lagged subscriber may resume while its position is still replayed
+				return status.Error(codes.Unavailable, sub.Err().Error())
+			}
+			if err := srv.Send(&gatewayv1.Event{
+				Event:       &gatewayv1.Event_Message{Message: toProto(m)},
+				ResumeToken: encodeResumeToken(msg.Position{Epoch: sub.Position.Epoch, Offset: m.Offset()}),
+			}); err != nil {
+				return err
+			}
+		case <-srv.Context().Done():
+			return srv.Context().Err()
+		}
+	}
+}
+
+// store authorizes the caller and returns the store of the monitor.
+func (s *Server) store(ctx context.Context, monitor *gatewayv1.MonitorRef) (*msg.MessageStore, error) {
+	if monitor.GetNamespace() == "" || monitor.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "monitor namespace and name are required")
+	}
+	if err := s.authorizer.Authorize(ctx, bearerToken(ctx), monitor.GetNamespace(), monitor.GetName()); err != nil {
+		var authErr *stream.AuthError
+		if !errors.As(err, &authErr) {
+			rpcLogger.Error(err, "Review access failed", "monitor", monitor.GetNamespace()+"/"+monitor.GetName())
+			return nil, status.Error(codes.Internal, "review access failed")
+		}
+		if authErr.Status == http.StatusUnauthorized {
+			return nil, status.Error(codes.Unauthenticated, authErr.Reason)
+		}
+		return nil, status.Error(codes.PermissionDenied, authErr.Reason)
+	}
+	store := s.lookup(monitor.GetNamespace(), monitor.GetName())
+	if store == nil {
+		return nil, status.Errorf(codes.NotFound, "monitor %s/%s is not running", monitor.GetNamespace(), monitor.GetName())
+	}
+	return store, nil
+}
+
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if strings.HasPrefix(auth, "Bearer ") {
+			return strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	return ""
+}
+
+func subscriptionFilter(filter *gatewayv1.Filter) msg.SubscriptionFilter {
+	subFilter := msg.SubscriptionFilter{Namespace: filter.GetNamespace(), Name: filter.GetName()}
+	for _, op := range filter.GetOps() {
+		if resourceOp, ok := opsFromProto[op]; ok {
+			subFilter.Ops = append(subFilter.Ops, resourceOp)
+		}
+	}
+	return subFilter
+}
+
+func toProto(m *msg.Message) *gatewayv1.Message {
+	protoMsg := &gatewayv1.Message{Op: opsToProto[m.Op], Reason: string(m.Reason)}
+	if len(m.Data) > 0 {
+		data := &structpb.Value{}
+		if err := protojson.Unmarshal(m.Data, data); err != nil {
+			rpcLogger.Error(err, "Decode message data failed", "op", m.Op)
+		} else {
+			protoMsg.Data = data
+		}
+	}
+	if meta := m.Meta; meta != nil {
+		protoMsg.Meta = &gatewayv1.ResourceMeta{
+			SchemaId:         meta.SchemaID,
+			Namespace:        meta.Namespace,
+			Name:             meta.Name,
+			Uid:              string(meta.UID),
+			ResourceVersion:  meta.ResourceVersion,
+			Generation:       meta.Generation,
+			Timestamp:        timestamppb.New(meta.Timestamp),
+			MonitorNamespace: meta.MonitorNamespace,
+			MonitorName:      meta.MonitorName,
+			GatewayId:        meta.GatewayID,
+			Seq:              meta.Seq,
+		}
+	}
+	return protoMsg
+}
+
+// encodeResumeToken hides the position, the clients only pass it back.
+func encodeResumeToken(position msg.Position) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", position.Epoch, position.Offset)))
+}
+
+func decodeResumeToken(token string) (msg.Position, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return msg.Position{}, fmt.Errorf("invalid resume token")
+	}
+	sep := strings.LastIndex(string(data), ":")
+	if sep < 0 {
+		return msg.Position{}, fmt.Errorf("invalid resume token")
+	}
+	offset, err := strconv.ParseUint(string(data[sep+1:]), 10, 64)
+	if err != nil {
+		return msg.Position{}, fmt.Errorf("invalid resume token")
+	}
+	return msg.Position{Epoch: string(data[:sep]), Offset: offset}, nil
+}
@@ -0,0 +1,187 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	gatewayv1 "github.com/fusion-app/gateway/api/gateway/v1"
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/msg"
+	"github.com/fusion-app/gateway/pkg/stream"
+)
+
+type tokenAuthorizer string
+
+func (a tokenAuthorizer) Authorize(ctx context.Context, token, namespace, name string) error {
+	if token != string(a) {
+		return &stream.AuthError{Status: http.StatusForbidden, Reason: "forbidden"}
+	}
+	return nil
+}
+
+func newTestPod(name, phase string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"namespace": "default", "name": name},
+		"status":     map[string]interface{}{"phase": phase},
+	}}
+}
+
+// newTestClient serves the store of the monitor default/pods over an in-memory connection.
+func newTestClient(t *testing.T) (gatewayv1.SubscriptionClient, *msg.MessageStore, func()) {
+	store := msg.NewMsgStore(&monitorv1alpha1.ResourceMonitor{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"},
+	}, nil)
+	pod := newTestPod("web", "Running")
+	store.OnResourceAdd(pod, pod)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	gatewayv1.RegisterSubscriptionServer(server, NewServer("", tokenAuthorizer("token")))
+	go server.Serve(listener)
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(
+		func(context.Context, string) (net.Conn, error) { return listener.Dial() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gatewayv1.NewSubscriptionClient(conn), store, func() {
+		conn.Close()
+		server.Stop()
+		store.Close()
+	}
+}
+
+func authorized(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestServer_Subscribe(t *testing.T) {
+	client, store, cleanup := newTestClient(t)
+	defer cleanup()
+	monitor := &gatewayv1.MonitorRef{Namespace: "default", Name: "pods"}
+
+	ctx, cancel := context.WithCancel(authorized("token"))
+	stream, err := client.Subscribe(ctx, &gatewayv1.SubscribeRequest{Monitor: monitor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := event.GetMessage(); m.GetOp() != gatewayv1.Op_OP_NEW || m.GetMeta().GetName() != "web" ||
+		m.GetData().GetStructValue().AsMap()["status"] == nil || event.GetResumeToken() != "" {
+		t.Fatalf("event = %v", event)
+	}
+	if event, err = stream.Recv(); err != nil || event.GetSynced() == nil || event.GetSynced().GetResumed() {
+		t.Fatalf("event = %v, err = %v", event, err)
+	}
+	synced := event.GetResumeToken()
+
+	pod := newTestPod("web", "Succeeded")
+	store.OnResourceUpdate(pod, pod)
+	if event, err = stream.Recv(); err != nil || event.GetMessage().GetOp() != gatewayv1.Op_OP_UPDATE {
+		t.Fatalf("event = %v, err = %v", event, err)
+	}
+	cancel()
+
+	// missed while disconnected
+	pod = newTestPod("web", "Failed")
+	store.OnResourceUpdate(pod, pod)
+	stream, err = client.Subscribe(authorized("token"), &gatewayv1.SubscribeRequest{Monitor: monitor, ResumeToken: synced})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []gatewayv1.Op
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.GetSynced() != nil {
+			if !event.GetSynced().GetResumed() {
+				t.Errorf("not resumed")
+			}
+			break
+		}
+		ops = append(ops, event.GetMessage().GetOp())
+	}
+	if len(ops) != 2 || ops[0] != gatewayv1.Op_OP_UPDATE || ops[1] != gatewayv1.Op_OP_UPDATE {
+		t.Errorf("replayed = %v", ops)
+	}
+}
+
+func TestServer_GetState(t *testing.T) {
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	resp, err := client.GetState(authorized("token"), &gatewayv1.GetStateRequest{
+		Monitor: &gatewayv1.MonitorRef{Namespace: "default", Name: "pods"},
+		Filter:  &gatewayv1.Filter{Name: "web"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetMessages()) != 1 || resp.GetResumeToken() == "" {
+		t.Errorf("response = %v", resp)
+	}
+
+	for ctx, code := range map[context.Context]codes.Code{
+		authorized("other"):  codes.PermissionDenied,
+		authorized("token"):  codes.NotFound,
+		context.Background(): codes.PermissionDenied,
+	} {
+		_, err := client.GetState(ctx, &gatewayv1.GetStateRequest{Monitor: &gatewayv1.MonitorRef{Namespace: "default", Name: "nodes"}})
+		if status.Code(err) != code {
+			t.Errorf("err = %v, want %s", err, code)
+		}
+	}
+}
+
+func TestServer_GetStateResume(t *testing.T) {
+	client, store, cleanup := newTestClient(t)
+	defer cleanup()
+	monitor := &gatewayv1.MonitorRef{Namespace: "default", Name: "pods"}
+
+	// nobody subscribed yet
+	state, err := client.GetState(authorized("token"), &gatewayv1.GetStateRequest{Monitor: monitor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := newTestPod("web", "Succeeded")
+	store.OnResourceUpdate(pod, pod)
+
+	stream, err := client.Subscribe(authorized("token"), &gatewayv1.SubscribeRequest{Monitor: monitor, ResumeToken: state.GetResumeToken()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Recv()
+	if err != nil || event.GetMessage().GetOp() != gatewayv1.Op_OP_UPDATE {
+		t.Fatalf("event = %v, err = %v", event, err)
+	}
+	if event, err = stream.Recv(); err != nil || !event.GetSynced().GetResumed() {
+		t.Fatalf("event = %v, err = %v", event, err)
+	}
+}
+
+func TestResumeToken(t *testing.T) {
+	position := msg.Position{Epoch: "a:b", Offset: 42}
+	decoded, err := decodeResumeToken(encodeResumeToken(position))
+	if err != nil || decoded != position {
+		t.Errorf("decoded = %v, err = %v", decoded, err)
+	}
+	if _, err := decodeResumeToken("not a token"); err == nil {
+		t.Errorf("invalid token decoded")
+	}
+}
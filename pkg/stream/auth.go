@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+// Authorizer checks that the bearer of token may read the monitor namespace/name.
+type Authorizer interface {
+	Authorize(ctx context.Context, token, namespace, name string) error
+}
+
+// AuthError is returned when the access is denied, Status is the HTTP status sent to the client.
+type AuthError struct {
+	Status int
+	Reason string
+}
+
+func (e *AuthError) Error() string {
+	return e.Reason
+}
+
+// reviewAuthorizer authenticates the token with a TokenReview and requires get on the monitor
+// through a SubjectAccessReview.
+type reviewAuthorizer struct {
+	client client.Client
+}
+
+func NewReviewAuthorizer(c client.Client) Authorizer {
+	return &reviewAuthorizer{client: c}
+}
+
+func (a *reviewAuthorizer) Authorize(ctx context.Context, token, namespace, name string) error {
+	if token == "" {
+		return &AuthError{Status: http.StatusUnauthorized, Reason: "missing bearer token"}
+	}
+	tokenReview := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := a.client.Create(ctx, tokenReview); err != nil {
+		return err
+	}
+	if !tokenReview.Status.Authenticated {
+		return &AuthError{Status: http.StatusUnauthorized, Reason: "invalid bearer token"}
+	}
+
+	user := tokenReview.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	accessReview := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+		Extra:  extra,
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "get",
+			Group:     monitorv1alpha1.GroupVersion.Group,
+			Resource:  "resourcemonitors",
+			Name:      name,
+		},
+	}}
+	if err := a.client.Create(ctx, accessReview); err != nil {
+		return err
+	}
+	if !accessReview.Status.Allowed {
+		return &AuthError{
+			Status: http.StatusForbidden,
+			Reason: fmt.Sprintf("%s may not get resourcemonitor %s/%s", user.Username, namespace, name),
+		}
+	}
+	return nil
+}
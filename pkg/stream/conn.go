@@ -0,0 +1,115 @@
+package stream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamConn is the transport of a subscription.
+type streamConn interface {
+	// send writes the message body as one frame or event named op
+	send(op string, body []byte) error
+	ping() error
+	// fail tells the client why the stream ends
+	fail(err error)
+	// done is closed once the client is gone
+	done() <-chan struct{}
+	close()
+}
+
+type webSocketConn struct {
+	conn   *websocket.Conn
+	closed chan struct{}
+}
+
+// newWebSocketConn discards the messages of the client, reading only detects the close.
+func newWebSocketConn(conn *websocket.Conn) *webSocketConn {
+	c := &webSocketConn{conn: conn, closed: make(chan struct{})}
+	go func() {
+		defer close(c.closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *webSocketConn) send(op string, body []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, body)
+}
+
+func (c *webSocketConn) ping() error {
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+func (c *webSocketConn) fail(err error) {
+	// 1013 try again later, the client subscribes again for a fresh snapshot
+	message := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error())
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeTimeout))
+}
+
+func (c *webSocketConn) done() <-chan struct{} {
+	return c.closed
+}
+
+func (c *webSocketConn) close() {
+	c.conn.Close()
+}
+
+type eventStreamConn struct {
+	writer  *bufio.Writer
+	flusher http.Flusher
+	closed  <-chan struct{}
+}
+
+func newEventStreamConn(w http.ResponseWriter, r *http.Request) (*eventStreamConn, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming unsupported")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// disables the response buffering of nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &eventStreamConn{writer: bufio.NewWriter(w), flusher: flusher, closed: r.Context().Done()}, nil
+}
+
+// send writes an event, the JSON encoded body holds no line break.
+func (c *eventStreamConn) send(op string, body []byte) error {
+	fmt.Fprintf(c.writer, "event: %s\ndata: %s\n\n", op, body)
+	return c.flush()
+}
+
+func (c *eventStreamConn) ping() error {
+	c.writer.WriteString(": ping\n\n")
+	return c.flush()
+}
+
+func (c *eventStreamConn) fail(err error) {
+	fmt.Fprintf(c.writer, "event: error\ndata: %s\n\n", err)
+	c.flush()
+}
+
+func (c *eventStreamConn) flush() error {
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+func (c *eventStreamConn) done() <-chan struct{} {
+	return c.closed
+}
+
+func (c *eventStreamConn) close() {}
@@ -0,0 +1,205 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/msg"
+)
+
+var streamLogger = ctrl.Log.WithName("stream")
+
+const (
+	// pathPrefix of /monitors/<namespace>/<name>
+	pathPrefix         = "/monitors/"
+	subscriptionBuffer = 256
+	pingInterval       = time.Second * 30
+	writeTimeout       = time.Second * 10
+	authTimeout        = time.Second * 10
+)
+
+// syncedBody marks the end of the snapshot, the live messages follow.
+var syncedBody = []byte(`{"op":"Synced"}`)
+
+// Server streams the messages of a monitor to browsers over WebSocket, or Server-Sent Events
+// otherwise. A client subscribes with GET /monitors/<namespace>/<name> and the optional query
+// parameters namespace, name and op (repeated or comma separated) selecting the resources, and
+// envelope=cloudevents. The current state of the resources is sent first as New messages, then
+// {"op":"Synced"}, then the live messages.
+//
+// The bearer token is taken from the Authorization header, or from the access_token parameter
+// since browsers can't set headers on WebSocket and EventSource requests.
+type Server struct {
+	addr       string
+	authorizer Authorizer
+	// lookup returns the store of a running monitor
+	lookup   func(namespace, name string) *msg.MessageStore
+	upgrader websocket.Upgrader
+}
+
+func NewServer(addr string, authorizer Authorizer) *Server {
+	return &Server{
+		addr:       addr,
+		authorizer: authorizer,
+		lookup:     msg.LookupStore,
+		upgrader: websocket.Upgrader{
+			// the clients authenticate with tokens, not cookies, so any origin may connect
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// Start serves until ctx is done.
+func (s *Server) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:    s.addr,
+		Handler: s,
+		// the streams, hijacked WebSocket connections included, end with ctx
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		streamLogger.Info("Serving monitor streams", "address", s.addr)
+		errCh <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, pathPrefix), "/")
+	if !strings.HasPrefix(r.URL.Path, pathPrefix) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	namespace, name := parts[0], parts[1]
+
+	ctx, cancel := context.WithTimeout(r.Context(), authTimeout)
+	err := s.authorizer.Authorize(ctx, bearerToken(r), namespace, name)
+	cancel()
+	if err != nil {
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			http.Error(w, authErr.Reason, authErr.Status)
+			return
+		}
+		streamLogger.Error(err, "Review access failed", "monitor", namespace+"/"+name)
+		http.Error(w, "review access failed", http.StatusInternalServerError)
+		return
+	}
+
+	store := s.lookup(namespace, name)
+	if store == nil {
+		http.Error(w, fmt.Sprintf("monitor %s/%s is not running", namespace, name), http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	filter := msg.SubscriptionFilter{Namespace: query.Get("namespace"), Name: query.Get("name")}
+	for _, ops := range query["op"] {
+		for _, op := range strings.Split(ops, ",") {
+			filter.Ops = append(filter.Ops, msg.ResourceOp(op))
+		}
+	}
+	var envelope *msg.Envelope
+	if query.Get("envelope") == "cloudevents" {
+		envelope = msg.NewEnvelope(&monitorv1alpha1.EnvelopeSpec{Format: monitorv1alpha1.CloudEventsEnvelope}, nil, false)
+	} else {
+		envelope = msg.NewEnvelope(nil, nil, false)
+	}
+
+	snapshot, sub, err := store.Subscribe(filter, subscriptionBuffer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer store.Unsubscribe(sub)
+
+	var conn streamConn
+	if websocket.IsWebSocketUpgrade(r) {
+		wsConn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader replied already
+			return
+		}
+		conn = newWebSocketConn(wsConn)
+	} else {
+		if conn, err = newEventStreamConn(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+	}
+	defer conn.close()
+	if err := pump(r.Context(), conn, envelope, snapshot, sub); err != nil {
+		streamLogger.V(1).Info("Subscription ended", "monitor", namespace+"/"+name, "reason", err.Error())
+	}
+}
+
+// pump sends the snapshot and then the live messages until the client or the subscription is
+// gone, the returned error tells why.
+func pump(ctx context.Context, conn streamConn, envelope *msg.Envelope, snapshot []*msg.Message, sub *msg.Subscription) error {
+	send := func(m *msg.Message) error {
+		encoded, err := envelope.Encode(m)
+		if err != nil {
+			streamLogger.Error(err, "Encode message failed")
+			return nil
+		}
+		return conn.send(string(m.Op), encoded.Body)
+	}
+	for _, m := range snapshot {
+		if err := send(m); err != nil {
+			return err
+		}
+	}
+	if err := conn.send("Synced", syncedBody); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case m, ok := <-sub.C:
+			if !ok {
+				conn.fail(sub.Err())
+				return sub.Err()
+			}
+			if err := send(m); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := conn.ping(); err != nil {
+				return err
+			}
+		case <-conn.done():
+			return errors.New("client disconnected")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("access_token")
+}
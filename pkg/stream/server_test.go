@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/msg"
+)
+
+type tokenAuthorizer string
+
+func (a tokenAuthorizer) Authorize(ctx context.Context, token, namespace, name string) error {
+	if token != string(a) {
+		return &AuthError{Status: http.StatusForbidden, Reason: "forbidden"}
+	}
+	return nil
+}
+
+func newTestPod(name, phase string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"namespace": "default", "name": name},
+		"status":     map[string]interface{}{"phase": phase},
+	}}
+}
+
+func newTestServer() (*httptest.Server, *msg.MessageStore) {
+	store := msg.NewMsgStore(&monitorv1alpha1.ResourceMonitor{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"},
+	}, nil)
+	pod := newTestPod("web", "Running")
+	store.OnResourceAdd(pod, pod)
+	return httptest.NewServer(NewServer("", tokenAuthorizer("token"))), store
+}
+
+func TestServer_EventStream(t *testing.T) {
+	server, store := newTestServer()
+	defer server.Close()
+	defer store.Close()
+
+	resp, err := http.Get(server.URL + "/monitors/default/pods?access_token=token&op=New")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, headers = %v", resp.StatusCode, resp.Header)
+	}
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (string, string) {
+		var event, data string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case line == "\n":
+				return event, data
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}
+
+	event, data := readEvent()
+	decoded := &msg.Message{}
+	if err := json.Unmarshal([]byte(data), decoded); err != nil || event != "New" || decoded.Meta.Name != "web" {
+		t.Fatalf("event = %s, data = %s", event, data)
+	}
+	if event, _ := readEvent(); event != "Synced" {
+		t.Fatalf("event = %s, want Synced", event)
+	}
+	// the Update is filtered out
+	succeeded := newTestPod("web", "Succeeded")
+	store.OnResourceUpdate(succeeded, succeeded)
+	pod := newTestPod("api", "Pending")
+	store.OnResourceAdd(pod, pod)
+	event, data = readEvent()
+	if err := json.Unmarshal([]byte(data), decoded); err != nil || event != "New" || decoded.Meta.Name != "api" {
+		t.Errorf("event = %s, data = %s", event, data)
+	}
+}
+
+func TestServer_WebSocket(t *testing.T) {
+	server, store := newTestServer()
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/monitors/default/pods?name=web"
+	header := http.Header{"Authorization": []string{"Bearer token"}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var ops []string
+	for len(ops) < 3 {
+		decoded := &msg.Message{}
+		if err := conn.ReadJSON(decoded); err != nil {
+			t.Fatal(err)
+		}
+		ops = append(ops, string(decoded.Op))
+		if len(ops) == 2 {
+			pod := newTestPod("web", "Succeeded")
+			store.OnResourceUpdate(pod, pod)
+		}
+	}
+	if strings.Join(ops, ",") != "New,Synced,Update" {
+		t.Errorf("ops = %v", ops)
+	}
+
+	store.Close()
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Errorf("close = %v", err)
+	}
+}
+
+func TestServer_Forbidden(t *testing.T) {
+	server, store := newTestServer()
+	defer server.Close()
+	defer store.Close()
+
+	for path, status := range map[string]int{
+		"/monitors/default/pods?access_token=other":  http.StatusForbidden,
+		"/monitors/default/nodes?access_token=token": http.StatusNotFound,
+		"/monitors/default":                          http.StatusNotFound,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: status = %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
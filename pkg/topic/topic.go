@@ -0,0 +1,15 @@
+package topic
+
+import (
+	"strings"
+	"text/template"
+)
+
+// Parse parses a subject, topic or routing key template, the functions lower and upper are
+// available. The same parsing is done by the backends and the validating webhook.
+func Parse(text string) (*template.Template, error) {
+	return template.New("topic").Option("missingkey=zero").Funcs(template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}).Parse(text)
+}
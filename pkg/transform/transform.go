@@ -0,0 +1,80 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/itchyny/gojq"
+)
+
+// Transform renders a message payload from a JSON compatible input.
+type Transform interface {
+	Render(input interface{}) ([]byte, error)
+}
+
+// New compiles a jq program or a Go template, it returns nil when both are empty.
+func New(jq, tmpl string) (Transform, error) {
+	switch {
+	case jq != "" && tmpl != "":
+		return nil, fmt.Errorf("only one of jq and template can be set")
+	case jq != "":
+		query, err := gojq.Parse(jq)
+		if err != nil {
+			return nil, fmt.Errorf("parse jq: %v", err)
+		}
+		code, err := gojq.Compile(query)
+		if err != nil {
+			return nil, fmt.Errorf("compile jq: %v", err)
+		}
+		return &jqTransform{code: code}, nil
+	case tmpl != "":
+		t, err := template.New("payload").Option("missingkey=zero").Funcs(template.FuncMap{
+			"json": toJSON,
+		}).Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("parse template: %v", err)
+		}
+		return &templateTransform{tmpl: t}, nil
+	}
+	return nil, nil
+}
+
+// jqTransform encodes the single output of a jq program as JSON.
+type jqTransform struct {
+	code *gojq.Code
+}
+
+func (t *jqTransform) Render(input interface{}) ([]byte, error) {
+	iter := t.code.Run(input)
+	output, ok := iter.Next()
+	if !ok {
+		return nil, fmt.Errorf("jq produced no output")
+	}
+	if err, ok := output.(error); ok {
+		return nil, err
+	}
+	if _, ok := iter.Next(); ok {
+		return nil, fmt.Errorf("jq produced more than one output")
+	}
+	return json.Marshal(output)
+}
+
+// templateTransform executes a text/template, the json function encodes a value as JSON.
+type templateTransform struct {
+	tmpl *template.Template
+}
+
+func (t *templateTransform) Render(input interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := t.tmpl.Execute(buf, input); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
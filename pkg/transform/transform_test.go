@@ -0,0 +1,42 @@
+package transform
+
+import "testing"
+
+func TestTransform(t *testing.T) {
+	input := map[string]interface{}{
+		"object":  map[string]interface{}{"status": map[string]interface{}{"phase": "Running"}},
+		"metrics": map[string]interface{}{"cpu_use": 0.5},
+	}
+	for _, c := range []struct {
+		jq, tmpl string
+		expected string
+	}{
+		{jq: `{phase: .object.status.phase, cpu: .metrics.cpu_use}`, expected: `{"cpu":0.5,"phase":"Running"}`},
+		{tmpl: `{"phase":{{ json .object.status.phase }},"cpu":{{ .metrics.cpu_use }}}`, expected: `{"phase":"Running","cpu":0.5}`},
+	} {
+		transform, err := New(c.jq, c.tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := transform.Render(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, output)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, c := range []struct{ jq, tmpl string }{
+		{jq: `.object |`},
+		{jq: `undefined_func(1)`},
+		{tmpl: `{{ .object`},
+		{jq: `.`, tmpl: `{{ . }}`},
+	} {
+		if _, err := New(c.jq, c.tmpl); err == nil {
+			t.Errorf("expected jq %q template %q to be rejected", c.jq, c.tmpl)
+		}
+	}
+}
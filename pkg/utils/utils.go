@@ -1,10 +1,23 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/jsonpath"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
 )
 
 var log = ctrl.Log.WithName("utils")
@@ -30,6 +43,69 @@ func ToUnstructured(runtimeObj interface{}) *unstructured.Unstructured {
 	return &unstructured.Unstructured{Object: innerObj}
 }
 
+// UnwrapTombstone returns the last known object of a delete missed by an informer, and whether obj
+// was such a tombstone.
+func UnwrapTombstone(obj interface{}) (interface{}, bool) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj, true
+	}
+	return obj, false
+}
+
 func JSONSchemaID(u *unstructured.Unstructured) string {
 	return fmt.Sprintf("%s/%s", u.GetAPIVersion(), u.GetKind())
-}
\ No newline at end of file
+}
+
+// JSONPathValue evaluates a kubectl style JSONPath against data, the braces and the leading dot
+// are optional.
+func JSONPathValue(data interface{}, path string) (string, error) {
+	if !strings.HasPrefix(path, "{") {
+		if !strings.HasPrefix(path, ".") {
+			path = "." + path
+		}
+		path = fmt.Sprintf("{%s}", path)
+	}
+	parser := jsonpath.New("value").AllowMissingKeys(true)
+	if err := parser.Parse(path); err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := parser.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SecretValue reads the key referenced by selector from a Secret in namespace.
+func SecretValue(ctx context.Context, reader client.Reader, namespace string, selector *corev1.SecretKeySelector) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: selector.Name}, secret); err != nil {
+		return nil, err
+	}
+	data, exists := secret.Data[selector.Key]
+	if !exists {
+		return nil, fmt.Errorf("key %s not found in secret %s/%s", selector.Key, namespace, selector.Name)
+	}
+	return data, nil
+}
+
+// TLSClientConfig builds the client TLS config of spec, the CA bundle is read from a Secret in
+// namespace.
+func TLSClientConfig(ctx context.Context, reader client.Reader, namespace string, spec *monitorv1alpha1.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         spec.ServerName,
+		InsecureSkipVerify: spec.InsecureSkipVerify,
+	}
+	if spec.CA != nil {
+		caData, err := SecretValue(ctx, reader, namespace, spec.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no valid certificate found in secret %s/%s", namespace, spec.CA.Name)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+	"github.com/fusion-app/gateway/pkg/filter"
+	"github.com/fusion-app/gateway/pkg/msg"
+	"github.com/fusion-app/gateway/pkg/topic"
+	"github.com/fusion-app/gateway/pkg/transform"
+)
+
+const ValidatePath = "/validate-fusion-app-io-v1alpha1-resourcemonitor"
+
+var webhookLogger = logf.Log.WithName("resourcemonitor-webhook")
+
+//+kubebuilder:webhook:path=/validate-fusion-app-io-v1alpha1-resourcemonitor,mutating=false,failurePolicy=fail,sideEffects=None,groups=fusion-app.io,resources=resourcemonitors,verbs=create;update,versions=v1alpha1,name=vresourcemonitor.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// ResourceMonitorValidator rejects the monitors the jobs would fail to run, it compiles the
+// expressions and templates the same way the jobs do.
+type ResourceMonitorValidator struct {
+	decoder *admission.Decoder
+}
+
+// SetupWithManager serves the validator on the webhook server of mgr.
+func SetupWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(ValidatePath, &webhook.Admission{Handler: &ResourceMonitorValidator{}})
+	return nil
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (v *ResourceMonitorValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+func (v *ResourceMonitorValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	monitor := &monitorv1alpha1.ResourceMonitor{}
+	if err := v.decoder.Decode(req, monitor); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	webhookLogger.Info("validate", "operation", req.Operation, "name", monitor.Name)
+	if err := Validate(monitor); err != nil {
+		var apiStatus apierrors.APIStatus
+		if errors.As(err, &apiStatus) {
+			status := apiStatus.Status()
+			return admission.Response{AdmissionResponse: admissionv1.AdmissionResponse{Allowed: false, Result: &status}}
+		}
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// Validate returns an Invalid error listing the fields of monitor the job can't use.
+func Validate(monitor *monitorv1alpha1.ResourceMonitor) error {
+	allErrs := validateSpec(&monitor.Spec, field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(monitorv1alpha1.GroupVersion.WithKind("ResourceMonitor").GroupKind(), monitor.Name, allErrs)
+}
+
+// validateSpec compiles the filter, the transform, the ignore paths and the topic templates of spec.
+func validateSpec(spec *monitorv1alpha1.ResourceMonitorSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	builderPath := path.Child("msgBuilder")
+	if filterSpec := spec.MsgBuilder.Filter; filterSpec != nil {
+		// type-checks the expressions, the same compilation is done by the job
+		if _, err := filter.New(filterSpec.Object, filterSpec.Update); err != nil {
+			allErrs = append(allErrs, field.Invalid(builderPath.Child("filter"), filterSpec, err.Error()))
+		}
+	}
+	if transformSpec := spec.MsgBuilder.Transform; transformSpec != nil {
+		if _, err := transform.New(transformSpec.JQ, transformSpec.Template); err != nil {
+			allErrs = append(allErrs, field.Invalid(builderPath.Child("transform"), transformSpec, err.Error()))
+		}
+	}
+	for i, ignorePath := range spec.MsgBuilder.IgnorePaths {
+		if err := msg.ValidateIgnorePath(ignorePath); err != nil {
+			allErrs = append(allErrs, field.Invalid(builderPath.Child("ignorePaths").Index(i), ignorePath, err.Error()))
+		}
+	}
+	allErrs = append(allErrs, validateTopics(&spec.MsgBackendSpec, path)...)
+	return allErrs
+}
+
+// validateTopics parses the topic templates, the backends fail to build with an invalid one.
+func validateTopics(spec *monitorv1alpha1.MsgBackendSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	parse := func(path *field.Path, text string) {
+		if _, err := topic.Parse(text); err != nil {
+			allErrs = append(allErrs, field.Invalid(path, text, err.Error()))
+		}
+	}
+	if backend := spec.MQTTBackend; backend != nil && backend.StateTopic != "" {
+		parse(path.Child("mqttBackend", "stateTopic"), backend.StateTopic)
+	}
+	if backend := spec.NATSBackend; backend != nil {
+		parse(path.Child("natsBackend", "subject"), backend.Subject)
+	}
+	if backend := spec.AMQPBackend; backend != nil {
+		parse(path.Child("amqpBackend", "routingKey"), backend.RoutingKey)
+	}
+	if backend := spec.RedisStreamBackend; backend != nil {
+		parse(path.Child("redisStreamBackend", "stream"), backend.Stream)
+		if backend.LatestHash != "" {
+			parse(path.Child("redisStreamBackend", "latestHash"), backend.LatestHash)
+		}
+	}
+	return allErrs
+}
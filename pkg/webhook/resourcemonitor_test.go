@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	monitorv1alpha1 "github.com/fusion-app/gateway/api/v1alpha1"
+)
+
+func newTestMonitor() *monitorv1alpha1.ResourceMonitor {
+	return &monitorv1alpha1.ResourceMonitor{
+		TypeMeta:   metav1.TypeMeta{APIVersion: monitorv1alpha1.GroupVersion.String(), Kind: "ResourceMonitor"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pods"},
+		Spec: monitorv1alpha1.ResourceMonitorSpec{
+			Selector: monitorv1alpha1.SelectorSpec{
+				GVK:       metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Namespace: "default",
+			},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	monitor := newTestMonitor()
+	monitor.Spec.MsgBuilder.Filter = &monitorv1alpha1.FilterSpec{Object: "object.status.phase != 'Succeeded'"}
+	monitor.Spec.NATSBackend = &monitorv1alpha1.NATSBackendSpec{Subject: "gateway.{{lower .Kind}}.{{.Name}}"}
+	if err := Validate(monitor); err != nil {
+		t.Fatalf("valid monitor rejected: %v", err)
+	}
+
+	monitor.Spec.MsgBuilder.Filter.Object = "object.status.phase +"
+	monitor.Spec.MsgBuilder.Transform = &monitorv1alpha1.TransformSpec{JQ: ".object | {"}
+	monitor.Spec.MsgBuilder.IgnorePaths = []string{"status.conditions[].lastProbeTime", "status.conditions[x].reason"}
+	monitor.Spec.MQTTBackend = &monitorv1alpha1.MQTTBackendSpec{Topic: "gateway", StateTopic: "gateway/{{.Name"}
+	err := Validate(monitor)
+	if err == nil {
+		t.Fatal("invalid monitor accepted")
+	}
+	if strings.Contains(err.Error(), "ignorePaths[0]") {
+		t.Errorf("[] should match every element, got %v", err)
+	}
+	for _, path := range []string{"spec.msgBuilder.filter", "spec.msgBuilder.transform", "spec.msgBuilder.ignorePaths[1]", "spec.mqttBackend.stateTopic"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("expected an error of %s, got %v", path, err)
+		}
+	}
+}
+
+func TestResourceMonitorValidator_Handle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := monitorv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := &ResourceMonitorValidator{}
+	if err := validator.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(monitor *monitorv1alpha1.ResourceMonitor) admission.Request {
+		raw, err := json.Marshal(monitor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+	if resp := validator.Handle(context.Background(), request(newTestMonitor())); !resp.Allowed {
+		t.Errorf("valid monitor denied: %v", resp.Result)
+	}
+
+	monitor := newTestMonitor()
+	monitor.Spec.AMQPBackend = &monitorv1alpha1.AMQPBackendSpec{RoutingKey: "{{.Missing}"}
+	resp := validator.Handle(context.Background(), request(monitor))
+	if resp.Allowed || resp.Result == nil || resp.Result.Reason != metav1.StatusReasonInvalid {
+		t.Errorf("invalid routing key not denied: %+v", resp.Result)
+	}
+}